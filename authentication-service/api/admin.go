@@ -0,0 +1,488 @@
+package api
+
+import (
+	"authentication/data"
+	"authentication/event"
+	"authentication/pkg/authz"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// passwordResetTokenTTL is how long a forced password reset link stays valid
+const passwordResetTokenTTL = 24 * time.Hour
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+const codePasswordResetRequired = "password_reset_required"
+
+// AdminListUsers returns one page of users, optionally filtered with ?search=
+// against email and name.
+func (app *Config) AdminListUsers(w http.ResponseWriter, r *http.Request) {
+	page, perPage := pagination(r)
+	search := r.URL.Query().Get("search")
+
+	users, total, err := app.Models.User.GetAll(search, page, perPage)
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to list users")
+		app.errorJSON(w, errors.New("unable to list users"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d users", total),
+		Data: map[string]any{
+			"users":    users,
+			"page":     page,
+			"per_page": perPage,
+			"total":    total,
+		},
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// AdminGetUser returns one user together with their roles
+func (app *Config) AdminGetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	roles, err := user.Roles()
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to load user roles")
+		app.errorJSON(w, errors.New("unable to load user"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "user " + user.Email,
+		Data: map[string]any{
+			"user":  user,
+			"roles": roles,
+		},
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// AdminUpdateUser changes the names and the active flag of a user. Fields left
+// out of the request are not changed. Deactivating a user terminates their
+// sessions.
+func (app *Config) AdminUpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		FirstName *string `json:"first_name"`
+		LastName  *string `json:"last_name"`
+		Active    *int    `json:"active"`
+	}
+
+	err := app.ReadJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	changes := map[string]any{}
+	if requestPayload.FirstName != nil {
+		user.FirstName = *requestPayload.FirstName
+		changes["first_name"] = user.FirstName
+	}
+	if requestPayload.LastName != nil {
+		user.LastName = *requestPayload.LastName
+		changes["last_name"] = user.LastName
+	}
+	if requestPayload.Active != nil {
+		if *requestPayload.Active != 0 && *requestPayload.Active != 1 {
+			app.errorJSON(w, errors.New("active must be 0 or 1"), http.StatusBadRequest)
+			return
+		}
+		user.Active = *requestPayload.Active
+		changes["active"] = user.Active
+	}
+
+	err = user.Update()
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to update user")
+		app.errorJSON(w, errors.New("unable to update user"), http.StatusInternalServerError)
+		return
+	}
+
+	if requestPayload.Active != nil && user.Active == 0 {
+		err = app.Models.Session.RevokeAllForUser(user.ID)
+		if err != nil {
+			app.Logger.WithError(err).Error("Failed to terminate sessions")
+			app.errorJSON(w, errors.New("unable to terminate sessions"), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	app.audit(r, "user.update", user.ID, changes)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "updated user " + user.Email,
+		Data:    user,
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// AdminForcePasswordReset blocks logins with the user's current password,
+// terminates their sessions and emails them a link to choose a new one.
+func (app *Config) AdminForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := app.Models.PasswordReset.Force(user.ID, passwordResetTokenTTL)
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to force password reset")
+		app.errorJSON(w, errors.New("unable to force password reset"), http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Models.Session.RevokeAllForUser(user.ID)
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to terminate sessions")
+		app.errorJSON(w, errors.New("unable to terminate sessions"), http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, "user.force_password_reset", user.ID, nil)
+
+	link := fmt.Sprintf("%s?token=%s", app.ResetURL, token)
+	err = app.sendMail(r.Context(), user.Email, "Reset your password",
+		fmt.Sprintf("An administrator has asked you to choose a new password. Please visit the following link: %s\n\nThe link expires in 24 hours.", link))
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to send password reset email")
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "password reset required for " + user.Email,
+	}
+
+	app.WriteJSON(w, http.StatusAccepted, payload)
+}
+
+// AdminDeleteUser deletes a user, or with ?mode=anonymize strips their personal
+// data and disables the account while keeping the row.
+func (app *Config) AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+
+	var err error
+	switch mode {
+	case "", "delete":
+		mode = "delete"
+		err = user.Delete()
+	case "anonymize":
+		err = user.Anonymize()
+	default:
+		app.errorJSON(w, errors.New("mode must be delete or anonymize"), http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		app.Logger.WithError(err).Errorf("Failed to %s user", mode)
+		app.errorJSON(w, fmt.Errorf("unable to %s user", mode), http.StatusInternalServerError)
+		return
+	}
+
+	// An anonymized user must not be identifiable from the audit log either
+	var details map[string]any
+	if mode == "delete" {
+		details = map[string]any{"email": user.Email}
+	}
+	app.audit(r, "user."+mode, user.ID, details)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("user %d: %s done", user.ID, mode),
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// AdminUnlockUser clears the failed logins and delays recorded for an account
+func (app *Config) AdminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.unlockLogin(r.Context(), user.Email)
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to unlock user")
+		app.errorJSON(w, errors.New("unable to unlock user"), http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, "user.unlock", user.ID, nil)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "unlocked user " + user.Email,
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// AdminAssignRole gives a user a role
+func (app *Config) AdminAssignRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		Role string `json:"role"`
+	}
+
+	err := app.ReadJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = user.AssignRole(requestPayload.Role)
+	if err != nil {
+		if errors.Is(err, data.ErrRoleNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.Logger.WithError(err).Error("Failed to assign role")
+		app.errorJSON(w, errors.New("unable to assign role"), http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, "user.role_assign", user.ID, map[string]any{"role": requestPayload.Role})
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("assigned role %s to %s", requestPayload.Role, user.Email),
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// AdminRevokeRole removes a role from a user
+func (app *Config) AdminRevokeRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	role := chi.URLParam(r, "role")
+
+	err := user.RevokeRole(role)
+	if err != nil {
+		if errors.Is(err, data.ErrRoleNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.Logger.WithError(err).Error("Failed to revoke role")
+		app.errorJSON(w, errors.New("unable to revoke role"), http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, "user.role_revoke", user.ID, map[string]any{"role": role})
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("revoked role %s from %s", role, user.Email),
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// AdminListRoles returns every role with its permissions
+func (app *Config) AdminListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.Models.Role.GetAll()
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to list roles")
+		app.errorJSON(w, errors.New("unable to list roles"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d roles", len(roles)),
+		Data:    roles,
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// AdminUserAudit returns the most recent admin changes made to a user
+func (app *Config) AdminUserAudit(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid user id"), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := app.Models.AuditLog.GetByTarget(id, maxPageSize)
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to load audit log")
+		app.errorJSON(w, errors.New("unable to load audit log"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d audit entries", len(entries)),
+		Data:    entries,
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// ResetPassword sets a new password using the token from a reset email
+func (app *Config) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	err := app.ReadJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.Password == "" {
+		app.errorJSON(w, errors.New("password is required"), http.StatusBadRequest)
+		return
+	}
+
+	// The token is only used up once the password is accepted, so a refused
+	// password can be replaced with another one from the same link
+	userID, err := app.Models.PasswordReset.Lookup(requestPayload.Token)
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidToken) {
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		app.Logger.WithError(err).Error("Failed to look up password reset token")
+		app.errorJSON(w, errors.New("unable to reset password"), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(userID)
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to load user")
+		app.errorJSON(w, errors.New("unable to reset password"), http.StatusInternalServerError)
+		return
+	}
+
+	if !app.checkPassword(w, requestPayload.Password, user) {
+		return
+	}
+
+	_, err = app.Models.PasswordReset.Redeem(requestPayload.Token, requestPayload.Password)
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidToken) {
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		app.Logger.WithError(err).Error("Failed to reset password")
+		app.errorJSON(w, errors.New("unable to reset password"), http.StatusInternalServerError)
+		return
+	}
+
+	app.Logger.WithField("user_id", userID).Info("Password reset")
+	app.publishEvent(r, event.Event{
+		Type: event.PasswordChanged,
+		Data: fmt.Sprintf("user %d changed their password with a reset link", userID),
+	}, user.Email, user)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Password changed, you can now log in",
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// userFromURL loads the user identified by the {id} URL parameter, writing the
+// error response itself when that is not possible.
+func (app *Config) userFromURL(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid user id"), http.StatusBadRequest)
+		return nil, false
+	}
+
+	user, err := app.Models.User.GetOne(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+			return nil, false
+		}
+		app.Logger.WithError(err).Error("Failed to load user")
+		app.errorJSON(w, errors.New("unable to load user"), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// audit records an admin action made by the caller identified by the access
+// token. A failure to record is logged but does not undo the action.
+func (app *Config) audit(r *http.Request, action string, targetUserID int, details map[string]any) {
+	entry := data.AuditLog{
+		Action:       action,
+		TargetUserID: targetUserID,
+		Details:      details,
+	}
+
+	if claims, ok := authz.FromContext(r.Context()); ok {
+		entry.ActorID, _ = strconv.Atoi(claims.Subject)
+		entry.ActorEmail = claims.Email
+	}
+
+	err := app.Models.AuditLog.Insert(entry)
+	if err != nil {
+		app.Logger.WithError(err).WithField("action", action).Error("Failed to record audit entry")
+	}
+}
+
+// pagination reads ?page= and ?per_page= with sane defaults and limits
+func pagination(r *http.Request) (int, int) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = defaultPageSize
+	}
+	if perPage > maxPageSize {
+		perPage = maxPageSize
+	}
+
+	return page, perPage
+}
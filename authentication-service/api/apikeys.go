@@ -0,0 +1,310 @@
+package api
+
+import (
+	"authentication/data"
+	"authentication/pkg/authz"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// apiKeyHeader is where callers put their API key
+const apiKeyHeader = "X-API-Key"
+
+// serviceNamePattern restricts service identities to DNS label like names,
+// the same names the services have in Kubernetes
+var serviceNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// AdminListAPIKeys returns every API key, or only those of ?service=. Keys are
+// listed by prefix, the keys themselves cannot be recovered.
+func (app *Config) AdminListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.Models.APIKey.GetAll(r.URL.Query().Get("service"))
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to list API keys")
+		app.errorJSON(w, errors.New("unable to list API keys"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d API keys", len(keys)),
+		Data:    keys,
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// AdminCreateAPIKey mints a key for a service. The key is only ever shown in
+// this response.
+func (app *Config) AdminCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Service   string     `json:"service"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	err := app.ReadJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !serviceNamePattern.MatchString(requestPayload.Service) {
+		app.errorJSON(w, errors.New("service must be a lowercase name of letters, digits and dashes"), http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.ExpiresAt != nil && !requestPayload.ExpiresAt.After(time.Now()) {
+		app.errorJSON(w, errors.New("expires_at must be in the future"), http.StatusBadRequest)
+		return
+	}
+
+	if err := app.checkScopes(requestPayload.Scopes); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !app.callerHoldsScopes(w, r, requestPayload.Scopes) {
+		return
+	}
+
+	plainText, key, err := app.Models.APIKey.Insert(requestPayload.Service, requestPayload.Scopes, requestPayload.ExpiresAt)
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to create API key")
+		app.errorJSON(w, errors.New("unable to create API key"), http.StatusInternalServerError)
+		return
+	}
+
+	app.apiKeyLogger(r, key).Info("API key created")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "API key created for " + key.Service + ", store it now, it will not be shown again",
+		Data: map[string]any{
+			"key":     plainText,
+			"api_key": key,
+		},
+	}
+
+	app.WriteJSON(w, http.StatusCreated, payload)
+}
+
+// AdminRotateAPIKey replaces a key with a new one for the same service and
+// scopes. The old key keeps working for ?grace_seconds= (default none), so
+// the service can be redeployed with the new key first.
+func (app *Config) AdminRotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, ok := app.apiKeyFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if key.RevokedAt != nil {
+		app.errorJSON(w, errors.New("API key has been revoked"), http.StatusConflict)
+		return
+	}
+
+	// Rotating hands out a new key with the same scopes
+	if !app.callerHoldsScopes(w, r, key.Scopes) {
+		return
+	}
+
+	var grace time.Duration
+	if value := r.URL.Query().Get("grace_seconds"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			app.errorJSON(w, errors.New("grace_seconds must be a positive number"), http.StatusBadRequest)
+			return
+		}
+		grace = time.Duration(seconds) * time.Second
+	}
+
+	plainText, replacement, err := key.Rotate(grace)
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to rotate API key")
+		app.errorJSON(w, errors.New("unable to rotate API key"), http.StatusInternalServerError)
+		return
+	}
+
+	app.apiKeyLogger(r, key).WithFields(logrus.Fields{
+		"replacement_prefix": replacement.Prefix,
+		"grace":              grace.String(),
+	}).Info("API key rotated")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "API key " + key.Prefix + " replaced, store the new key now, it will not be shown again",
+		Data: map[string]any{
+			"key":     plainText,
+			"api_key": replacement,
+		},
+	}
+
+	app.WriteJSON(w, http.StatusCreated, payload)
+}
+
+// AdminRevokeAPIKey stops a key from being accepted, immediately
+func (app *Config) AdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, ok := app.apiKeyFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := key.Revoke()
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to revoke API key")
+		app.errorJSON(w, errors.New("unable to revoke API key"), http.StatusInternalServerError)
+		return
+	}
+
+	app.apiKeyLogger(r, key).Info("API key revoked")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "revoked API key " + key.Prefix,
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// ValidateAPIKey lets other services authenticate machine traffic. It takes
+// the key from the X-API-Key header and returns the service it belongs to and
+// its scopes. With ?scope= the key must also grant that scope.
+func (app *Config) ValidateAPIKey(w http.ResponseWriter, r *http.Request) {
+	plainText := r.Header.Get(apiKeyHeader)
+	if plainText == "" {
+		app.errorJSON(w, errors.New("missing "+apiKeyHeader+" header"), http.StatusUnauthorized)
+		return
+	}
+
+	key, err := app.Models.APIKey.Validate(plainText)
+	if err != nil {
+		if !errors.Is(err, data.ErrInvalidAPIKey) {
+			app.Logger.WithError(err).Error("Failed to validate API key")
+			app.errorJSON(w, errors.New("unable to validate API key"), http.StatusInternalServerError)
+			return
+		}
+		app.Metrics.ErrorCount.WithLabelValues(r.Method, r.URL.Path).Inc()
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	if scope := r.URL.Query().Get("scope"); scope != "" && !key.HasScope(scope) {
+		app.errorJSON(w, fmt.Errorf("API key does not grant %s", scope), http.StatusForbidden)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "valid API key for " + key.Service,
+		Data: map[string]any{
+			"key_id":     key.ID,
+			"service":    key.Service,
+			"scopes":     key.Scopes,
+			"expires_at": key.ExpiresAt,
+		},
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// checkScopes makes sure every requested scope is a known permission
+func (app *Config) checkScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+
+	known, err := app.Models.Role.AllPermissions()
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to load permissions")
+		return errors.New("unable to check scopes")
+	}
+
+	valid := make(map[string]bool, len(known))
+	for _, permission := range known {
+		valid[permission] = true
+	}
+
+	var unknown []string
+	for _, scope := range scopes {
+		if !valid[scope] {
+			unknown = append(unknown, scope)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown scopes: %s", strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
+
+// callerHoldsScopes makes sure the caller holds every scope it hands out, so
+// that nobody can mint credentials stronger than their own. It answers 403
+// itself when they do not.
+func (app *Config) callerHoldsScopes(w http.ResponseWriter, r *http.Request, scopes []string) bool {
+	claims, ok := authz.FromContext(r.Context())
+	if !ok {
+		app.errorJSON(w, errors.New("authentication required"), http.StatusUnauthorized)
+		return false
+	}
+
+	var missing []string
+	for _, scope := range scopes {
+		if !claims.HasPermission(scope) {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		app.errorJSON(w, fmt.Errorf("cannot grant scopes you do not hold: %s", strings.Join(missing, ", ")), http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// apiKeyFromURL loads the key named by the {id} URL parameter, writing the
+// error response itself when it cannot
+func (app *Config) apiKeyFromURL(w http.ResponseWriter, r *http.Request) (*data.APIKey, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid API key id"), http.StatusBadRequest)
+		return nil, false
+	}
+
+	key, err := app.Models.APIKey.GetOne(id)
+	if err != nil {
+		if errors.Is(err, data.ErrAPIKeyNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return nil, false
+		}
+		app.Logger.WithError(err).Error("Failed to load API key")
+		app.errorJSON(w, errors.New("unable to load API key"), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return key, true
+}
+
+// apiKeyLogger returns a logger describing a key and the admin acting on it.
+// API key changes are logged rather than audited, since the audit trail is
+// kept per user account.
+func (app *Config) apiKeyLogger(r *http.Request, key *data.APIKey) *logrus.Entry {
+	fields := logrus.Fields{
+		"api_key_id": key.ID,
+		"prefix":     key.Prefix,
+		"service":    key.Service,
+	}
+
+	if claims, ok := authz.FromContext(r.Context()); ok {
+		fields["actor_id"] = claims.Subject
+		fields["actor_email"] = claims.Email
+	}
+
+	return app.Logger.WithFields(fields)
+}
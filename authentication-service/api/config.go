@@ -2,23 +2,74 @@ package api
 
 import (
 	"authentication/data"
+	"authentication/event"
+	"authentication/internal/limiter"
+	"authentication/internal/password"
 	"database/sql"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 )
 
+// EventPublisher queues authentication events for delivery without blocking
+type EventPublisher interface {
+	Publish(event.Event)
+}
+
 type Config struct {
 	DB     *sql.DB
 	Models data.Models
 	Logger *logrus.Logger
 	Redis  *redis.Client
 
+	// MailerURL is the mailer-service endpoint used to deliver emails and
+	// VerifyURL is the public link users follow to verify their address.
+	MailerURL string
+	VerifyURL string
+	ResetURL  string
+	MailFrom  string
+
+	// MFAIssuer is the name authenticator apps show next to TOTP codes
+	MFAIssuer string
+
+	// JWTSecret signs issued access tokens, which are valid for TokenTTL
+	JWTSecret []byte
+	TokenTTL  time.Duration
+
+	// PasswordPolicy is checked whenever a user sets a password
+	PasswordPolicy password.Policy
+
+	// Events receives authentication events for RabbitMQ. It may be nil, in
+	// which case events are only logged.
+	Events EventPublisher
+
+	// LoginLimits configures the delays imposed after failed logins, which
+	// are tracked by Limiter
+	LoginLimits LoginLimits
+	Limiter     limiter.Limiter
+
 	Metrics struct {
 		RequestCount       *prometheus.CounterVec
 		RequestLatency     *prometheus.HistogramVec
 		ErrorCount         *prometheus.CounterVec
 		PGConnectionStatus *prometheus.GaugeVec
+
+		// LegacyPasswordHashes is the number of users whose password hash
+		// does not use the configured algorithm and cost yet
+		LegacyPasswordHashes prometheus.Gauge
+
+		// LoginBlocked counts logins refused because of earlier failures, by
+		// the scope that imposed the delay
+		LoginBlocked *prometheus.CounterVec
+
+		// RedisUp is 1 while Redis answers and 0 while login limits fall
+		// back to the memory of each replica
+		RedisUp prometheus.Gauge
+
+		// EventsDropped counts auth events lost because the buffer filled up
+		// while RabbitMQ was unavailable
+		EventsDropped prometheus.Counter
 	}
 }
@@ -1,9 +1,8 @@
 package api
 
 import (
-	"bytes"
+	"authentication/data"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -42,108 +41,130 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	// Add tracing attribute after payload is parsed
 	span.SetAttributes(attribute.String("user.email", requestPayload.Email))
 
-	// --- RATE LIMIT CHECK ---
-	key := "login_attempts:" + requestPayload.Email
-	attempts, _ := app.Redis.Get(ctx, key).Int()
-	if attempts >= 5 {
-		app.Logger.Warnf("Rate limit exceeded for email %s", requestPayload.Email)
-		app.errorJSON(w, errors.New("too many failed login attempts, please wait 15 minutes"), http.StatusTooManyRequests)
+	user, attempt, ok := app.checkLogin(ctx, w, r, logger, requestPayload.Email, requestPayload.Password)
+	if !ok {
+		return
+	}
+
+	// --- Second factor: the attempt counter is only reset once it succeeds ---
+	if user.MFAEnabled {
+		app.recordLogin(r, user.Email, user, data.LoginMFARequired)
+		app.startMFAChallenge(ctx, w, logger, user)
 		return
 	}
+
+	// --- Success: Reset failed attempts ---
+	app.resetLoginFailures(ctx, attempt)
+
+	app.completeLogin(w, r, logger, user, start)
+}
+
+// checkLogin verifies an email and password pair for Authenticate and the
+// MFA enrollment endpoints. Attempts count against the login limits and are
+// recorded in the login history, and the account has to be verified, active
+// and not waiting for a password reset. When it returns false the response has
+// already been written.
+func (app *Config) checkLogin(ctx context.Context, w http.ResponseWriter, r *http.Request, logger *logrus.Entry, email, password string) (*data.User, loginAttempt, bool) {
+	// --- RATE LIMIT CHECK ---
+	attempt := newLoginAttempt(r, email)
+	if delay, reason := app.loginDelay(ctx, attempt); delay > 0 {
+		logger.WithFields(logrus.Fields{"reason": reason, "ip": attempt.ip}).
+			Warnf("Login delayed for email %s", email)
+		app.recordLogin(r, email, nil, data.LoginRateLimited)
+		app.tooManyAttempts(w, delay, reason)
+		return nil, attempt, false
+	}
 	// ------------------------
 
-	user, err := app.Models.User.GetByEmail(requestPayload.Email)
+	user, err := app.Models.User.GetByEmail(email)
 	if err != nil {
-		app.Redis.Incr(ctx, key)
-		app.Redis.Expire(ctx, key, 15*time.Minute)
+		// Spend as long as a real password check would, so the response
+		// time does not reveal whether the email is registered
+		data.DummyPasswordCheck(password)
+
+		app.recordLoginFailure(ctx, attempt)
+		app.recordLogin(r, email, nil, data.LoginInvalidCredentials)
 
 		logger.WithError(err).Warn("Invalid credentials")
-		app.Metrics.ErrorCount.WithLabelValues(r.Method, "/authenticate").Inc()
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
-		return
+		app.Metrics.ErrorCount.WithLabelValues(r.Method, r.URL.Path).Inc()
+		app.errorJSON(w, errInvalidCredentials, http.StatusUnauthorized)
+		return nil, attempt, false
 	}
 
-	valid, err := user.PasswordMatches(requestPayload.Password)
+	valid, err := user.PasswordMatches(password)
 	if err != nil || !valid {
-		app.Redis.Incr(ctx, key)
-		app.Redis.Expire(ctx, key, 15*time.Minute)
+		app.recordLoginFailure(ctx, attempt)
+		app.recordLogin(r, email, user, data.LoginInvalidCredentials)
 
 		logger.Warn("Invalid password")
-		app.Metrics.ErrorCount.WithLabelValues(r.Method, "/authenticate").Inc()
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
-		return
+		app.Metrics.ErrorCount.WithLabelValues(r.Method, r.URL.Path).Inc()
+		app.errorJSON(w, errInvalidCredentials, http.StatusUnauthorized)
+		return nil, attempt, false
 	}
 
-	// --- Success: Reset failed attempts ---
-	app.Redis.Del(ctx, key)
+	// --- Upgrade outdated hashes while the plain text password is at hand ---
+	if user.NeedsRehash() {
+		if err := user.Rehash(password); err != nil {
+			logger.WithError(err).Warn("Failed to upgrade password hash")
+		} else {
+			logger.WithField("user_email", user.Email).Info("Password hash upgraded")
+			app.Metrics.LegacyPasswordHashes.Dec()
+		}
+	}
+
+	// --- Account state: only checked once the password is known to be right ---
+	if !user.Verified {
+		logger.WithField("user_email", user.Email).Warn("Login refused, email not verified")
+		app.recordLogin(r, user.Email, user, data.LoginEmailNotVerified)
+		app.Metrics.ErrorCount.WithLabelValues(r.Method, r.URL.Path).Inc()
+		app.errorCodeJSON(w, codeEmailNotVerified, errors.New("email address has not been verified"), http.StatusForbidden)
+		return nil, attempt, false
+	}
 
-	err = app.logRequest(ctx, "authentication", fmt.Sprintf("%s logged in", user.Email))
+	if user.Active != 1 {
+		logger.WithField("user_email", user.Email).Warn("Login refused, account inactive")
+		app.recordLogin(r, user.Email, user, data.LoginAccountInactive)
+		app.Metrics.ErrorCount.WithLabelValues(r.Method, r.URL.Path).Inc()
+		app.errorCodeJSON(w, codeAccountInactive, errors.New("account is inactive"), http.StatusForbidden)
+		return nil, attempt, false
+	}
+
+	if user.PasswordResetRequired {
+		logger.WithField("user_email", user.Email).Warn("Login refused, password reset required")
+		app.recordLogin(r, user.Email, user, data.LoginPasswordReset)
+		app.Metrics.ErrorCount.WithLabelValues(r.Method, r.URL.Path).Inc()
+		app.errorCodeJSON(w, codePasswordResetRequired, errors.New("password must be reset before logging in"), http.StatusForbidden)
+		return nil, attempt, false
+	}
+
+	return user, attempt, true
+}
+
+// completeLogin issues an access token and sends the logged in user back to
+// the client. It is shared by password logins and MFA challenge logins.
+func (app *Config) completeLogin(w http.ResponseWriter, r *http.Request, logger *logrus.Entry, user *data.User, start time.Time) {
+	token, err := app.issueAccessToken(r, user)
 	if err != nil {
-		logger.WithError(err).Error("Failed to log authentication event")
-		app.Metrics.ErrorCount.WithLabelValues(r.Method, "/authenticate").Inc()
-		app.errorJSON(w, err)
+		logger.WithError(err).Error("Failed to issue access token")
+		app.Metrics.ErrorCount.WithLabelValues(r.Method, r.URL.Path).Inc()
+		app.errorJSON(w, errors.New("unable to issue access token"), http.StatusInternalServerError)
 		return
 	}
 
+	app.recordLogin(r, user.Email, user, data.LoginSuccess)
+
 	logger.WithField("user_email", user.Email).Info("User authenticated")
 
 	payload := jsonResponse{
 		Error:   false,
 		Message: fmt.Sprintf("Logged in user %s", user.Email),
-		Data:    user,
+		Data:    token,
 	}
 
 	app.WriteJSON(w, http.StatusAccepted, payload)
 
 	duration := time.Since(start).Seconds()
-	app.Metrics.RequestCount.WithLabelValues(r.Method, "/authenticate").Inc()
-	app.Metrics.RequestLatency.WithLabelValues(r.Method, "/authenticate").Observe(duration)
+	app.Metrics.RequestCount.WithLabelValues(r.Method, r.URL.Path).Inc()
+	app.Metrics.RequestLatency.WithLabelValues(r.Method, r.URL.Path).Observe(duration)
 	logger.WithField("latency", duration).Info("Request completed")
 }
-
-func (app *Config) logRequest(ctx context.Context, name, data string) error {
-	logger := logrus.WithFields(logrus.Fields{
-		"event": name,
-		"data":  data,
-	})
-
-	logger.Info("Event logged")
-
-	// Structure log data for external logging service
-	var entry struct {
-		Name string `json:"name"`
-		Data string `json:"data"`
-	}
-
-	entry.Name = name
-	entry.Data = data
-
-	jsonData, err := json.MarshalIndent(entry, "", "\t")
-	if err != nil {
-		logger.WithError(err).Error("Failed to marshal log data to JSON")
-		return err
-	}
-
-	logServiceURL := "http://logger-service/log"
-	request, err := http.NewRequest("POST", logServiceURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		logger.WithError(err).Error("Failed to create HTTP request for logger service")
-		return err
-	}
-
-	client := &http.Client{}
-	response, err := client.Do(request)
-	if err != nil {
-		logger.WithError(err).Error("Failed to send log data to logger service")
-		return err
-	}
-	defer response.Body.Close()
-
-	if response.StatusCode >= 400 {
-		err = fmt.Errorf("logger service returned status code %d", response.StatusCode)
-		logger.WithError(err).Error("Logger service error")
-		return err
-	}
-
-	return nil
-}
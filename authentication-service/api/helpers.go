@@ -9,6 +9,7 @@ import (
 
 type jsonResponse struct {
 	Error   bool   `json:"error"`
+	Code    string `json:"code,omitempty"`
 	Message string `json:"message"`
 	Data    any    `json:"data,omitempty"`
 }
@@ -71,3 +72,14 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 
 	return app.WriteJSON(w, statusCode, payload)
 }
+
+// errorCodeJSON works like errorJSON but also includes a machine readable error
+// code, so clients can tell apart failures that share the same status code.
+func (app *Config) errorCodeJSON(w http.ResponseWriter, code string, err error, status int) error {
+	var payload jsonResponse
+	payload.Error = true
+	payload.Code = code
+	payload.Message = err.Error()
+
+	return app.WriteJSON(w, status, payload)
+}
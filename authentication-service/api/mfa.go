@@ -0,0 +1,339 @@
+package api
+
+import (
+	"authentication/data"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+)
+
+// mfaChallengeTTL is how long a user has to complete the second login step
+const mfaChallengeTTL = 5 * time.Minute
+
+const codeMFARequired = "mfa_required"
+
+// totpPeriod is the lifetime of a TOTP code in seconds, the default used by
+// authenticator apps
+const totpPeriod = 30
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// startMFAChallenge is called once a user with MFA enabled has supplied the
+// right password. Instead of logging them in, it hands out a short lived
+// challenge token that has to be exchanged at /mfa/verify together with a code.
+func (app *Config) startMFAChallenge(ctx context.Context, w http.ResponseWriter, logger *logrus.Entry, user *data.User) {
+	token, _, err := data.NewToken()
+	if err != nil {
+		logger.WithError(err).Error("Failed to generate MFA challenge")
+		app.errorJSON(w, errors.New("unable to start MFA challenge"), http.StatusInternalServerError)
+		return
+	}
+
+	// Challenges are kept with the login limits, so they fall back to the
+	// memory of the replica while Redis is down
+	err = app.Limiter.Put(ctx, "mfa_challenge:"+token, strconv.Itoa(user.ID), mfaChallengeTTL)
+	if err != nil {
+		logger.WithError(err).Error("Failed to store MFA challenge")
+		app.errorJSON(w, errors.New("unable to start MFA challenge"), http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithField("user_email", user.Email).Info("MFA challenge issued")
+
+	payload := jsonResponse{
+		Error:   false,
+		Code:    codeMFARequired,
+		Message: "Multi-factor authentication required",
+		Data: map[string]any{
+			"mfa_token":  token,
+			"expires_in": int(mfaChallengeTTL.Seconds()),
+		},
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// VerifyMFA completes a login started by Authenticate for a user with MFA
+// enabled. It accepts either a current TOTP code or an unused recovery code.
+func (app *Config) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	ctx, span := otel.Tracer("authentication-service").Start(r.Context(), "VerifyMFAHandler")
+	defer span.End()
+
+	logger := logrus.WithFields(logrus.Fields{
+		"method":   r.Method,
+		"path":     r.URL.Path,
+		"trace_id": span.SpanContext().TraceID().String(),
+	})
+
+	var requestPayload struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	err := app.ReadJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	challengeKey := "mfa_challenge:" + requestPayload.MFAToken
+	stored, err := app.Limiter.Get(ctx, challengeKey)
+	if err != nil {
+		logger.WithError(err).Error("Failed to load MFA challenge")
+	}
+
+	userID, err := strconv.Atoi(stored)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired MFA challenge"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(userID)
+	if err != nil {
+		logger.WithError(err).Error("Failed to load user for MFA challenge")
+		app.errorJSON(w, errors.New("invalid or expired MFA challenge"), http.StatusUnauthorized)
+		return
+	}
+
+	// MFA failures count against the same limits as password failures, so a
+	// stolen password does not buy unlimited guesses at the second factor
+	attempt := newLoginAttempt(r, user.Email)
+	if delay, reason := app.loginDelay(ctx, attempt); delay > 0 {
+		app.Limiter.Reset(ctx, challengeKey)
+		app.recordLogin(r, user.Email, user, data.LoginRateLimited)
+		app.tooManyAttempts(w, delay, reason)
+		return
+	}
+
+	valid, err := app.checkSecondFactor(user, requestPayload.Code)
+	if err != nil {
+		logger.WithError(err).Error("Failed to check MFA code")
+		app.errorJSON(w, errors.New("unable to verify code"), http.StatusInternalServerError)
+		return
+	}
+
+	if !valid {
+		app.recordLoginFailure(ctx, attempt)
+		app.recordLogin(r, user.Email, user, data.LoginInvalidMFACode)
+
+		logger.WithField("user_email", user.Email).Warn("Invalid MFA code")
+		app.Metrics.ErrorCount.WithLabelValues(r.Method, r.URL.Path).Inc()
+		app.errorJSON(w, errors.New("invalid code"), http.StatusUnauthorized)
+		return
+	}
+
+	app.Limiter.Reset(ctx, challengeKey)
+	app.resetLoginFailures(ctx, attempt)
+
+	app.completeLogin(w, r, logger, user, start)
+}
+
+// checkSecondFactor accepts a six digit TOTP code or, failing that, one of the
+// user's recovery codes, which is consumed on use. A TOTP code is only
+// accepted once, see acceptTOTP.
+func (app *Config) checkSecondFactor(user *data.User, code string) (bool, error) {
+	if len(code) == 6 {
+		if _, err := strconv.Atoi(code); err == nil {
+			return acceptTOTP(user, code)
+		}
+	}
+
+	return user.UseRecoveryCode(code)
+}
+
+// acceptTOTP checks a TOTP code against the user's secret and records its time
+// step, so that a code seen by someone else cannot be used again while it is
+// still valid
+func acceptTOTP(user *data.User, code string) (bool, error) {
+	step, ok := totpStep(code, user.MFASecret, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return user.UseTOTPStep(step)
+}
+
+// totpStep returns the time step a code was generated for. Like totp.Validate
+// it allows one step of clock drift either way.
+func totpStep(code, secret string, now time.Time) (int64, bool) {
+	for _, drift := range []int64{0, -1, 1} {
+		at := now.Add(time.Duration(drift*totpPeriod) * time.Second)
+
+		expected, err := totp.GenerateCode(secret, at)
+		if err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return at.Unix() / totpPeriod, true
+		}
+	}
+
+	return 0, false
+}
+
+// EnrollMFA generates a new TOTP secret for the user and returns it together
+// with an otpauth:// URI and a QR code PNG for authenticator apps. MFA is not
+// enabled until the first code is confirmed with ConfirmMFA.
+func (app *Config) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("authentication-service").Start(r.Context(), "EnrollMFAHandler")
+	defer span.End()
+
+	logger := logrus.WithFields(logrus.Fields{
+		"method":   r.Method,
+		"path":     r.URL.Path,
+		"trace_id": span.SpanContext().TraceID().String(),
+	})
+
+	var requestPayload struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.ReadJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, attempt, ok := app.checkLogin(ctx, w, r, logger, requestPayload.Email, requestPayload.Password)
+	if !ok {
+		return
+	}
+	app.resetLoginFailures(ctx, attempt)
+
+	if user.MFAEnabled {
+		app.errorJSON(w, errors.New("multi-factor authentication is already enabled"), http.StatusConflict)
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      app.MFAIssuer,
+		AccountName: user.Email,
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to generate TOTP secret")
+		app.errorJSON(w, errors.New("unable to enroll"), http.StatusInternalServerError)
+		return
+	}
+
+	img, err := key.Image(256, 256)
+	if err != nil {
+		logger.WithError(err).Error("Failed to render QR code")
+		app.errorJSON(w, errors.New("unable to enroll"), http.StatusInternalServerError)
+		return
+	}
+
+	var qr bytes.Buffer
+	if err := png.Encode(&qr, img); err != nil {
+		logger.WithError(err).Error("Failed to encode QR code")
+		app.errorJSON(w, errors.New("unable to enroll"), http.StatusInternalServerError)
+		return
+	}
+
+	err = user.SetMFASecret(key.Secret())
+	if err != nil {
+		logger.WithError(err).Error("Failed to store TOTP secret")
+		app.errorJSON(w, errors.New("unable to enroll"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Scan the QR code and confirm with the first code to enable MFA",
+		Data: map[string]string{
+			"secret":      key.Secret(),
+			"otpauth_url": key.URL(),
+			"qr_png":      base64.StdEncoding.EncodeToString(qr.Bytes()),
+		},
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// ConfirmMFA enables MFA once the user proves their authenticator app works by
+// sending a valid code. The recovery codes are only ever shown in this response.
+func (app *Config) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("authentication-service").Start(r.Context(), "ConfirmMFAHandler")
+	defer span.End()
+
+	logger := logrus.WithFields(logrus.Fields{
+		"method":   r.Method,
+		"path":     r.URL.Path,
+		"trace_id": span.SpanContext().TraceID().String(),
+	})
+
+	var requestPayload struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	err := app.ReadJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, attempt, ok := app.checkLogin(ctx, w, r, logger, requestPayload.Email, requestPayload.Password)
+	if !ok {
+		return
+	}
+
+	if user.MFAEnabled {
+		app.errorJSON(w, errors.New("multi-factor authentication is already enabled"), http.StatusConflict)
+		return
+	}
+
+	if user.MFASecret == "" {
+		app.errorJSON(w, errors.New("no pending enrollment, call /mfa/enroll first"), http.StatusBadRequest)
+		return
+	}
+
+	valid, err := acceptTOTP(user, requestPayload.Code)
+	if err != nil {
+		logger.WithError(err).Error("Failed to check MFA code")
+		app.errorJSON(w, errors.New("unable to verify code"), http.StatusInternalServerError)
+		return
+	}
+
+	if !valid {
+		app.recordLoginFailure(ctx, attempt)
+		app.recordLogin(r, user.Email, user, data.LoginInvalidMFACode)
+		app.errorJSON(w, errors.New("invalid code"), http.StatusBadRequest)
+		return
+	}
+	app.resetLoginFailures(ctx, attempt)
+
+	codes, err := data.NewRecoveryCodes()
+	if err != nil {
+		logger.WithError(err).Error("Failed to generate recovery codes")
+		app.errorJSON(w, errors.New("unable to enable MFA"), http.StatusInternalServerError)
+		return
+	}
+
+	err = user.EnableMFA(codes)
+	if err != nil {
+		logger.WithError(err).Error("Failed to enable MFA")
+		app.errorJSON(w, errors.New("unable to enable MFA"), http.StatusInternalServerError)
+		return
+	}
+
+	logger.WithField("user_email", user.Email).Info("MFA enabled")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Multi-factor authentication enabled, store the recovery codes somewhere safe",
+		Data:    map[string][]string{"recovery_codes": codes},
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
@@ -0,0 +1,387 @@
+package api
+
+import (
+	"authentication/data"
+	"authentication/pkg/authz"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Error codes of the OAuth2 token endpoint, RFC 6749 section 5.2
+const (
+	oauthInvalidRequest       = "invalid_request"
+	oauthInvalidClient        = "invalid_client"
+	oauthInvalidScope         = "invalid_scope"
+	oauthUnsupportedGrantType = "unsupported_grant_type"
+	oauthServerError          = "server_error"
+)
+
+// oauthTokenResponse is the successful token response of RFC 6749 section 5.1
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// oauthErrorResponse is the error response of RFC 6749 section 5.2
+type oauthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// OAuthToken implements the client credentials grant of RFC 6749 section 4.4.
+// Clients authenticate with HTTP Basic or with client_id and client_secret in
+// the form body, and get an access token in the same format as user logins,
+// granting the requested scopes as permissions.
+func (app *Config) OAuthToken(w http.ResponseWriter, r *http.Request) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "application/x-www-form-urlencoded" {
+		app.oauthError(w, http.StatusBadRequest, oauthInvalidRequest, "request body must be application/x-www-form-urlencoded")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+	if err := r.ParseForm(); err != nil {
+		app.oauthError(w, http.StatusBadRequest, oauthInvalidRequest, "malformed request body")
+		return
+	}
+
+	if grantType := r.PostForm.Get("grant_type"); grantType != "client_credentials" {
+		if grantType == "" {
+			app.oauthError(w, http.StatusBadRequest, oauthInvalidRequest, "missing grant_type")
+			return
+		}
+		app.oauthError(w, http.StatusBadRequest, oauthUnsupportedGrantType, "only the client_credentials grant is supported")
+		return
+	}
+
+	clientID, secret, basic, err := clientCredentials(r)
+	if err != nil {
+		app.oauthError(w, http.StatusBadRequest, oauthInvalidRequest, err.Error())
+		return
+	}
+
+	client, err := app.Models.OAuthClient.Authenticate(clientID, secret)
+	if err != nil {
+		if !errors.Is(err, data.ErrInvalidClient) {
+			app.Logger.WithError(err).Error("Failed to authenticate OAuth client")
+			app.oauthError(w, http.StatusInternalServerError, oauthServerError, "")
+			return
+		}
+
+		app.Logger.WithField("client_id", clientID).Warn("OAuth client authentication failed")
+		app.Metrics.ErrorCount.WithLabelValues(r.Method, r.URL.Path).Inc()
+
+		// A client that tried HTTP Basic gets 401 with a challenge, as
+		// required by section 5.2
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="authentication-service"`)
+			app.oauthError(w, http.StatusUnauthorized, oauthInvalidClient, "client authentication failed")
+			return
+		}
+		app.oauthError(w, http.StatusBadRequest, oauthInvalidClient, "client authentication failed")
+		return
+	}
+
+	scopes, err := grantedScopes(client, r.PostForm.Get("scope"))
+	if err != nil {
+		app.oauthError(w, http.StatusBadRequest, oauthInvalidScope, err.Error())
+		return
+	}
+
+	claims := authz.Claims{
+		Permissions: scopes,
+		ClientID:    client.ClientID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: "client:" + client.ClientID,
+		},
+	}
+
+	token, err := authz.Sign(app.JWTSecret, claims, app.TokenTTL)
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to sign client access token")
+		app.oauthError(w, http.StatusInternalServerError, oauthServerError, "")
+		return
+	}
+
+	app.Logger.WithField("client_id", client.ClientID).Info("Client access token issued")
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+	headers.Set("Pragma", "no-cache")
+
+	app.WriteJSON(w, http.StatusOK, oauthTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(app.TokenTTL.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, headers)
+}
+
+// clientCredentials reads the client id and secret from the Authorization
+// header or the form body, reporting which one was used. Using both at once is
+// not allowed.
+func clientCredentials(r *http.Request) (string, string, bool, error) {
+	clientID, secret, basic := r.BasicAuth()
+	formID := r.PostForm.Get("client_id")
+	formSecret := r.PostForm.Get("client_secret")
+
+	if basic {
+		if formSecret != "" {
+			return "", "", false, errors.New("use only one client authentication method")
+		}
+
+		// Both parts are form encoded before being put in the header,
+		// section 2.3.1
+		var err1, err2 error
+		clientID, err1 = url.QueryUnescape(clientID)
+		secret, err2 = url.QueryUnescape(secret)
+		if err1 != nil || err2 != nil {
+			return "", "", false, errors.New("malformed Authorization header")
+		}
+		return clientID, secret, true, nil
+	}
+
+	if formID == "" || formSecret == "" {
+		return "", "", false, errors.New("missing client credentials")
+	}
+	return formID, formSecret, false, nil
+}
+
+// grantedScopes returns the scopes to put in the token: the requested ones,
+// which must all be allowed for the client, or every allowed scope when none
+// are requested.
+func grantedScopes(client *data.OAuthClient, requested string) ([]string, error) {
+	if strings.TrimSpace(requested) == "" {
+		return client.Scopes, nil
+	}
+
+	allowed := make(map[string]bool, len(client.Scopes))
+	for _, scope := range client.Scopes {
+		allowed[scope] = true
+	}
+
+	scopes := strings.Fields(requested)
+	for _, scope := range scopes {
+		if !allowed[scope] {
+			return nil, fmt.Errorf("scope %s is not allowed for this client", scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+// oauthError writes an RFC 6749 error response
+func (app *Config) oauthError(w http.ResponseWriter, status int, code, description string) {
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+	headers.Set("Pragma", "no-cache")
+
+	app.WriteJSON(w, status, oauthErrorResponse{Error: code, ErrorDescription: description}, headers)
+}
+
+// AdminListOAuthClients returns every registered OAuth2 client
+func (app *Config) AdminListOAuthClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := app.Models.OAuthClient.GetAll()
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to list OAuth clients")
+		app.errorJSON(w, errors.New("unable to list OAuth clients"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d OAuth clients", len(clients)),
+		Data:    clients,
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// AdminCreateOAuthClient registers a client. The secret is only ever shown in
+// this response.
+func (app *Config) AdminCreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+
+	err := app.ReadJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(requestPayload.Name) == "" {
+		app.errorJSON(w, errors.New("name is required"), http.StatusBadRequest)
+		return
+	}
+
+	if err := app.checkScopes(requestPayload.Scopes); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !app.callerHoldsScopes(w, r, requestPayload.Scopes) {
+		return
+	}
+
+	secret, client, err := app.Models.OAuthClient.Insert(requestPayload.Name, requestPayload.Scopes)
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to create OAuth client")
+		app.errorJSON(w, errors.New("unable to create OAuth client"), http.StatusInternalServerError)
+		return
+	}
+
+	app.Logger.WithField("client_id", client.ClientID).Info("OAuth client created")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "OAuth client " + client.ClientID + " created, store the secret now, it will not be shown again",
+		Data: map[string]any{
+			"client":        client,
+			"client_secret": secret,
+		},
+	}
+
+	app.WriteJSON(w, http.StatusCreated, payload)
+}
+
+// AdminUpdateOAuthClient changes the name and allowed scopes of a client.
+// Fields left out of the request are not changed.
+func (app *Config) AdminUpdateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := app.oauthClientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		Name   *string  `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+
+	err := app.ReadJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.Name != nil {
+		if strings.TrimSpace(*requestPayload.Name) == "" {
+			app.errorJSON(w, errors.New("name cannot be empty"), http.StatusBadRequest)
+			return
+		}
+		client.Name = *requestPayload.Name
+	}
+
+	if requestPayload.Scopes != nil {
+		if err := app.checkScopes(requestPayload.Scopes); err != nil {
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		if !app.callerHoldsScopes(w, r, requestPayload.Scopes) {
+			return
+		}
+		client.Scopes = requestPayload.Scopes
+	}
+
+	if err := client.Update(); err != nil {
+		app.Logger.WithError(err).Error("Failed to update OAuth client")
+		app.errorJSON(w, errors.New("unable to update OAuth client"), http.StatusInternalServerError)
+		return
+	}
+
+	app.Logger.WithField("client_id", client.ClientID).Info("OAuth client updated")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "updated OAuth client " + client.ClientID,
+		Data:    client,
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// AdminResetOAuthClientSecret issues a new secret for a client, which stops
+// the old one from working
+func (app *Config) AdminResetOAuthClientSecret(w http.ResponseWriter, r *http.Request) {
+	client, ok := app.oauthClientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	secret, err := client.ResetSecret()
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to reset OAuth client secret")
+		app.errorJSON(w, errors.New("unable to reset client secret"), http.StatusInternalServerError)
+		return
+	}
+
+	app.Logger.WithField("client_id", client.ClientID).Info("OAuth client secret reset")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "new secret for " + client.ClientID + ", store it now, it will not be shown again",
+		Data: map[string]any{
+			"client":        client,
+			"client_secret": secret,
+		},
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// AdminRevokeOAuthClient stops a client from getting new tokens
+func (app *Config) AdminRevokeOAuthClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := app.oauthClientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if err := client.Revoke(); err != nil {
+		app.Logger.WithError(err).Error("Failed to revoke OAuth client")
+		app.errorJSON(w, errors.New("unable to revoke OAuth client"), http.StatusInternalServerError)
+		return
+	}
+
+	app.Logger.WithField("client_id", client.ClientID).Info("OAuth client revoked")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "revoked OAuth client " + client.ClientID,
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// oauthClientFromURL loads the client named by the {id} URL parameter, writing
+// the error response itself when it cannot
+func (app *Config) oauthClientFromURL(w http.ResponseWriter, r *http.Request) (*data.OAuthClient, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid client id"), http.StatusBadRequest)
+		return nil, false
+	}
+
+	client, err := app.Models.OAuthClient.GetOne(id)
+	if err != nil {
+		if errors.Is(err, data.ErrClientNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return nil, false
+		}
+		app.Logger.WithError(err).Error("Failed to load OAuth client")
+		app.errorJSON(w, errors.New("unable to load OAuth client"), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return client, true
+}
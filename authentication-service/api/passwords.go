@@ -0,0 +1,121 @@
+package api
+
+import (
+	"authentication/data"
+	"authentication/event"
+	"authentication/internal/password"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WatchLegacyHashes keeps the legacy password hash gauge up to date, so the
+// progress of the transparent migration to the configured algorithm can be
+// followed in Grafana. It never returns.
+func (app *Config) WatchLegacyHashes(interval time.Duration) {
+	for {
+		count, err := app.Models.User.CountLegacyHashes()
+		if err != nil {
+			app.Logger.WithError(err).Warn("Failed to count legacy password hashes")
+		} else {
+			app.Metrics.LegacyPasswordHashes.Set(float64(count))
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// ChangePassword lets a logged in user replace their password, after
+// confirming the current one. Wrong current passwords count as failed logins.
+func (app *Config) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.callerID(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+
+	err := app.ReadJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.CurrentPassword == "" || requestPayload.Password == "" {
+		app.errorJSON(w, errors.New("current_password and password are required"), http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(userID)
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to load user")
+		app.errorJSON(w, errors.New("unable to change password"), http.StatusInternalServerError)
+		return
+	}
+
+	attempt := newLoginAttempt(r, user.Email)
+	if delay, reason := app.loginDelay(r.Context(), attempt); delay > 0 {
+		app.tooManyAttempts(w, delay, reason)
+		return
+	}
+
+	valid, err := user.PasswordMatches(requestPayload.CurrentPassword)
+	if err != nil || !valid {
+		app.recordLoginFailure(r.Context(), attempt)
+		app.errorCodeJSON(w, "invalid_current_password", errors.New("current password is incorrect"), http.StatusBadRequest)
+		return
+	}
+
+	if !app.checkPassword(w, requestPayload.Password, user) {
+		return
+	}
+
+	err = user.ResetPassword(requestPayload.Password)
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to change password")
+		app.errorJSON(w, errors.New("unable to change password"), http.StatusInternalServerError)
+		return
+	}
+
+	app.Logger.WithField("user_id", userID).Info("Password changed")
+	app.publishEvent(r, event.Event{
+		Type: event.PasswordChanged,
+		Data: fmt.Sprintf("user %d changed their password", userID),
+	}, user.Email, user)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Password changed",
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// checkPassword applies the password policy to a new password of user,
+// writing a response that lists every violated rule when it is refused
+func (app *Config) checkPassword(w http.ResponseWriter, plainText string, user *data.User) bool {
+	violations := app.PasswordPolicy.Check(plainText, user.Email, user.FirstName, user.LastName)
+	if len(violations) == 0 {
+		return true
+	}
+
+	messages := make([]string, 0, len(violations))
+	for _, v := range violations {
+		messages = append(messages, v.Message)
+	}
+
+	payload := jsonResponse{
+		Error:   true,
+		Code:    "weak_password",
+		Message: "password " + strings.Join(messages, ", "),
+		Data:    map[string][]password.Violation{"violations": violations},
+	}
+
+	app.WriteJSON(w, http.StatusBadRequest, payload)
+	return false
+}
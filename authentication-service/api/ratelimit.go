@@ -0,0 +1,239 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scopes failed logins are counted in. Each one is also the value of the
+// reason label on the blocked attempts metric.
+const (
+	scopeEmail   = "email"
+	scopeIP      = "ip"
+	scopeIPEmail = "ip_email"
+)
+
+// LoginLimit sets how many failed logins a scope tolerates within Window
+// before every further failure makes the next attempt wait, doubling the wait
+// each time up to MaxDelay.
+type LoginLimit struct {
+	Free     int
+	Window   time.Duration
+	MaxDelay time.Duration
+}
+
+// LoginLimits configures brute-force protection for logins. Failures are
+// counted per email (guessing one account), per client IP (password spraying
+// across accounts) and per IP and email pair. Addresses in TrustedNetworks are
+// never delayed.
+type LoginLimits struct {
+	Email   LoginLimit
+	IP      LoginLimit
+	IPEmail LoginLimit
+
+	// BaseDelay is the wait imposed by the first failure over the limit
+	BaseDelay       time.Duration
+	TrustedNetworks []*net.IPNet
+}
+
+// DefaultLoginLimits returns the limits used unless configured otherwise. The
+// per email delay is kept short so that an attacker cannot lock a user out of
+// their account, which is what the per pair limit is for.
+func DefaultLoginLimits() LoginLimits {
+	return LoginLimits{
+		Email:     LoginLimit{Free: 10, Window: time.Hour, MaxDelay: time.Minute},
+		IP:        LoginLimit{Free: 20, Window: time.Hour, MaxDelay: 15 * time.Minute},
+		IPEmail:   LoginLimit{Free: 3, Window: time.Hour, MaxDelay: 15 * time.Minute},
+		BaseDelay: time.Second,
+	}
+}
+
+// ParseTrustedNetworks parses a comma separated list of CIDR ranges or single
+// addresses
+func ParseTrustedNetworks(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("trusted network %q: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// Trusted reports whether an address belongs to one of the trusted networks
+func (l LoginLimits) Trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range l.TrustedNetworks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delay returns how long a scope waits after the given number of failures
+func (l LoginLimits) Delay(limit LoginLimit, failures int64) time.Duration {
+	over := failures - int64(limit.Free)
+	if over <= 0 {
+		return 0
+	}
+
+	delay := time.Duration(float64(l.BaseDelay) * math.Pow(2, float64(over-1)))
+	if delay <= 0 || delay > limit.MaxDelay {
+		return limit.MaxDelay
+	}
+	return delay
+}
+
+// loginAttempt identifies the scopes a login attempt is counted in
+type loginAttempt struct {
+	email string
+	ip    string
+}
+
+// newLoginAttempt returns the attempt for an email made by the client of r
+func newLoginAttempt(r *http.Request, email string) loginAttempt {
+	return loginAttempt{email: strings.ToLower(strings.TrimSpace(email)), ip: clientIP(r)}
+}
+
+// clientIP returns the address of the client. The RealIP middleware has
+// already replaced RemoteAddr with the forwarded client address where there is
+// one.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// loginScope is the limit of a scope and what the attempt is counted as in it
+type loginScope struct {
+	limit LoginLimit
+	id    string
+}
+
+// scopes returns every scope the attempt counts in, keyed by name
+func (a loginAttempt) scopes(limits LoginLimits) map[string]loginScope {
+	return map[string]loginScope{
+		scopeEmail:   {limits.Email, a.email},
+		scopeIP:      {limits.IP, a.ip},
+		scopeIPEmail: {limits.IPEmail, a.email + "|" + a.ip},
+	}
+}
+
+// attemptsKey and delayKey are the Limiter keys holding the failure count of a
+// scope and the wait it currently imposes
+func attemptsKey(scope, id string) string { return "login_attempts:" + scope + ":" + id }
+func delayKey(scope, id string) string    { return "login_delay:" + scope + ":" + id }
+
+// loginDelay returns how long the client has to wait before it may try to log
+// in again and the scope imposing the longest wait. Limiter errors let the
+// attempt through, since refusing every login would be worse.
+func (app *Config) loginDelay(ctx context.Context, attempt loginAttempt) (time.Duration, string) {
+	if app.LoginLimits.Trusted(attempt.ip) {
+		return 0, ""
+	}
+
+	var longest time.Duration
+	var reason string
+
+	for scope, s := range attempt.scopes(app.LoginLimits) {
+		delay, err := app.Limiter.Delay(ctx, delayKey(scope, s.id))
+		if err != nil {
+			app.Logger.WithError(err).Warn("Failed to read login delay")
+			continue
+		}
+		if delay > longest {
+			longest, reason = delay, scope
+		}
+	}
+
+	return longest, reason
+}
+
+// recordLoginFailure counts a failed login in every scope and starts the wait
+// for those over their limit
+func (app *Config) recordLoginFailure(ctx context.Context, attempt loginAttempt) {
+	if app.LoginLimits.Trusted(attempt.ip) {
+		return
+	}
+
+	for scope, s := range attempt.scopes(app.LoginLimits) {
+		failures, err := app.Limiter.Fail(ctx, attemptsKey(scope, s.id), s.limit.Window)
+		if err != nil {
+			app.Logger.WithError(err).Warn("Failed to record login failure")
+			continue
+		}
+
+		if delay := app.LoginLimits.Delay(s.limit, failures); delay > 0 {
+			app.Limiter.Block(ctx, delayKey(scope, s.id), delay)
+		}
+	}
+}
+
+// resetLoginFailures forgets the failures of a user after a successful login.
+// The per IP count is kept, otherwise an attacker spraying passwords could
+// reset it by logging into an account of their own.
+func (app *Config) resetLoginFailures(ctx context.Context, attempt loginAttempt) {
+	scopes := attempt.scopes(app.LoginLimits)
+
+	app.Limiter.Reset(ctx,
+		attemptsKey(scopeEmail, scopes[scopeEmail].id), delayKey(scopeEmail, scopes[scopeEmail].id),
+		attemptsKey(scopeIPEmail, scopes[scopeIPEmail].id), delayKey(scopeIPEmail, scopes[scopeIPEmail].id),
+	)
+}
+
+// unlockLogin removes every failure and wait recorded for an email, from any
+// address
+func (app *Config) unlockLogin(ctx context.Context, email string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	err := app.Limiter.Reset(ctx, attemptsKey(scopeEmail, email), delayKey(scopeEmail, email))
+	if err != nil {
+		return err
+	}
+
+	// Pair ids start with the email, see loginAttempt.scopes
+	err = app.Limiter.ResetPrefix(ctx, attemptsKey(scopeIPEmail, email+"|"))
+	if err != nil {
+		return err
+	}
+	return app.Limiter.ResetPrefix(ctx, delayKey(scopeIPEmail, email+"|"))
+}
+
+// tooManyAttempts refuses a login that came too soon after previous failures
+func (app *Config) tooManyAttempts(w http.ResponseWriter, delay time.Duration, reason string) {
+	app.Metrics.LoginBlocked.WithLabelValues(reason).Inc()
+
+	seconds := int(math.Ceil(delay.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	app.errorJSON(w, fmt.Errorf("too many failed login attempts, try again in %d seconds", seconds), http.StatusTooManyRequests)
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"authentication/pkg/authz"
 	"net/http"
 	"time"
 
@@ -29,6 +30,7 @@ func (app *Config) Routes() http.Handler {
 
 	mux.Use(middleware.Heartbeat("/ping"))
 	mux.Use(app.MetricsMiddleware)
+	mux.Use(authz.Verifier(app.JWTSecret))
 
 	// Add metrics endpoint
 	mux.Handle("/metrics", promhttp.Handler())
@@ -38,6 +40,74 @@ func (app *Config) Routes() http.Handler {
 	mux.Get("/readiness", app.ReadinessHandler) // Readiness probe
 
 	mux.Post("/authenticate", app.Authenticate)
+	mux.Post("/register", app.Register)
+	mux.Get("/verify", app.VerifyEmail)
+	mux.Post("/verify/resend", app.ResendVerification)
+
+	mux.Post("/mfa/enroll", app.EnrollMFA)
+	mux.Post("/mfa/confirm", app.ConfirmMFA)
+	mux.Post("/mfa/verify", app.VerifyMFA)
+
+	mux.Post("/password/reset", app.ResetPassword)
+
+	// Lets other services check the X-API-Key of machine callers
+	mux.Post("/api-keys/validate", app.ValidateAPIKey)
+
+	// OAuth2 client credentials grant
+	mux.Post("/oauth/token", app.OAuthToken)
+
+	// Account of the caller: login history, sessions and password
+	mux.Route("/me", func(mux chi.Router) {
+		mux.Use(authz.RequireAuth)
+		mux.Use(app.RequireSession)
+
+		mux.Get("/logins", app.MyLogins)
+		mux.Post("/password", app.ChangePassword)
+		mux.Get("/sessions", app.MySessions)
+		mux.Delete("/sessions/{id}", app.TerminateSession)
+	})
+
+	// Admin API, guarded by the permissions carried in the access token
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Use(authz.RequireAuth)
+		mux.Use(app.RequireSession)
+
+		mux.With(authz.RequirePermission("users:read")).Get("/roles", app.AdminListRoles)
+		mux.With(authz.RequirePermission("users:read")).Get("/users", app.AdminListUsers)
+		mux.With(authz.RequirePermission("users:read")).Get("/users/export", app.AdminExportUsers)
+		mux.With(authz.RequirePermission("users:read")).Get("/users/{id}", app.AdminGetUser)
+		mux.With(authz.RequirePermission("users:read")).Get("/users/{id}/audit", app.AdminUserAudit)
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(authz.RequirePermission("users:write"))
+			mux.Post("/users/import", app.AdminImportUsers)
+			mux.Put("/users/{id}", app.AdminUpdateUser)
+			mux.Delete("/users/{id}", app.AdminDeleteUser)
+			mux.Post("/users/{id}/password-reset", app.AdminForcePasswordReset)
+			mux.Post("/users/{id}/unlock", app.AdminUnlockUser)
+			mux.Post("/users/{id}/roles", app.AdminAssignRole)
+			mux.Delete("/users/{id}/roles/{role}", app.AdminRevokeRole)
+		})
+
+		mux.With(authz.RequirePermission("api_keys:read")).Get("/api-keys", app.AdminListAPIKeys)
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(authz.RequirePermission("api_keys:write"))
+			mux.Post("/api-keys", app.AdminCreateAPIKey)
+			mux.Post("/api-keys/{id}/rotate", app.AdminRotateAPIKey)
+			mux.Delete("/api-keys/{id}", app.AdminRevokeAPIKey)
+		})
+
+		mux.With(authz.RequirePermission("oauth_clients:read")).Get("/oauth-clients", app.AdminListOAuthClients)
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(authz.RequirePermission("oauth_clients:write"))
+			mux.Post("/oauth-clients", app.AdminCreateOAuthClient)
+			mux.Put("/oauth-clients/{id}", app.AdminUpdateOAuthClient)
+			mux.Post("/oauth-clients/{id}/secret", app.AdminResetOAuthClientSecret)
+			mux.Delete("/oauth-clients/{id}", app.AdminRevokeOAuthClient)
+		})
+	})
 	return mux
 }
 
@@ -66,11 +136,26 @@ func (app *Config) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Without Redis logins still work, only with per replica limits, so the
+	// service stays in rotation and reports itself as degraded
+	if h, ok := app.Limiter.(healthReporter); ok && !h.Healthy() {
+		app.Logger.Warn("Redis is unavailable, reporting degraded")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("DEGRADED: redis unavailable, login limits are per replica"))
+		return
+	}
+
 	app.Logger.Info("DB is ready, responding OK")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
 
+// healthReporter is implemented by dependencies the service can run without,
+// such as the failover login limiter
+type healthReporter interface {
+	Healthy() bool
+}
+
 // Middleware to track request count and latency
 func (app *Config) MetricsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
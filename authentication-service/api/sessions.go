@@ -0,0 +1,235 @@
+package api
+
+import (
+	"authentication/data"
+	"authentication/event"
+	"authentication/pkg/authz"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultLoginHistory = 20
+	maxLoginHistory     = 100
+)
+
+// recordLogin stores a login attempt in the login history and publishes it as
+// an auth event. user is nil when the email does not belong to any account. A
+// failure to record is logged but never fails the login.
+func (app *Config) recordLogin(r *http.Request, email string, user *data.User, outcome string) {
+	entry := data.LoginHistory{
+		Email:     email,
+		Outcome:   outcome,
+		IPAddress: clientIP(r),
+		UserAgent: r.UserAgent(),
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+
+	if user != nil {
+		entry.UserID = &user.ID
+		entry.Email = user.Email
+	}
+
+	err := app.Models.LoginHistory.Insert(entry)
+	if err != nil {
+		app.Logger.WithError(err).WithField("outcome", outcome).Error("Failed to record login attempt")
+	}
+
+	e := event.Event{Reason: outcome}
+	switch outcome {
+	case data.LoginSuccess:
+		e.Type, e.Reason = event.LoginSuccess, ""
+		e.Data = entry.Email + " logged in"
+	case data.LoginRateLimited:
+		e.Type = event.LoginLocked
+		e.Data = entry.Email + " login delayed after repeated failures"
+	case data.LoginMFARequired:
+		e.Type, e.Reason = event.LoginMFA, ""
+		e.Data = entry.Email + " passed the password step, second factor required"
+	default:
+		e.Type = event.LoginFailure
+		e.Data = entry.Email + " failed to log in: " + outcome
+	}
+
+	app.publishEvent(r, e, entry.Email, user)
+}
+
+// publishEvent fills in the request details of an auth event and hands it to
+// the event publisher
+func (app *Config) publishEvent(r *http.Request, e event.Event, email string, user *data.User) {
+	e.Email = email
+	e.IPAddress = clientIP(r)
+	e.UserAgent = r.UserAgent()
+	e.RequestID = middleware.GetReqID(r.Context())
+	if user != nil {
+		e.UserID = user.ID
+	}
+
+	app.Logger.WithFields(logrus.Fields{"event": e.Type, "data": e.Data}).Info("Event logged")
+
+	if app.Events != nil {
+		app.Events.Publish(e)
+	}
+}
+
+// MyLogins returns the most recent login attempts on the account of the
+// caller, ?limit= of them
+func (app *Config) MyLogins(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.callerID(w, r)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultLoginHistory
+	}
+	if limit > maxLoginHistory {
+		limit = maxLoginHistory
+	}
+
+	entries, err := app.Models.LoginHistory.GetByUser(userID, limit)
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to load login history")
+		app.errorJSON(w, errors.New("unable to load login history"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d login attempts", len(entries)),
+		Data:    entries,
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// MySessions returns the active sessions of the caller, flagging the one the
+// request was made with
+func (app *Config) MySessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.callerID(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := app.Models.Session.GetActiveByUser(userID)
+	if err != nil {
+		app.Logger.WithError(err).Error("Failed to load sessions")
+		app.errorJSON(w, errors.New("unable to load sessions"), http.StatusInternalServerError)
+		return
+	}
+
+	claims, _ := authz.FromContext(r.Context())
+
+	type sessionResponse struct {
+		*data.Session
+		Current bool `json:"current"`
+	}
+
+	out := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		out = append(out, sessionResponse{
+			Session: session,
+			Current: strconv.Itoa(session.ID) == claims.ID,
+		})
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d active sessions", len(out)),
+		Data:    out,
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// TerminateSession ends one of the sessions of the caller. Requests to this
+// service made with its token are refused from then on; other services accept
+// it until it expires, at most TokenTTL later.
+func (app *Config) TerminateSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.callerID(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid session id"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Session.Revoke(userID, id)
+	if err != nil {
+		if errors.Is(err, data.ErrSessionNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.Logger.WithError(err).Error("Failed to terminate session")
+		app.errorJSON(w, errors.New("unable to terminate session"), http.StatusInternalServerError)
+		return
+	}
+
+	app.Logger.WithFields(logrus.Fields{"user_id": userID, "session_id": id}).Info("Session terminated")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("session %d terminated", id),
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// RequireSession rejects tokens whose session has been terminated. Tokens
+// without a session, such as OAuth client tokens, are let through.
+func (app *Config) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := authz.FromContext(r.Context())
+		if !ok || claims.ID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id, err := strconv.Atoi(claims.ID)
+		if err != nil {
+			app.errorJSON(w, authz.ErrInvalidToken, http.StatusUnauthorized)
+			return
+		}
+
+		active, err := app.Models.Session.IsActive(id)
+		if err != nil {
+			app.Logger.WithError(err).Error("Failed to check session")
+			app.errorJSON(w, errors.New("unable to check session"), http.StatusInternalServerError)
+			return
+		}
+		if !active {
+			app.errorJSON(w, errors.New("session has been terminated"), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// callerID returns the id of the user the access token was issued to, writing
+// the error response itself for tokens that do not belong to a user
+func (app *Config) callerID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	claims, ok := authz.FromContext(r.Context())
+	if !ok {
+		app.errorJSON(w, errors.New("authentication required"), http.StatusUnauthorized)
+		return 0, false
+	}
+
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		app.errorJSON(w, errors.New("only user tokens have sessions and logins"), http.StatusForbidden)
+		return 0, false
+	}
+
+	return id, true
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"authentication/data"
+	"authentication/pkg/authz"
+	"net/http"
+	"strconv"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// tokenResponse is what a client gets back after a successful login
+type tokenResponse struct {
+	User        *data.User `json:"user"`
+	AccessToken string     `json:"access_token"`
+	TokenType   string     `json:"token_type"`
+	ExpiresIn   int        `json:"expires_in"`
+}
+
+// issueAccessToken opens a session for the user and signs an access token for
+// it carrying their roles and the permissions those roles grant.
+func (app *Config) issueAccessToken(r *http.Request, user *data.User) (*tokenResponse, error) {
+	roles, err := user.Roles()
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := user.Permissions()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := app.Models.Session.Insert(user.ID, clientIP(r), r.UserAgent(), app.TokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := authz.Claims{
+		Email:       user.Email,
+		Roles:       roles,
+		Permissions: permissions,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:      strconv.Itoa(session.ID),
+			Subject: strconv.Itoa(user.ID),
+		},
+	}
+
+	token, err := authz.Sign(app.JWTSecret, claims, app.TokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse{
+		User:        user,
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(app.TokenTTL.Seconds()),
+	}, nil
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"authentication/data"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// verificationTokenTTL is how long an email verification link stays valid
+const verificationTokenTTL = 24 * time.Hour
+
+// DefaultRole is assigned to every account created through registration or
+// imported without roles
+const DefaultRole = "user"
+
+// Error codes returned to clients when a login is refused for a reason other
+// than bad credentials
+const (
+	codeEmailNotVerified = "email_not_verified"
+	codeAccountInactive  = "account_inactive"
+)
+
+// Register creates a new, unverified account and emails the owner a link to
+// verify their address. An address that is already registered gets the same
+// answer, so that registration cannot be used to find out who has an account;
+// its owner is told by email instead.
+func (app *Config) Register(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("authentication-service").Start(r.Context(), "RegisterHandler")
+	defer span.End()
+
+	logger := logrus.WithFields(logrus.Fields{
+		"method":   r.Method,
+		"path":     r.URL.Path,
+		"trace_id": span.SpanContext().TraceID().String(),
+	})
+
+	var requestPayload struct {
+		Email     string `json:"email"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Password  string `json:"password"`
+	}
+
+	err := app.ReadJSON(w, r, &requestPayload)
+	if err != nil {
+		logger.WithError(err).Error("Failed to parse request payload")
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	requestPayload.Email = strings.TrimSpace(requestPayload.Email)
+	if requestPayload.Email == "" || requestPayload.Password == "" {
+		app.errorJSON(w, errors.New("email and password are required"), http.StatusBadRequest)
+		return
+	}
+
+	span.SetAttributes(attribute.String("user.email", requestPayload.Email))
+
+	user := data.User{
+		Email:     requestPayload.Email,
+		FirstName: requestPayload.FirstName,
+		LastName:  requestPayload.LastName,
+		Password:  requestPayload.Password,
+	}
+
+	if !app.checkPassword(w, requestPayload.Password, &user) {
+		return
+	}
+
+	id, err := app.Models.User.Insert(user)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+			logger.WithError(err).Error("Failed to create user")
+			app.errorJSON(w, errors.New("unable to create account"), http.StatusInternalServerError)
+			return
+		}
+
+		err = app.sendMail(ctx, requestPayload.Email, "Someone tried to register with your email address",
+			"Someone tried to create an account with this email address, which already has one. If that was you, please log in or reset your password. Otherwise you can ignore this email.")
+		if err != nil {
+			logger.WithError(err).Error("Failed to send registration notice")
+		}
+
+		app.registered(w, requestPayload.Email)
+		return
+	}
+
+	// Every new account starts with the default role
+	newUser := data.User{ID: id}
+	if err := newUser.AssignRole(DefaultRole); err != nil {
+		logger.WithError(err).Error("Failed to assign default role")
+	}
+
+	err = app.sendVerificationEmail(ctx, id, requestPayload.Email)
+	if err != nil {
+		// The account exists at this point; the user can ask for a new link
+		logger.WithError(err).Error("Failed to send verification email")
+	}
+
+	logger.WithField("user_email", requestPayload.Email).Info("User registered")
+
+	app.registered(w, requestPayload.Email)
+}
+
+// registered writes the answer to a registration, which is the same whether
+// the account was created or already existed
+func (app *Config) registered(w http.ResponseWriter, email string) {
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Registered user %s, please check your email to verify your account", email),
+	}
+
+	app.WriteJSON(w, http.StatusCreated, payload)
+}
+
+// VerifyEmail consumes the token from a verification link and marks the email
+// of the account it belongs to as verified.
+func (app *Config) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		app.errorJSON(w, errors.New("missing token"), http.StatusBadRequest)
+		return
+	}
+
+	userID, err := app.Models.EmailVerification.Verify(token)
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidToken) {
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		app.Logger.WithError(err).Error("Failed to verify email")
+		app.errorJSON(w, errors.New("unable to verify email"), http.StatusInternalServerError)
+		return
+	}
+
+	app.Logger.WithField("user_id", userID).Info("Email verified")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Email verified, you can now log in",
+	}
+
+	app.WriteJSON(w, http.StatusOK, payload)
+}
+
+// ResendVerification sends a fresh verification link. It always answers with
+// the same response so it cannot be used to discover registered emails.
+func (app *Config) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.ReadJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetByEmail(requestPayload.Email)
+	if err == nil && !user.Verified {
+		err = app.sendVerificationEmail(r.Context(), user.ID, user.Email)
+		if err != nil {
+			app.Logger.WithError(err).Error("Failed to resend verification email")
+		}
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "If the account exists and is not yet verified, a new link has been sent",
+	}
+
+	app.WriteJSON(w, http.StatusAccepted, payload)
+}
+
+// sendVerificationEmail creates a verification token for the user and mails
+// them the link to use it.
+func (app *Config) sendVerificationEmail(ctx context.Context, userID int, email string) error {
+	token, err := app.Models.EmailVerification.New(userID, verificationTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s?token=%s", app.VerifyURL, token)
+
+	return app.sendMail(ctx, email, "Verify your email address",
+		fmt.Sprintf("Please verify your email address by visiting the following link: %s\n\nThe link expires in 24 hours.", link))
+}
+
+// sendMail delivers an email through mailer-service
+func (app *Config) sendMail(ctx context.Context, to, subject, message string) error {
+	var msg struct {
+		From    string `json:"from"`
+		To      string `json:"to"`
+		Subject string `json:"subject"`
+		Message string `json:"message"`
+	}
+
+	msg.From = app.MailFrom
+	msg.To = to
+	msg.Subject = subject
+	msg.Message = message
+
+	jsonData, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", app.MailerURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("mailer service returned status code %d", response.StatusCode)
+	}
+
+	return nil
+}
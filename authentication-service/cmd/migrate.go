@@ -0,0 +1,122 @@
+package main
+
+import (
+	"authentication/data"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// runMigrateCommand implements "authApp migrate up|down [steps]|status"
+func runMigrateCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: authApp migrate up|down [steps]|status")
+		os.Exit(2)
+	}
+
+	conn := connectToDB()
+	if conn == nil {
+		logger.Fatal("Unable to establish database connection after maximum retries")
+	}
+	defer conn.Close()
+
+	migrator, err := data.NewMigrator(conn)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to load migrations")
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied   %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			logger.WithError(err).Fatal("Migration failed")
+		}
+		if len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				logger.Fatalf("Invalid number of steps %q", args[1])
+			}
+		}
+
+		rolledBack, err := migrator.Down(ctx, steps)
+		for _, m := range rolledBack {
+			fmt.Printf("rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			logger.WithError(err).Fatal("Rollback failed")
+		}
+
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to read migration status")
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, applied)
+		}
+
+	default:
+		logger.Fatalf("Unknown migrate command %q, expected up, down or status", args[0])
+	}
+}
+
+// migrateOnStart applies pending migrations before the server starts, unless
+// disabled with MIGRATE_ON_START=false
+func migrateOnStart(conn *sql.DB) {
+	if getEnv("MIGRATE_ON_START", "true") != "true" {
+		logger.Info("Skipping migrations on start")
+		return
+	}
+
+	migrator, err := data.NewMigrator(conn)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to load migrations")
+	}
+
+	applied, err := migrator.Up(context.Background())
+	for _, m := range applied {
+		logger.Infof("Applied migration %04d_%s", m.Version, m.Name)
+	}
+	if err != nil {
+		logger.WithError(err).Fatal("Migration failed")
+	}
+}
+
+// seedAdmin creates the initial administrator from USER_EMAIL and the base64
+// encoded bcrypt hash in USER_PASSWORD, if it does not exist yet
+func seedAdmin(models data.Models) {
+	email := os.Getenv("USER_EMAIL")
+	encoded := os.Getenv("USER_PASSWORD")
+	if email == "" || encoded == "" {
+		return
+	}
+
+	hash, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		logger.WithError(err).Error("USER_PASSWORD is not valid base64, skipping admin seed")
+		return
+	}
+
+	if err := models.User.InsertAdmin(email, string(hash)); err != nil {
+		logger.WithError(err).Error("Failed to seed admin user")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"authentication/internal/password"
+	"fmt"
+	"os"
+)
+
+// breachedFalsePositiveRate is the share of good passwords the breached
+// password filter wrongly refuses
+const breachedFalsePositiveRate = 0.001
+
+// runBreachedFilterCommand implements "authApp breached-filter LIST FILTER",
+// which compiles a list of breached passwords or SHA-1 hashes into the bloom
+// filter loaded from BREACHED_PASSWORDS_FILE. The filter is a fraction of the
+// size of the list and loads without hashing every entry again.
+func runBreachedFilterCommand(args []string) {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: authApp breached-filter LIST FILTER")
+		os.Exit(2)
+	}
+
+	filter, err := password.LoadBloom(args[0], breachedFalsePositiveRate)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to read breached passwords")
+	}
+
+	out, err := os.Create(args[1])
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to create filter")
+	}
+	defer out.Close()
+
+	size, err := filter.WriteTo(out)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to write filter")
+	}
+
+	fmt.Printf("wrote %d passwords to %s (%d bytes)\n", filter.Len(), args[1], size)
+}
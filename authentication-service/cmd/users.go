@@ -0,0 +1,122 @@
+package main
+
+import (
+	"authentication/api"
+	"authentication/data"
+	"authentication/internal/bulk"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const usersUsage = `usage: authApp users import [-format csv|ndjson] [-dry-run] [-atomic] FILE
+       authApp users export [-format csv|ndjson] [FILE]`
+
+// runUsersCommand implements "authApp users import|export", the command line
+// counterpart of the admin import and export endpoints. Files are read from
+// and written to standard input and output when FILE is "-" or missing.
+func runUsersCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usersUsage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("users "+args[0], flag.ExitOnError)
+	formatName := flags.String("format", "", "csv or ndjson, taken from the file extension by default")
+	dryRun := flags.Bool("dry-run", false, "check every row without creating anyone")
+	atomic := flags.Bool("atomic", false, "create nobody unless every row can be created")
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, usersUsage) }
+	flags.Parse(args[1:])
+
+	path := flags.Arg(0)
+	if path == "" {
+		path = "-"
+	}
+
+	format, err := fileFormat(*formatName, path)
+	if err != nil {
+		logger.WithError(err).Fatal("Unknown file format")
+	}
+
+	data.SetPasswordHasher(passwordHasher())
+
+	conn := connectToDB()
+	if conn == nil {
+		logger.Fatal("Unable to establish database connection after maximum retries")
+	}
+	defer conn.Close()
+
+	models := data.New(conn)
+
+	switch args[0] {
+	case "import":
+		in := io.Reader(os.Stdin)
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				logger.WithError(err).Fatal("Failed to open import file")
+			}
+			defer f.Close()
+			in = f
+		}
+
+		report, err := bulk.Import(&models.User, in, format, bulk.Options{
+			DryRun:      *dryRun,
+			Atomic:      *atomic,
+			Policy:      passwordPolicy(),
+			DefaultRole: api.DefaultRole,
+		})
+		if err != nil {
+			logger.WithError(err).Fatal("Import failed")
+		}
+
+		for _, rowErr := range report.Errors {
+			fmt.Printf("line %d %s: %s\n", rowErr.Line, rowErr.Email, strings.Join(rowErr.Errors, "; "))
+		}
+		if report.DryRun {
+			fmt.Printf("dry run: %d of %d users can be imported\n", report.Valid, report.Rows)
+		} else {
+			fmt.Printf("imported %d of %d users\n", report.Imported, report.Rows)
+		}
+
+		if report.Failed > 0 {
+			os.Exit(1)
+		}
+
+	case "export":
+		out := io.Writer(os.Stdout)
+		if path != "-" {
+			f, err := os.Create(path)
+			if err != nil {
+				logger.WithError(err).Fatal("Failed to create export file")
+			}
+			defer f.Close()
+			out = f
+		}
+
+		count, err := bulk.Export(&models.User, out, format)
+		if err != nil {
+			logger.WithError(err).Fatal("Export failed")
+		}
+		fmt.Fprintf(os.Stderr, "exported %d users\n", count)
+
+	default:
+		fmt.Fprintln(os.Stderr, usersUsage)
+		os.Exit(2)
+	}
+}
+
+// fileFormat returns the format named on the command line, or else the one of
+// the file extension, CSV for standard input and output
+func fileFormat(name, path string) (bulk.Format, error) {
+	if name != "" {
+		return bulk.ParseFormat(name)
+	}
+	if path == "-" {
+		return bulk.CSV, nil
+	}
+	return bulk.ParseFormat(filepath.Ext(path))
+}
@@ -0,0 +1,281 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// apiKeyPrefix starts every API key, so that leaked keys are easy to spot
+const apiKeyPrefix = "ak_"
+
+// ErrInvalidAPIKey is returned when a key is malformed, unknown, revoked or
+// expired
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// ErrAPIKeyNotFound is returned when managing a key id that does not exist
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// APIKey is the structure which holds one API key of a service. The key itself
+// is only returned once, when it is created; afterwards the Prefix, which is
+// its first characters, is all there is to tell keys apart.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Service    string     `json:"service"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+const apiKeyColumns = `id, service, prefix, key_hash, scopes, created_at, last_used_at, expires_at, revoked_at`
+
+// Insert creates a key for a service with the given scopes and returns it in
+// plain text together with the stored record. A nil expiresAt never expires.
+func (k *APIKey) Insert(service string, scopes []string, expiresAt *time.Time) (string, *APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return insertAPIKey(ctx, db, service, scopes, expiresAt)
+}
+
+// GetAll returns every key, or only those of one service if service is not
+// empty, newest first
+func (k *APIKey) GetAll(service string) ([]*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + apiKeyColumns + ` from api_keys
+		where $1 = '' or service = $1
+		order by created_at desc`
+
+	rows, err := db.QueryContext(ctx, query, service)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// GetOne returns one key by id
+func (k *APIKey) GetOne(id int) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	row := db.QueryRowContext(ctx, `select `+apiKeyColumns+` from api_keys where id = $1`, id)
+
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+// Revoke stops the key from being accepted. Revoking a revoked key is not an
+// error.
+func (k *APIKey) Revoke() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `update api_keys set revoked_at = coalesce(revoked_at, $1) where id = $2`, time.Now(), k.ID)
+	return err
+}
+
+// Rotate creates a replacement key with the same service and scopes and lets
+// this one expire after grace, so callers can switch over without downtime.
+// A grace of zero revokes the old key at once.
+func (k *APIKey) Rotate(grace time.Duration) (string, *APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+
+	plainText, key, err := insertAPIKey(ctx, tx, k.Service, k.Scopes, k.ExpiresAt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if grace > 0 {
+		_, err = tx.ExecContext(ctx, `update api_keys set expires_at = $1 where id = $2 and (expires_at is null or expires_at > $1)`,
+			time.Now().Add(grace), k.ID)
+	} else {
+		_, err = tx.ExecContext(ctx, `update api_keys set revoked_at = $1 where id = $2`, time.Now(), k.ID)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", nil, err
+	}
+
+	return plainText, key, nil
+}
+
+// Validate returns the key matching a plain text key if it is neither revoked
+// nor expired, and records that it was used.
+func (k *APIKey) Validate(plainText string) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	prefix, ok := apiKeyPrefixOf(plainText)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	row := db.QueryRowContext(ctx, `select `+apiKeyColumns+` from api_keys where prefix = $1`, prefix)
+
+	key, err := scanAPIKey(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashToken(plainText))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	_, err = db.ExecContext(ctx, `update api_keys set last_used_at = $1 where id = $2`, now, key.ID)
+	if err != nil {
+		return nil, err
+	}
+	key.LastUsedAt = &now
+
+	return key, nil
+}
+
+// HasScope reports whether the key grants a scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// execQuerier is implemented by both *sql.DB and *sql.Tx
+type execQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// insertAPIKey generates a key and stores its hash
+func insertAPIKey(ctx context.Context, q execQuerier, service string, scopes []string, expiresAt *time.Time) (string, *APIKey, error) {
+	plainText, prefix, err := newAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := &APIKey{
+		Service:   service,
+		Prefix:    prefix,
+		KeyHash:   hashToken(plainText),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+
+	stmt := `insert into api_keys (service, prefix, key_hash, scopes, created_at, expires_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err = q.QueryRowContext(ctx, stmt, key.Service, key.Prefix, key.KeyHash,
+		strings.Join(key.Scopes, " "), key.CreatedAt, key.ExpiresAt).Scan(&key.ID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return plainText, key, nil
+}
+
+// newAPIKey generates a key of the form ak_<8 hex characters>_<secret>. The
+// part up to the second underscore is the prefix kept in clear.
+func newAPIKey() (string, string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	prefix := apiKeyPrefix + hex.EncodeToString(b)
+
+	secret, _, err := NewToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	return prefix + "_" + secret, prefix, nil
+}
+
+// apiKeyPrefixOf returns the visible prefix of a plain text key
+func apiKeyPrefixOf(plainText string) (string, bool) {
+	n := len(apiKeyPrefix) + 8
+	if len(plainText) <= n+1 || !strings.HasPrefix(plainText, apiKeyPrefix) || plainText[n] != '_' {
+		return "", false
+	}
+	return plainText[:n], true
+}
+
+// scanAPIKey reads one key from a row selected with apiKeyColumns
+func scanAPIKey(row scanner) (*APIKey, error) {
+	var key APIKey
+	var scopes string
+	var lastUsedAt, expiresAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&key.ID,
+		&key.Service,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+		&key.CreatedAt,
+		&lastUsedAt,
+		&expiresAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = strings.Fields(scopes)
+	key.LastUsedAt = nullTime(lastUsedAt)
+	key.ExpiresAt = nullTime(expiresAt)
+	key.RevokedAt = nullTime(revokedAt)
+
+	return &key, nil
+}
+
+// nullTime turns a nullable column into a pointer, nil for NULL
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,88 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// AuditLog is the structure which holds one entry of the admin audit trail.
+// Every change made through the admin API is recorded with the acting user.
+type AuditLog struct {
+	ID           int            `json:"id"`
+	ActorID      int            `json:"actor_id"`
+	ActorEmail   string         `json:"actor_email"`
+	Action       string         `json:"action"`
+	TargetUserID int            `json:"target_user_id"`
+	Details      map[string]any `json:"details,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+// Insert records one audit entry
+func (a *AuditLog) Insert(entry AuditLog) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into admin_audit_log (actor_id, actor_email, action, target_user_id, details, created_at)
+		values ($1, $2, $3, $4, $5, $6)`
+
+	_, err = db.ExecContext(ctx, stmt,
+		entry.ActorID,
+		entry.ActorEmail,
+		entry.Action,
+		entry.TargetUserID,
+		details,
+		time.Now(),
+	)
+
+	return err
+}
+
+// GetByTarget returns the most recent audit entries concerning one user
+func (a *AuditLog) GetByTarget(userID int, limit int) ([]*AuditLog, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, actor_id, actor_email, action, target_user_id, details, created_at
+		from admin_audit_log where target_user_id = $1 order by created_at desc limit $2`
+
+	rows, err := db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditLog{}
+	for rows.Next() {
+		var entry AuditLog
+		var details []byte
+
+		err := rows.Scan(
+			&entry.ID,
+			&entry.ActorID,
+			&entry.ActorEmail,
+			&entry.Action,
+			&entry.TargetUserID,
+			&details,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(details) > 0 {
+			if err := json.Unmarshal(details, &entry.Details); err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,195 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+// bulkTimeout bounds a whole import or export, which run many statements
+const bulkTimeout = 5 * time.Minute
+
+// ErrDuplicateEmail is returned for an imported user whose email is taken
+var ErrDuplicateEmail = errors.New("an account with this email already exists")
+
+// ImportUser is one account to create in a bulk import. Password is a plain
+// text password to hash, or else PasswordHash a bcrypt or Argon2id hash made
+// elsewhere.
+type ImportUser struct {
+	Email        string
+	FirstName    string
+	LastName     string
+	Password     string
+	PasswordHash string
+	Active       bool
+	Verified     bool
+	Roles        []string
+}
+
+// ImportResult is the id of an imported user, or why it was not created
+type ImportResult struct {
+	ID  int
+	Err error
+}
+
+// Import creates users with their roles in a single transaction. Each user is
+// created behind a savepoint, so that one failing does not stop the others,
+// and the result of each is returned in order. The transaction is committed
+// unless dryRun is set or, with atomic, any user failed; the returned bool
+// tells whether it was.
+func (u *User) Import(users []ImportUser, atomic, dryRun bool) ([]ImportResult, bool, error) {
+	results := make([]ImportResult, len(users))
+
+	// Hash first, Argon2id is slow enough to matter over hundreds of users
+	hashes := make([]string, len(users))
+	for i, user := range users {
+		if user.Password == "" {
+			hashes[i] = user.PasswordHash
+			continue
+		}
+
+		hash, err := hasher.Hash(user.Password)
+		if err != nil {
+			return nil, false, err
+		}
+		hashes[i] = hash
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), bulkTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	failed := false
+	for i, user := range users {
+		if _, err := tx.ExecContext(ctx, `savepoint import_user`); err != nil {
+			return nil, false, err
+		}
+
+		id, err := importUser(ctx, tx, user, hashes[i])
+		if err != nil {
+			if _, rbErr := tx.ExecContext(ctx, `rollback to savepoint import_user`); rbErr != nil {
+				return nil, false, rbErr
+			}
+			results[i].Err = err
+			failed = true
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, `release savepoint import_user`); err != nil {
+			return nil, false, err
+		}
+		results[i].ID = id
+	}
+
+	if dryRun || (atomic && failed) {
+		return results, false, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return results, true, nil
+}
+
+// importUser inserts one imported user and its roles
+func importUser(ctx context.Context, tx execQuerier, user ImportUser, hash string) (int, error) {
+	active := 0
+	if user.Active {
+		active = 1
+	}
+
+	var id int
+	stmt := `insert into users (email, first_name, last_name, password, user_active, email_verified, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $7) returning id`
+
+	err := tx.QueryRowContext(ctx, stmt,
+		user.Email, user.FirstName, user.LastName, hash, active, user.Verified, time.Now(),
+	).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return 0, ErrDuplicateEmail
+		}
+		return 0, err
+	}
+
+	stmt = `insert into user_roles (user_id, role_id, created_at)
+		select $1, id, $2 from roles where name = $3
+		on conflict (user_id, role_id) do nothing`
+
+	for _, role := range user.Roles {
+		result, err := tx.ExecContext(ctx, stmt, id, time.Now(), role)
+		if err != nil {
+			return 0, err
+		}
+
+		// Roles are listed once per user, so nothing inserted means the
+		// role does not exist
+		if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+			return 0, fmt.Errorf("%w: %s", ErrRoleNotFound, role)
+		}
+	}
+
+	return id, nil
+}
+
+// Export calls fn with every user, in id order, and the names of their roles.
+// Password hashes and MFA secrets are not read.
+func (u *User) Export(fn func(user *User, roles []string) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), bulkTimeout)
+	defer cancel()
+
+	query := `select id, email, first_name, last_name, user_active, email_verified, mfa_enabled,
+		password_reset_required, created_at, updated_at,
+		coalesce((select string_agg(r.name, ',' order by r.name) from user_roles ur
+			join roles r on r.id = ur.role_id where ur.user_id = users.id), '')
+		from users order by id`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		var roles string
+
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Active,
+			&user.Verified,
+			&user.MFAEnabled,
+			&user.PasswordResetRequired,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&roles,
+		)
+		if err != nil {
+			return err
+		}
+
+		var names []string
+		if roles != "" {
+			names = strings.Split(roles, ",")
+		}
+
+		if err := fn(&user, names); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
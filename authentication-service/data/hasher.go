@@ -0,0 +1,295 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported password hashing algorithms
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+// ErrUnknownHash is returned when a stored hash is in a format no hasher knows
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes new passwords and verifies stored hashes. Verify must
+// accept every supported format, not only the one Hash produces, so that users
+// can still log in after the configured algorithm changes.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, hash string) (bool, error)
+	// NeedsRehash reports whether a hash was made with another algorithm or
+	// weaker parameters than the ones currently configured
+	NeedsRehash(hash string) bool
+	// CurrentPrefix is the prefix shared by every hash made with the current
+	// configuration, used to count users still on legacy hashes
+	CurrentPrefix() string
+}
+
+// Argon2Params are the Argon2id cost parameters. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Hasher is the PasswordHasher used by the data package. It hashes new
+// passwords with Algorithm and verifies both bcrypt and Argon2id hashes.
+type Hasher struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+// DefaultHasher returns a Hasher using Argon2id with the parameters recommended
+// by OWASP, which fit comfortably in the service memory limits.
+func DefaultHasher() *Hasher {
+	return &Hasher{
+		Algorithm:  AlgorithmArgon2id,
+		BcryptCost: 12,
+		Argon2: Argon2Params{
+			Memory:      19 * 1024,
+			Iterations:  2,
+			Parallelism: 1,
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+	}
+}
+
+// hasher is used by every User method that creates or checks a password
+var hasher PasswordHasher = DefaultHasher()
+
+// dummyHash is a hash of a password nobody knows, made the first time
+// DummyPasswordCheck needs it
+var (
+	dummyHash string
+	dummyOnce = &sync.Once{}
+)
+
+// SetPasswordHasher replaces the PasswordHasher used by the data package
+func SetPasswordHasher(h PasswordHasher) {
+	hasher = h
+	dummyOnce = &sync.Once{}
+}
+
+// DummyPasswordCheck verifies a password against a throwaway hash. Calling it
+// when a user does not exist makes a failed login cost the same time whether
+// or not the email is registered.
+func DummyPasswordCheck(plainText string) {
+	dummyOnce.Do(makeDummyHash)
+
+	hasher.Verify(plainText, dummyHash)
+}
+
+// DummyHashAlgorithm returns the algorithm of the hash DummyPasswordCheck
+// verifies against
+func DummyHashAlgorithm() string {
+	dummyOnce.Do(makeDummyHash)
+
+	switch {
+	case isBcrypt(dummyHash):
+		return AlgorithmBcrypt
+	case strings.HasPrefix(dummyHash, "$argon2id$"):
+		return AlgorithmArgon2id
+	default:
+		return ""
+	}
+}
+
+// makeDummyHash sets dummyHash. Stored hashes are not all made with the
+// configured algorithm, bcrypt ones are only upgraded on login, so with a
+// Hasher both a bcrypt and an Argon2id hash are made and the one slower to
+// verify is kept. A login for an unknown email then takes at least as long as
+// one for any real account.
+func makeDummyHash() {
+	random, _, err := NewToken()
+	if err != nil {
+		return
+	}
+
+	h, ok := hasher.(*Hasher)
+	if !ok {
+		dummyHash, _ = hasher.Hash(random)
+		return
+	}
+
+	var slowest time.Duration
+	for _, algorithm := range []string{AlgorithmBcrypt, AlgorithmArgon2id} {
+		candidate := *h
+		candidate.Algorithm = algorithm
+
+		hash, err := candidate.Hash(random)
+		if err != nil {
+			continue
+		}
+
+		start := time.Now()
+		h.Verify(random, hash)
+		if took := time.Since(start); took > slowest {
+			slowest, dummyHash = took, hash
+		}
+	}
+}
+
+// Hash hashes a password with the configured algorithm
+func (h *Hasher) Hash(password string) (string, error) {
+	switch h.Algorithm {
+	case AlgorithmBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		return string(hash), err
+	case AlgorithmArgon2id:
+		salt := make([]byte, h.Argon2.SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, h.Argon2.Iterations, h.Argon2.Memory, h.Argon2.Parallelism, h.Argon2.KeyLength)
+		return h.argon2Prefix() +
+			base64.RawStdEncoding.EncodeToString(salt) + "$" +
+			base64.RawStdEncoding.EncodeToString(key), nil
+	default:
+		return "", fmt.Errorf("unsupported password hash algorithm %q", h.Algorithm)
+	}
+}
+
+// Verify compares a password with a bcrypt or Argon2id hash
+func (h *Hasher) Verify(password, hash string) (bool, error) {
+	switch {
+	case isBcrypt(hash):
+		// Normalize $2y$ to $2a$ for Go bcrypt compatibility
+		if strings.HasPrefix(hash, "$2y$") {
+			hash = "$2a$" + hash[4:]
+		}
+
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				// invalid password
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, err := decodeArgon2(hash)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+
+	default:
+		return false, ErrUnknownHash
+	}
+}
+
+// NeedsRehash reports whether a hash differs in algorithm or cost from what
+// Hash would produce now
+func (h *Hasher) NeedsRehash(hash string) bool {
+	switch h.Algorithm {
+	case AlgorithmBcrypt:
+		if !isBcrypt(hash) || strings.HasPrefix(hash, "$2y$") {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != h.BcryptCost
+	case AlgorithmArgon2id:
+		params, _, key, err := decodeArgon2(hash)
+		if err != nil {
+			return true
+		}
+		return params.Memory != h.Argon2.Memory ||
+			params.Iterations != h.Argon2.Iterations ||
+			params.Parallelism != h.Argon2.Parallelism ||
+			uint32(len(key)) != h.Argon2.KeyLength
+	default:
+		return false
+	}
+}
+
+// CurrentPrefix returns the prefix of hashes made with the current settings
+func (h *Hasher) CurrentPrefix() string {
+	if h.Algorithm == AlgorithmBcrypt {
+		return fmt.Sprintf("$2a$%02d$", h.BcryptCost)
+	}
+	return h.argon2Prefix()
+}
+
+// argon2Prefix returns the PHC string prefix holding the Argon2id parameters
+func (h *Hasher) argon2Prefix() string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$",
+		argon2.Version, h.Argon2.Memory, h.Argon2.Iterations, h.Argon2.Parallelism)
+}
+
+// ValidHash checks that a hash made elsewhere, such as in a system users are
+// imported from, is a bcrypt or Argon2id hash this service can verify
+func ValidHash(hash string) error {
+	switch {
+	case isBcrypt(hash):
+		if strings.HasPrefix(hash, "$2y$") {
+			hash = "$2a$" + hash[4:]
+		}
+		_, err := bcrypt.Cost([]byte(hash))
+		return err
+	case strings.HasPrefix(hash, "$argon2id$"):
+		_, _, _, err := decodeArgon2(hash)
+		return err
+	default:
+		return ErrUnknownHash
+	}
+}
+
+// isBcrypt reports whether a hash is in one of the bcrypt formats
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// decodeArgon2 parses a hash in the PHC string format
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+func decodeArgon2(hash string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
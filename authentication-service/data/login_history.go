@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"unicode/utf8"
+)
+
+// Outcomes of a login attempt stored in the login history
+const (
+	LoginSuccess            = "success"
+	LoginInvalidCredentials = "invalid_credentials"
+	LoginRateLimited        = "rate_limited"
+	LoginEmailNotVerified   = "email_not_verified"
+	LoginAccountInactive    = "account_inactive"
+	LoginPasswordReset      = "password_reset_required"
+	LoginMFARequired        = "mfa_required"
+	LoginInvalidMFACode     = "invalid_mfa_code"
+)
+
+// LoginHistory is the structure which holds one login attempt. UserID is nil
+// when the email did not belong to any account.
+type LoginHistory struct {
+	ID        int64     `json:"id"`
+	UserID    *int      `json:"user_id,omitempty"`
+	Email     string    `json:"email"`
+	Success   bool      `json:"success"`
+	Outcome   string    `json:"outcome"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	RequestID string    `json:"request_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Insert records one login attempt
+func (l *LoginHistory) Insert(entry LoginHistory) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into login_history (user_id, email, success, outcome, ip_address, user_agent, request_id, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := db.ExecContext(ctx, stmt,
+		entry.UserID,
+		entry.Email,
+		entry.Outcome == LoginSuccess,
+		entry.Outcome,
+		truncate(entry.IPAddress, 45),
+		truncate(entry.UserAgent, 512),
+		truncate(entry.RequestID, 64),
+		time.Now(),
+	)
+
+	return err
+}
+
+// GetByUser returns the most recent login attempts on one account
+func (l *LoginHistory) GetByUser(userID int, limit int) ([]*LoginHistory, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, email, success, outcome, ip_address, user_agent, request_id, created_at
+		from login_history where user_id = $1 order by created_at desc limit $2`
+
+	rows, err := db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*LoginHistory{}
+	for rows.Next() {
+		var entry LoginHistory
+		var id sql.NullInt64
+
+		err := rows.Scan(
+			&entry.ID,
+			&id,
+			&entry.Email,
+			&entry.Success,
+			&entry.Outcome,
+			&entry.IPAddress,
+			&entry.UserAgent,
+			&entry.RequestID,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if id.Valid {
+			userID := int(id.Int64)
+			entry.UserID = &userID
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// truncate cuts s to at most n bytes so it fits its column, without splitting
+// a multi-byte character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// recoveryCodeCount is how many one-time recovery codes a user gets when
+// multi-factor authentication is enabled
+const recoveryCodeCount = 10
+
+// SetMFASecret stores a pending TOTP secret for the user. The secret is not
+// used for logins until EnableMFA has been called.
+func (u *User) SetMFASecret(secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set mfa_secret = $1, updated_at = $2 where id = $3`
+
+	_, err := db.ExecContext(ctx, stmt, secret, time.Now(), u.ID)
+	return err
+}
+
+// EnableMFA turns on multi-factor authentication for the user and replaces any
+// existing recovery codes with the given ones. Only code hashes are stored.
+func (u *User) EnableMFA(recoveryCodes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update users set mfa_enabled = true, updated_at = $1 where id = $2`, time.Now(), u.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from mfa_recovery_codes where user_id = $1`, u.ID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into mfa_recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`
+	for _, code := range recoveryCodes {
+		_, err = tx.ExecContext(ctx, stmt, u.ID, hashToken(normalizeRecoveryCode(code)), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks one of the user's unused recovery codes as used. It
+// returns false if the code does not exist or has already been used.
+func (u *User) UseRecoveryCode(code string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update mfa_recovery_codes set used_at = $1
+		where user_id = $2 and code_hash = $3 and used_at is null`
+
+	result, err := db.ExecContext(ctx, stmt, time.Now(), u.ID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// UseTOTPStep records the time step of a TOTP code the user has just
+// presented. It returns false if that step or a later one has been used
+// before, which means the code is being replayed.
+func (u *User) UseTOTPStep(step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set mfa_last_step = $1
+		where id = $2 and (mfa_last_step is null or mfa_last_step < $1)`
+
+	result, err := db.ExecContext(ctx, stmt, step, u.ID)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// NewRecoveryCodes generates a fresh set of random recovery codes in the form
+// xxxxx-xxxxx.
+func NewRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode makes recovery codes tolerant to case and to the
+// separator being left out when typed in.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
@@ -0,0 +1,22 @@
+-- The users table predates versioned migrations, so it is created only when
+-- missing to adopt databases set up by the old Postgres init script.
+CREATE SEQUENCE IF NOT EXISTS public.user_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+CREATE TABLE IF NOT EXISTS public.users (
+    id integer DEFAULT nextval('public.user_id_seq'::regclass) NOT NULL,
+    email character varying(255) UNIQUE,
+    first_name character varying(255),
+    last_name character varying(60),
+    password character varying(60),
+    user_active integer DEFAULT 0,
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone,
+    PRIMARY KEY (id)
+);
+
+ALTER SEQUENCE public.user_id_seq OWNED BY public.users.id;
@@ -0,0 +1,12 @@
+-- Email verification: new accounts start unverified. Accounts that existed
+-- before this migration are backfilled as verified so they can still log in.
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS email_verified boolean DEFAULT true NOT NULL;
+ALTER TABLE public.users ALTER COLUMN email_verified SET DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS public.email_verification_tokens (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    token_hash character(64) NOT NULL UNIQUE,
+    expires_at timestamp without time zone NOT NULL,
+    created_at timestamp without time zone NOT NULL
+);
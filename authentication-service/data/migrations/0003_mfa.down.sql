@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.mfa_recovery_codes;
+ALTER TABLE public.users DROP COLUMN IF EXISTS mfa_secret;
+ALTER TABLE public.users DROP COLUMN IF EXISTS mfa_enabled;
@@ -0,0 +1,13 @@
+-- Multi-factor authentication (TOTP) and one-time recovery codes
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS mfa_enabled boolean DEFAULT false NOT NULL;
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS mfa_secret character varying(64);
+
+CREATE TABLE IF NOT EXISTS public.mfa_recovery_codes (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    code_hash character(64) NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS mfa_recovery_codes_user_id_idx ON public.mfa_recovery_codes (user_id);
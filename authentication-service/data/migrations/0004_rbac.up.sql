@@ -0,0 +1,45 @@
+-- Role-based access control
+CREATE TABLE IF NOT EXISTS public.roles (
+    id serial PRIMARY KEY,
+    name character varying(64) NOT NULL UNIQUE,
+    description character varying(255),
+    created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.permissions (
+    id serial PRIMARY KEY,
+    name character varying(64) NOT NULL UNIQUE,
+    description character varying(255)
+);
+
+CREATE TABLE IF NOT EXISTS public.role_permissions (
+    role_id integer NOT NULL REFERENCES public.roles (id) ON DELETE CASCADE,
+    permission_id integer NOT NULL REFERENCES public.permissions (id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.user_roles (
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    role_id integer NOT NULL REFERENCES public.roles (id) ON DELETE CASCADE,
+    created_at timestamp without time zone DEFAULT now() NOT NULL,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO public.roles (name, description)
+VALUES
+('admin', 'Full access to every service'),
+('user', 'Regular application user')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.permissions (name, description)
+VALUES
+('logs:read', 'Read log entries'),
+('logs:write', 'Write log entries'),
+('mail:send', 'Send emails'),
+('users:read', 'View user accounts'),
+('users:write', 'Manage user accounts')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM public.roles r CROSS JOIN public.permissions p WHERE r.name = 'admin'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.admin_audit_log;
+DROP TABLE IF EXISTS public.password_reset_tokens;
+ALTER TABLE public.users DROP COLUMN IF EXISTS password_reset_required;
@@ -0,0 +1,22 @@
+-- Admin user management: forced password resets and the audit trail
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS password_reset_required boolean DEFAULT false NOT NULL;
+
+CREATE TABLE IF NOT EXISTS public.password_reset_tokens (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    token_hash character(64) NOT NULL UNIQUE,
+    expires_at timestamp without time zone NOT NULL,
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.admin_audit_log (
+    id serial PRIMARY KEY,
+    actor_id integer NOT NULL,
+    actor_email character varying(255) NOT NULL,
+    action character varying(64) NOT NULL,
+    target_user_id integer NOT NULL,
+    details jsonb,
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS admin_audit_log_target_user_id_idx ON public.admin_audit_log (target_user_id, created_at);
@@ -0,0 +1,3 @@
+-- Fails while any stored hash is longer than 60 characters, which is the
+-- intended safety net against truncating Argon2id hashes.
+ALTER TABLE public.users ALTER COLUMN password TYPE character varying(60);
@@ -0,0 +1,3 @@
+-- Argon2id hashes in PHC format are longer than the 60 characters of bcrypt
+-- hashes the column was sized for.
+ALTER TABLE public.users ALTER COLUMN password TYPE character varying(255);
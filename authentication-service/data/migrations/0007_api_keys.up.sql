@@ -0,0 +1,26 @@
+-- API keys for service to service calls. Only a hash of each key is stored,
+-- next to the prefix shown to humans to tell keys apart.
+CREATE TABLE IF NOT EXISTS public.api_keys (
+    id serial PRIMARY KEY,
+    service character varying(64) NOT NULL,
+    prefix character varying(16) NOT NULL UNIQUE,
+    key_hash character(64) NOT NULL UNIQUE,
+    scopes text NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    last_used_at timestamp without time zone,
+    expires_at timestamp without time zone,
+    revoked_at timestamp without time zone
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_service_idx ON public.api_keys (service);
+
+INSERT INTO public.permissions (name, description)
+VALUES
+('api_keys:read', 'View service API keys'),
+('api_keys:write', 'Mint, rotate and revoke service API keys')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM public.roles r CROSS JOIN public.permissions p
+WHERE r.name = 'admin' AND p.name IN ('api_keys:read', 'api_keys:write')
+ON CONFLICT DO NOTHING;
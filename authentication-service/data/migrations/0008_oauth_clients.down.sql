@@ -0,0 +1,2 @@
+DELETE FROM public.permissions WHERE name IN ('oauth_clients:read', 'oauth_clients:write');
+DROP TABLE IF EXISTS public.oauth_clients;
@@ -0,0 +1,23 @@
+-- OAuth2 clients allowed to use the client credentials grant. Only a hash of
+-- each client secret is stored.
+CREATE TABLE IF NOT EXISTS public.oauth_clients (
+    id serial PRIMARY KEY,
+    client_id character varying(64) NOT NULL UNIQUE,
+    secret_hash character(64) NOT NULL,
+    name character varying(255) NOT NULL,
+    scopes text NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL,
+    revoked_at timestamp without time zone
+);
+
+INSERT INTO public.permissions (name, description)
+VALUES
+('oauth_clients:read', 'View OAuth2 clients'),
+('oauth_clients:write', 'Register and manage OAuth2 clients')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM public.roles r CROSS JOIN public.permissions p
+WHERE r.name = 'admin' AND p.name IN ('oauth_clients:read', 'oauth_clients:write')
+ON CONFLICT DO NOTHING;
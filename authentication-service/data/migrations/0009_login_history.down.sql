@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.sessions;
+DROP TABLE IF EXISTS public.login_history;
@@ -0,0 +1,27 @@
+-- Every login attempt, successful or not, and the sessions opened by the
+-- successful ones
+CREATE TABLE IF NOT EXISTS public.login_history (
+    id bigserial PRIMARY KEY,
+    user_id integer REFERENCES public.users (id) ON DELETE CASCADE,
+    email character varying(255) NOT NULL,
+    success boolean NOT NULL,
+    outcome character varying(32) NOT NULL,
+    ip_address character varying(45) NOT NULL,
+    user_agent character varying(512) NOT NULL,
+    request_id character varying(64) NOT NULL,
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS login_history_user_id_idx ON public.login_history (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS public.sessions (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    ip_address character varying(45) NOT NULL,
+    user_agent character varying(512) NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    revoked_at timestamp without time zone
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON public.sessions (user_id, expires_at);
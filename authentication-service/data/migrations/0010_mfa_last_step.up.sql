@@ -0,0 +1,3 @@
+-- The time step of the last TOTP code accepted for each user, so a code
+-- cannot be used twice
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS mfa_last_step bigint;
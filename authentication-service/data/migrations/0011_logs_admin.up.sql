@@ -0,0 +1,10 @@
+-- Managing the log retention policies of logger-service
+INSERT INTO public.permissions (name, description)
+VALUES
+('logs:admin', 'Manage log retention')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM public.roles r CROSS JOIN public.permissions p
+WHERE r.name = 'admin' AND p.name = 'logs:admin'
+ON CONFLICT DO NOTHING;
@@ -3,10 +3,8 @@ package data
 import (
 	"context"
 	"database/sql"
-	"errors"
+	"fmt"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 const dbTimeout = time.Second * 3
@@ -19,7 +17,15 @@ func New(dbPool *sql.DB) Models {
 	db = dbPool
 
 	return Models{
-		User: User{},
+		User:              User{},
+		EmailVerification: EmailVerification{},
+		Role:              Role{},
+		PasswordReset:     PasswordReset{},
+		AuditLog:          AuditLog{},
+		APIKey:            APIKey{},
+		OAuthClient:       OAuthClient{},
+		LoginHistory:      LoginHistory{},
+		Session:           Session{},
 	}
 }
 
@@ -27,30 +33,45 @@ func New(dbPool *sql.DB) Models {
 // in this type is available to us throughout the application, anywhere that the
 // app variable is used, provided that the model is also added in the New function.
 type Models struct {
-	User User
+	User              User
+	EmailVerification EmailVerification
+	Role              Role
+	PasswordReset     PasswordReset
+	AuditLog          AuditLog
+	APIKey            APIKey
+	OAuthClient       OAuthClient
+	LoginHistory      LoginHistory
+	Session           Session
 }
 
 // User is the structure which holds one user from the database.
 type User struct {
-	ID        int       `json:"id"`
-	Email     string    `json:"email"`
-	FirstName string    `json:"first_name,omitempty"`
-	LastName  string    `json:"last_name,omitempty"`
-	Password  string    `json:"-"`
-	Active    int       `json:"active"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID                    int       `json:"id"`
+	Email                 string    `json:"email"`
+	FirstName             string    `json:"first_name,omitempty"`
+	LastName              string    `json:"last_name,omitempty"`
+	Password              string    `json:"-"`
+	Active                int       `json:"active"`
+	Verified              bool      `json:"email_verified"`
+	MFAEnabled            bool      `json:"mfa_enabled"`
+	MFASecret             string    `json:"-"`
+	PasswordResetRequired bool      `json:"password_reset_required"`
+	CreatedAt             time.Time `json:"created_at"`
+	UpdatedAt             time.Time `json:"updated_at"`
 }
 
-// GetByEmail returns one user by email
-func (u *User) GetByEmail(email string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+// userColumns is the column list shared by every query that loads a full user
+const userColumns = `id, email, first_name, last_name, password, user_active, email_verified,
+	mfa_enabled, coalesce(mfa_secret, ''), password_reset_required, created_at, updated_at`
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where email = $1`
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
 
+// scanUser reads one row selected with userColumns into a User
+func scanUser(row scanner) (*User, error) {
 	var user User
-	row := db.QueryRowContext(ctx, query, email)
 
 	err := row.Scan(
 		&user.ID,
@@ -59,6 +80,10 @@ func (u *User) GetByEmail(email string) (*User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.Active,
+		&user.Verified,
+		&user.MFAEnabled,
+		&user.MFASecret,
+		&user.PasswordResetRequired,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -70,27 +95,289 @@ func (u *User) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-// PasswordMatches uses Go's bcrypt package to compare a user supplied password
-// with the hash we have stored for a given user in the database. If the password
-// and hash match, we return true; otherwise, we return false.
-func (u *User) PasswordMatches(plainText string) (bool, error) {
-	hash := u.Password
+// GetByEmail returns one user by email
+func (u *User) GetByEmail(email string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + userColumns + ` from users where email = $1`
+
+	return scanUser(db.QueryRowContext(ctx, query, email))
+}
+
+// GetOne returns one user by id
+func (u *User) GetOne(id int) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
 
-	// Normalize $2y$ to $2a$ for Go bcrypt compatibility
-	if len(hash) > 4 && hash[:4] == "$2y$" {
-		hash = "$2a$" + hash[4:]
+	query := `select ` + userColumns + ` from users where id = $1`
+
+	return scanUser(db.QueryRowContext(ctx, query, id))
+}
+
+// Insert inserts a new user into the database and returns the ID of the newly
+// inserted row. New accounts start active but unverified, and cannot log in
+// until the owner follows the link in the verification email.
+func (u *User) Insert(user User) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hashedPassword, err := hasher.Hash(user.Password)
+	if err != nil {
+		return 0, err
 	}
 
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(plainText))
+	var newID int
+	stmt := `insert into users (email, first_name, last_name, password, user_active, email_verified, created_at, updated_at)
+		values ($1, $2, $3, $4, 1, false, $5, $6) returning id`
+
+	err = db.QueryRowContext(ctx, stmt,
+		user.Email,
+		user.FirstName,
+		user.LastName,
+		hashedPassword,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			// invalid password
-			return false, nil
-		default:
-			return false, err
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// InsertAdmin creates the initial administrator from an already hashed
+// password, verified and active, and gives it the admin role. Nothing happens
+// if a user with that email already exists.
+func (u *User) InsertAdmin(email, passwordHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into users (email, first_name, last_name, password, user_active, email_verified, created_at, updated_at)
+		values ($1, 'Admin', 'User', $2, 1, true, $3, $3)
+		on conflict (email) do nothing`
+
+	_, err = tx.ExecContext(ctx, stmt, email, passwordHash, time.Now())
+	if err != nil {
+		return err
+	}
+
+	stmt = `insert into user_roles (user_id, role_id, created_at)
+		select u.id, r.id, $2 from users u cross join roles r
+		where u.email = $1 and r.name = 'admin'
+		on conflict do nothing`
+
+	_, err = tx.ExecContext(ctx, stmt, email, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAll returns one page of users ordered by id, optionally filtered by a
+// search term matched against email, first name and last name, together with
+// the total number of matching users.
+func (u *User) GetAll(search string, page, perPage int) ([]*User, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	where := ""
+	args := []any{}
+	if search != "" {
+		where = ` where email ilike $1 or first_name ilike $1 or last_name ilike $1`
+		args = append(args, "%"+search+"%")
+	}
+
+	var total int
+	err := db.QueryRowContext(ctx, `select count(*) from users`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`select %s from users%s order by id limit $%d offset $%d`,
+		userColumns, where, len(args)+1, len(args)+2)
+	args = append(args, perPage, (page-1)*perPage)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
 		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// Update updates one user in the database, using the information
+// stored in the receiver u
+func (u *User) Update() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set
+		first_name = $1,
+		last_name = $2,
+		user_active = $3,
+		updated_at = $4
+		where id = $5`
+
+	_, err := db.ExecContext(ctx, stmt,
+		u.FirstName,
+		u.LastName,
+		u.Active,
+		time.Now(),
+		u.ID,
+	)
+
+	return err
+}
+
+// Delete deletes one user from the database, by User.ID
+func (u *User) Delete() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `delete from users where id = $1`, u.ID)
+	return err
+}
+
+// Anonymize removes every piece of personal data from the user while keeping
+// the row, so that references to it (audit entries, ...) stay valid. Login
+// history keeps its outcomes but loses the email, address and user agent, and
+// sessions are deleted. The account can no longer be used to log in.
+func (u *User) Anonymize() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// A random password nobody knows, so the old one stops working
+	random, _, err := NewToken()
+	if err != nil {
+		return err
 	}
 
-	return true, nil
+	hashedPassword, err := hasher.Hash(random)
+	if err != nil {
+		return err
+	}
+
+	email := fmt.Sprintf("deleted-%d@anonymized.invalid", u.ID)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update users set
+		email = $1,
+		first_name = '',
+		last_name = '',
+		password = $2,
+		user_active = 0,
+		mfa_enabled = false,
+		mfa_secret = null,
+		updated_at = $3
+		where id = $4`
+
+	_, err = tx.ExecContext(ctx, stmt, email, hashedPassword, time.Now(), u.ID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update login_history set email = $1, ip_address = '', user_agent = '' where user_id = $2`
+
+	_, err = tx.ExecContext(ctx, stmt, email, u.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from sessions where user_id = $1`, u.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ResetPassword is the method we will use to change a user's password. It
+// also clears a pending forced password reset.
+func (u *User) ResetPassword(password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hashedPassword, err := hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update users set password = $1, password_reset_required = false, updated_at = $2 where id = $3`
+
+	_, err = db.ExecContext(ctx, stmt, hashedPassword, time.Now(), u.ID)
+	return err
+}
+
+// PasswordMatches compares a user supplied password with the hash we have
+// stored for a given user in the database, whichever supported algorithm made
+// it. If the password and hash match, we return true; otherwise, we return false.
+func (u *User) PasswordMatches(plainText string) (bool, error) {
+	return hasher.Verify(plainText, u.Password)
+}
+
+// NeedsRehash reports whether the stored hash was made with an outdated
+// algorithm or cost and should be replaced on the next successful login
+func (u *User) NeedsRehash() bool {
+	return hasher.NeedsRehash(u.Password)
+}
+
+// Rehash replaces the stored hash with one made with the current settings.
+// It must only be called with a password that has just been verified.
+func (u *User) Rehash(plainText string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hash, err := hasher.Hash(plainText)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `update users set password = $1 where id = $2`, hash, u.ID)
+	if err != nil {
+		return err
+	}
+
+	u.Password = hash
+	return nil
+}
+
+// CountLegacyHashes returns how many users have a password hash that was not
+// made with the current algorithm and cost
+func (u *User) CountLegacyHashes() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var count int
+	query := `select count(*) from users where password is not null and password not like $1`
+
+	err := db.QueryRowContext(ctx, query, hasher.CurrentPrefix()+"%").Scan(&count)
+	return count, err
 }
@@ -0,0 +1,203 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// oauthClientIDPrefix starts every generated client id
+const oauthClientIDPrefix = "cl_"
+
+// ErrInvalidClient is returned when a client id and secret do not match an
+// active client
+var ErrInvalidClient = errors.New("invalid client credentials")
+
+// ErrClientNotFound is returned when managing a client that does not exist
+var ErrClientNotFound = errors.New("OAuth client not found")
+
+// OAuthClient is the structure which holds one OAuth2 client allowed to get
+// access tokens with the client credentials grant, limited to Scopes
+type OAuthClient struct {
+	ID         int        `json:"id"`
+	ClientID   string     `json:"client_id"`
+	SecretHash string     `json:"-"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+const oauthClientColumns = `id, client_id, secret_hash, name, scopes, created_at, updated_at, revoked_at`
+
+// Insert registers a client and returns its secret in plain text together with
+// the stored record
+func (c *OAuthClient) Insert(name string, scopes []string) (string, *OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+
+	secret, hash, err := NewToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	client := &OAuthClient{
+		ClientID:   oauthClientIDPrefix + hex.EncodeToString(b),
+		SecretHash: hash,
+		Name:       name,
+		Scopes:     scopes,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	stmt := `insert into oauth_clients (client_id, secret_hash, name, scopes, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err = db.QueryRowContext(ctx, stmt, client.ClientID, client.SecretHash, client.Name,
+		strings.Join(client.Scopes, " "), client.CreatedAt, client.UpdatedAt).Scan(&client.ID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return secret, client, nil
+}
+
+// GetAll returns every client, newest first
+func (c *OAuthClient) GetAll() ([]*OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `select `+oauthClientColumns+` from oauth_clients order by created_at desc`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+// GetOne returns one client by id
+func (c *OAuthClient) GetOne(id int) (*OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	row := db.QueryRowContext(ctx, `select `+oauthClientColumns+` from oauth_clients where id = $1`, id)
+
+	client, err := scanOAuthClient(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrClientNotFound
+	}
+	return client, err
+}
+
+// Update saves the name and scopes of the client
+func (c *OAuthClient) Update() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	c.UpdatedAt = time.Now()
+	stmt := `update oauth_clients set name = $1, scopes = $2, updated_at = $3 where id = $4`
+
+	_, err := db.ExecContext(ctx, stmt, c.Name, strings.Join(c.Scopes, " "), c.UpdatedAt, c.ID)
+	return err
+}
+
+// ResetSecret replaces the secret of the client, which stops the old one from
+// working, and returns the new one in plain text
+func (c *OAuthClient) ResetSecret() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	secret, hash, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	c.SecretHash, c.UpdatedAt = hash, time.Now()
+	stmt := `update oauth_clients set secret_hash = $1, updated_at = $2 where id = $3`
+
+	if _, err := db.ExecContext(ctx, stmt, c.SecretHash, c.UpdatedAt, c.ID); err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// Revoke stops the client from getting new tokens. Tokens already issued stay
+// valid until they expire.
+func (c *OAuthClient) Revoke() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `update oauth_clients set revoked_at = coalesce(revoked_at, $1) where id = $2`, time.Now(), c.ID)
+	return err
+}
+
+// Authenticate returns the active client matching a client id and secret
+func (c *OAuthClient) Authenticate(clientID, secret string) (*OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	row := db.QueryRowContext(ctx, `select `+oauthClientColumns+` from oauth_clients where client_id = $1`, clientID)
+
+	client, err := scanOAuthClient(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(hashToken(secret))) != 1 || client.RevokedAt != nil {
+		return nil, ErrInvalidClient
+	}
+
+	return client, nil
+}
+
+// scanOAuthClient reads one client from a row selected with oauthClientColumns
+func scanOAuthClient(row scanner) (*OAuthClient, error) {
+	var client OAuthClient
+	var scopes string
+	var revokedAt sql.NullTime
+
+	err := row.Scan(
+		&client.ID,
+		&client.ClientID,
+		&client.SecretHash,
+		&client.Name,
+		&scopes,
+		&client.CreatedAt,
+		&client.UpdatedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	client.Scopes = strings.Fields(scopes)
+	client.RevokedAt = nullTime(revokedAt)
+
+	return &client, nil
+}
@@ -0,0 +1,124 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PasswordReset is the structure which holds one password reset token. Like
+// verification tokens, only the SHA-256 hash of the token is stored.
+type PasswordReset struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	TokenHash string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Force flags the user as having to reset their password, which blocks logins
+// with the current one, and returns a reset token to be delivered to them.
+func (p *PasswordReset) Force(userID int, ttl time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	plainText, hash, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update users set password_reset_required = true, updated_at = $1 where id = $2`, time.Now(), userID)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `insert into password_reset_tokens (user_id, token_hash, expires_at, created_at)
+		values ($1, $2, $3, $4)`
+
+	_, err = tx.ExecContext(ctx, stmt, userID, hash, time.Now().Add(ttl), time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return plainText, nil
+}
+
+// Lookup returns the id of the user a valid reset token belongs to, leaving
+// the token in place
+func (p *PasswordReset) Lookup(plainText string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var userID int
+	query := `select user_id from password_reset_tokens where token_hash = $1 and expires_at > $2`
+
+	err := db.QueryRowContext(ctx, query, hashToken(plainText), time.Now()).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidToken
+		}
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// Redeem deletes a valid reset token, together with every other outstanding
+// token of the user it belongs to, and sets that user's new password. Both
+// happen in one transaction, so a token is never used up without the password
+// being changed. It returns the id of the user.
+func (p *PasswordReset) Redeem(plainText, password string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hashedPassword, err := hasher.Hash(password)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	query := `delete from password_reset_tokens where token_hash = $1 and expires_at > $2 returning user_id`
+
+	err = tx.QueryRowContext(ctx, query, hashToken(plainText), time.Now()).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidToken
+		}
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from password_reset_tokens where user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `update users set password = $1, password_reset_required = false, updated_at = $2 where id = $3`
+
+	_, err = tx.ExecContext(ctx, stmt, hashedPassword, time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
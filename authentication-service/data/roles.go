@@ -0,0 +1,178 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrRoleNotFound is returned when assigning or revoking a role that does not exist
+var ErrRoleNotFound = errors.New("role not found")
+
+// Role is the structure which holds one role and the permissions it grants
+type Role struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// GetAll returns every role together with the permissions it grants
+func (r *Role) GetAll() ([]*Role, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select r.id, r.name, coalesce(r.description, ''), r.created_at, coalesce(p.name, '')
+		from roles r
+		left join role_permissions rp on rp.role_id = r.id
+		left join permissions p on p.id = rp.permission_id
+		order by r.name, p.name`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []*Role
+	byID := make(map[int]*Role)
+
+	for rows.Next() {
+		var role Role
+		var permission string
+
+		err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.CreatedAt, &permission)
+		if err != nil {
+			return nil, err
+		}
+
+		existing, ok := byID[role.ID]
+		if !ok {
+			role.Permissions = []string{}
+			existing = &role
+			byID[role.ID] = existing
+			roles = append(roles, existing)
+		}
+
+		if permission != "" {
+			existing.Permissions = append(existing.Permissions, permission)
+		}
+	}
+
+	return roles, rows.Err()
+}
+
+// AllPermissions returns the name of every permission that can be granted
+func (r *Role) AllPermissions() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return queryStrings(ctx, `select name from permissions order by name`)
+}
+
+// Roles returns the names of the roles assigned to the user
+func (u *User) Roles() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select r.name from roles r
+		join user_roles ur on ur.role_id = r.id
+		where ur.user_id = $1
+		order by r.name`
+
+	return queryStrings(ctx, query, u.ID)
+}
+
+// Permissions returns the distinct permissions granted to the user through all
+// of their roles
+func (u *User) Permissions() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select distinct p.name from permissions p
+		join role_permissions rp on rp.permission_id = p.id
+		join user_roles ur on ur.role_id = rp.role_id
+		where ur.user_id = $1
+		order by p.name`
+
+	return queryStrings(ctx, query, u.ID)
+}
+
+// AssignRole gives the user a role by name. Assigning a role the user already
+// has is not an error.
+func (u *User) AssignRole(role string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into user_roles (user_id, role_id, created_at)
+		select $1, id, $2 from roles where name = $3
+		on conflict (user_id, role_id) do nothing`
+
+	result, err := db.ExecContext(ctx, stmt, u.ID, time.Now(), role)
+	if err != nil {
+		return err
+	}
+
+	// Nothing inserted either means the role does not exist or it was
+	// already assigned, so check which one it was
+	rows, err := result.RowsAffected()
+	if err != nil || rows > 0 {
+		return err
+	}
+
+	return roleExists(ctx, role)
+}
+
+// RevokeRole removes a role from the user. Revoking a role the user does not
+// have is not an error.
+func (u *User) RevokeRole(role string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from user_roles
+		where user_id = $1 and role_id = (select id from roles where name = $2)`
+
+	_, err := db.ExecContext(ctx, stmt, u.ID, role)
+	if err != nil {
+		return err
+	}
+
+	return roleExists(ctx, role)
+}
+
+// roleExists returns ErrRoleNotFound if there is no role with the given name
+func roleExists(ctx context.Context, role string) error {
+	var exists bool
+
+	err := db.QueryRowContext(ctx, `select exists(select 1 from roles where name = $1)`, role).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrRoleNotFound
+	}
+
+	return nil
+}
+
+// queryStrings runs a query selecting a single text column and returns the values
+func queryStrings(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
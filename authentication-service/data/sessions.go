@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrSessionNotFound is returned when terminating a session that does not
+// exist, belongs to someone else or has already ended
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is the structure which holds one login session. A session lasts as
+// long as the access token issued with it, whose jti claim is the session id.
+type Session struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	IPAddress string     `json:"ip_address"`
+	UserAgent string     `json:"user_agent"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Insert opens a session for a user and returns it
+func (s *Session) Insert(userID int, ip, userAgent string, ttl time.Duration) (*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+	session := &Session{
+		UserID:    userID,
+		IPAddress: truncate(ip, 45),
+		UserAgent: truncate(userAgent, 512),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	stmt := `insert into sessions (user_id, ip_address, user_agent, created_at, expires_at)
+		values ($1, $2, $3, $4, $5) returning id`
+
+	err := db.QueryRowContext(ctx, stmt, session.UserID, session.IPAddress, session.UserAgent,
+		session.CreatedAt, session.ExpiresAt).Scan(&session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// GetActiveByUser returns the sessions of a user that have neither expired nor
+// been terminated, newest first
+func (s *Session) GetActiveByUser(userID int) ([]*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, ip_address, user_agent, created_at, expires_at
+		from sessions where user_id = $1 and revoked_at is null and expires_at > $2
+		order by created_at desc`
+
+	rows, err := db.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.IPAddress,
+			&session.UserAgent,
+			&session.CreatedAt,
+			&session.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// IsActive reports whether a session exists and has not been terminated
+func (s *Session) IsActive(id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var revokedAt sql.NullTime
+	err := db.QueryRowContext(ctx, `select revoked_at from sessions where id = $1`, id).Scan(&revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return !revokedAt.Valid, nil
+}
+
+// RevokeAllForUser terminates every active session of a user, so that the
+// access tokens issued with them stop working
+func (s *Session) RevokeAllForUser(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update sessions set revoked_at = $1
+		where user_id = $2 and revoked_at is null and expires_at > $1`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), userID)
+
+	return err
+}
+
+// Revoke terminates one active session of a user
+func (s *Session) Revoke(userID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update sessions set revoked_at = $1
+		where id = $2 and user_id = $3 and revoked_at is null and expires_at > $1`
+
+	result, err := db.ExecContext(ctx, stmt, time.Now(), id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
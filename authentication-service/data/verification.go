@@ -0,0 +1,111 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// ErrInvalidToken is returned when a verification token is unknown, expired or
+// has already been used.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// EmailVerification is the structure which holds one email verification token.
+// Only the SHA-256 hash of the token is stored; the plain token is sent to the
+// user by email and never persisted.
+type EmailVerification struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	TokenHash string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// New generates a verification token for the given user, stores its hash and
+// returns the plain text token to be delivered to the user.
+func (v *EmailVerification) New(userID int, ttl time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	plainText, hash, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `insert into email_verification_tokens (user_id, token_hash, expires_at, created_at)
+		values ($1, $2, $3, $4)`
+
+	_, err = db.ExecContext(ctx, stmt, userID, hash, time.Now().Add(ttl), time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	return plainText, nil
+}
+
+// Verify consumes a verification token and marks the owning user's email as
+// verified. The active flag is left alone, so that verifying cannot undo a
+// deactivation. The token is deleted so it cannot be used twice.
+func (v *EmailVerification) Verify(plainText string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	query := `delete from email_verification_tokens where token_hash = $1 and expires_at > $2 returning user_id`
+
+	err = tx.QueryRowContext(ctx, query, hashToken(plainText), time.Now()).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidToken
+		}
+		return 0, err
+	}
+
+	stmt := `update users set email_verified = true, updated_at = $1 where id = $2`
+
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Any other outstanding tokens for this user are now useless
+	_, err = tx.ExecContext(ctx, `delete from email_verification_tokens where user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// NewToken returns a random URL-safe token together with its hex encoded
+// SHA-256 hash, which is the form that gets stored in the database.
+func NewToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	plainText := base64.RawURLEncoding.EncodeToString(b)
+	return plainText, hashToken(plainText), nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a token.
+func hashToken(plainText string) string {
+	sum := sha256.Sum256([]byte(plainText))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,63 @@
+// Package event publishes authentication events to the logs_topic exchange
+// that listener-service consumes, so that logging never sits in the way of a
+// login.
+package event
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Exchange is the RabbitMQ topic exchange events are published to
+const Exchange = "logs_topic"
+
+// Routing keys, matching the ones listener-service binds to
+const (
+	RoutingInfo    = "log.INFO"
+	RoutingWarning = "log.WARNING"
+)
+
+// Types of authentication events
+const (
+	LoginSuccess    = "login.success"
+	LoginFailure    = "login.failure"
+	LoginLocked     = "login.locked"
+	LoginMFA        = "login.mfa_required"
+	PasswordChanged = "password.changed"
+)
+
+// Event is one authentication event. Name and Data are what listener-service
+// forwards to logger-service; the other fields carry the same event in a
+// structured form for consumers that want it.
+type Event struct {
+	Name string `json:"name"`
+	Data string `json:"data"`
+
+	Type      string    `json:"type"`
+	Reason    string    `json:"reason,omitempty"`
+	UserID    int       `json:"user_id,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// RoutingKey returns the key the event is published with: warnings for failed
+// and blocked logins, info for everything else
+func (e Event) RoutingKey() string {
+	switch e.Type {
+	case LoginFailure, LoginLocked:
+		return RoutingWarning
+	default:
+		return RoutingInfo
+	}
+}
+
+// body encodes the event as the message body
+func (e Event) body() ([]byte, error) {
+	if e.Name == "" {
+		e.Name = "event"
+	}
+	return json.Marshal(e)
+}
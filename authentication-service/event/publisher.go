@@ -0,0 +1,231 @@
+package event
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Channel is the part of an AMQP channel the Publisher needs
+type Channel interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+// Dialer opens a Channel to the broker with the exchange declared
+type Dialer func() (Channel, error)
+
+// Publisher sends events to RabbitMQ from a background goroutine. Events are
+// kept in a bounded in-memory buffer while RabbitMQ cannot be reached and sent
+// once it is back; when the buffer is full the oldest events are dropped.
+type Publisher struct {
+	dial Dialer
+	size int
+	log  *logrus.Logger
+
+	mu     sync.Mutex
+	buffer []Event
+	wake   chan struct{}
+
+	// Backoff is the first delay between reconnection attempts, doubled after
+	// each failure
+	Backoff time.Duration
+
+	// OnDrop, when set, is called for every event dropped from a full buffer
+	OnDrop func(Event)
+}
+
+// NewPublisher returns a Publisher buffering up to size events. Run must be
+// called for anything to be sent.
+func NewPublisher(dial Dialer, size int, log *logrus.Logger) *Publisher {
+	return &Publisher{
+		dial:    dial,
+		size:    size,
+		log:     log,
+		Backoff: time.Second,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Publish queues an event and returns immediately
+func (p *Publisher) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	p.mu.Lock()
+	var dropped []Event
+	if len(p.buffer) >= p.size {
+		dropped = p.buffer[:len(p.buffer)-p.size+1]
+		p.buffer = p.buffer[len(dropped):]
+	}
+	p.buffer = append(p.buffer, e)
+	p.mu.Unlock()
+
+	if p.OnDrop != nil {
+		for _, e := range dropped {
+			p.OnDrop(e)
+		}
+	}
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Buffered returns the number of events waiting to be sent
+func (p *Publisher) Buffered() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.buffer)
+}
+
+// Run sends queued events until ctx is done, reconnecting with a growing
+// delay, capped at 30 seconds, whenever RabbitMQ is unavailable
+func (p *Publisher) Run(ctx context.Context) {
+	var channel Channel
+	backoff := p.Backoff
+
+	defer func() {
+		if channel != nil {
+			channel.Close()
+		}
+	}()
+
+	for {
+		e, ok := p.peek()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.wake:
+				continue
+			}
+		}
+
+		if channel == nil {
+			var err error
+			channel, err = p.dial()
+			if err != nil {
+				p.log.WithError(err).WithField("buffered", p.Buffered()).Warn("RabbitMQ unavailable, buffering auth events")
+				if !sleep(ctx, backoff) {
+					return
+				}
+				backoff = min(backoff*2, 30*time.Second)
+				continue
+			}
+			backoff = p.Backoff
+		}
+
+		if err := p.send(ctx, channel, e); err != nil {
+			p.log.WithError(err).Warn("Failed to publish auth event, reconnecting")
+			channel.Close()
+			channel = nil
+			continue
+		}
+
+		p.pop()
+	}
+}
+
+// send publishes one event
+func (p *Publisher) send(ctx context.Context, channel Channel, e Event) error {
+	body, err := e.body()
+	if err != nil {
+		// An event that cannot be encoded will never be sent, skip it
+		p.log.WithError(err).Error("Failed to encode auth event")
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return channel.PublishWithContext(ctx, Exchange, e.RoutingKey(), false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    e.Time,
+		Body:         body,
+	})
+}
+
+// peek returns the oldest queued event without removing it
+func (p *Publisher) peek() (Event, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.buffer) == 0 {
+		return Event{}, false
+	}
+	return p.buffer[0], true
+}
+
+// pop removes the oldest queued event once it has been sent
+func (p *Publisher) pop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.buffer) > 0 {
+		p.buffer = p.buffer[1:]
+	}
+}
+
+// sleep waits for d or until ctx is done, reporting whether it waited
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// AMQPDialer returns a Dialer connecting to the broker at url and declaring
+// the exchange the same way the other services do
+func AMQPDialer(url string) Dialer {
+	return func() (Channel, error) {
+		conn, err := amqp.Dial(url)
+		if err != nil {
+			return nil, err
+		}
+
+		channel, err := conn.Channel()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		err = channel.ExchangeDeclare(
+			Exchange, // name
+			"topic",  // type
+			true,     // durable?
+			false,    // auto-deleted?
+			false,    // internal?
+			false,    // no-wait?
+			nil,      // arguments?
+		)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return &connChannel{Channel: channel, conn: conn}, nil
+	}
+}
+
+// connChannel closes its connection together with the channel
+type connChannel struct {
+	*amqp.Channel
+	conn *amqp.Connection
+}
+
+func (c *connChannel) Close() error {
+	c.Channel.Close()
+	return c.conn.Close()
+}
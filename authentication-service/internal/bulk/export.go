@@ -0,0 +1,34 @@
+package bulk
+
+import (
+	"authentication/data"
+	"io"
+)
+
+// Export writes every user to w and returns how many were written
+func Export(users *data.User, w io.Writer, format Format) (int, error) {
+	writer := NewWriter(w, format)
+
+	count := 0
+	err := users.Export(func(user *data.User, roles []string) error {
+		active := user.Active == 1
+		count++
+
+		return writer.Write(Record{
+			ID:         user.ID,
+			Email:      user.Email,
+			FirstName:  user.FirstName,
+			LastName:   user.LastName,
+			Active:     &active,
+			Verified:   &user.Verified,
+			MFAEnabled: user.MFAEnabled,
+			Roles:      roles,
+			CreatedAt:  &user.CreatedAt,
+		})
+	})
+	if err != nil {
+		return count, err
+	}
+
+	return count, writer.Flush()
+}
@@ -0,0 +1,339 @@
+// Package bulk imports users from and exports them to CSV and NDJSON files,
+// for the admin API and the "authApp users" command alike.
+package bulk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is a file format users are imported from and exported to
+type Format string
+
+const (
+	CSV    Format = "csv"
+	NDJSON Format = "ndjson"
+)
+
+// ParseFormat accepts a format name, a file extension or a content type
+func ParseFormat(value string) (Format, error) {
+	if mediaType, _, err := mime.ParseMediaType(value); err == nil {
+		value = mediaType
+	}
+
+	switch strings.ToLower(strings.TrimPrefix(value, ".")) {
+	case "csv", "text/csv":
+		return CSV, nil
+	case "ndjson", "jsonl", "application/x-ndjson", "application/jsonl":
+		return NDJSON, nil
+	default:
+		return "", fmt.Errorf("unsupported format %q, use csv or ndjson", value)
+	}
+}
+
+// ContentType returns the media type files of the format are served as
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// rolesSeparator separates role names within a CSV cell
+const rolesSeparator = ";"
+
+// Record is one user in an import or export file. Imports read the password
+// fields, exports write the read-only ones, and both share the rest, so an
+// export can be imported elsewhere once passwords are added.
+type Record struct {
+	// Line is where the record starts in an import file
+	Line int `json:"-"`
+
+	ID           int        `json:"id,omitempty"`
+	Email        string     `json:"email"`
+	FirstName    string     `json:"first_name,omitempty"`
+	LastName     string     `json:"last_name,omitempty"`
+	Password     string     `json:"password,omitempty"`
+	PasswordHash string     `json:"password_hash,omitempty"`
+	Active       *bool      `json:"active,omitempty"`
+	Verified     *bool      `json:"email_verified,omitempty"`
+	MFAEnabled   bool       `json:"mfa_enabled,omitempty"`
+	Roles        []string   `json:"roles,omitempty"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+}
+
+// Columns of CSV files. Read-only columns are accepted on import and
+// ignored.
+var (
+	importColumns   = []string{"email", "first_name", "last_name", "password", "password_hash", "active", "email_verified", "roles"}
+	exportColumns   = []string{"id", "email", "first_name", "last_name", "active", "email_verified", "mfa_enabled", "roles", "created_at"}
+	readOnlyColumns = []string{"id", "mfa_enabled", "created_at"}
+)
+
+// ErrInvalidFile is wrapped by the errors of files that cannot be imported at
+// all
+var ErrInvalidFile = errors.New("invalid import file")
+
+// invalidFile returns an ErrInvalidFile describing the problem
+func invalidFile(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidFile, fmt.Sprintf(format, args...))
+}
+
+// RowError lists what is wrong with one record of an import
+type RowError struct {
+	Line   int      `json:"line"`
+	Email  string   `json:"email,omitempty"`
+	Errors []string `json:"errors"`
+}
+
+// Read parses an import file. Records that cannot be parsed are returned as
+// row errors, an error is only returned when the file as a whole is unusable.
+func Read(r io.Reader, format Format) ([]Record, []RowError, error) {
+	if format == CSV {
+		return readCSV(r)
+	}
+	return readNDJSON(r)
+}
+
+// readCSV parses a CSV file with a header row naming its columns
+func readCSV(r io.Reader) ([]Record, []RowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil, nil, invalidFile("the file is empty")
+		case errors.As(err, &parseErr):
+			return nil, nil, invalidFile("header: %v", parseErr.Err)
+		}
+		return nil, nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !contains(importColumns, name) && !contains(readOnlyColumns, name) {
+			return nil, nil, invalidFile("unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, nil, invalidFile("the email column is required")
+	}
+
+	var records []Record
+	var rowErrors []RowError
+
+	for {
+		fields, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rowErrors = append(rowErrors, RowError{Line: parseErr.StartLine, Errors: []string{parseErr.Err.Error()}})
+				continue
+			}
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		// Passwords are taken as they are, spaces included
+		raw := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(fields) {
+				return fields[i]
+			}
+			return ""
+		}
+		get := func(name string) string {
+			return strings.TrimSpace(raw(name))
+		}
+
+		record := Record{
+			Line:         line,
+			Email:        get("email"),
+			FirstName:    get("first_name"),
+			LastName:     get("last_name"),
+			Password:     raw("password"),
+			PasswordHash: get("password_hash"),
+		}
+
+		var problems []string
+		for _, flag := range []struct {
+			name string
+			dest **bool
+		}{{"active", &record.Active}, {"email_verified", &record.Verified}} {
+			value := get(flag.name)
+			if value == "" {
+				continue
+			}
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s must be true or false", flag.name))
+				continue
+			}
+			*flag.dest = &parsed
+		}
+
+		for _, role := range strings.Split(get("roles"), rolesSeparator) {
+			if role = strings.TrimSpace(role); role != "" {
+				record.Roles = append(record.Roles, role)
+			}
+		}
+
+		if len(problems) > 0 {
+			rowErrors = append(rowErrors, RowError{Line: line, Email: record.Email, Errors: problems})
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records, rowErrors, nil
+}
+
+// readNDJSON parses a file with one JSON object per line
+func readNDJSON(r io.Reader) ([]Record, []RowError, error) {
+	var records []Record
+	var rowErrors []RowError
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var record Record
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&record); err != nil {
+			rowErrors = append(rowErrors, RowError{Line: line, Errors: []string{"invalid JSON: " + err.Error()}})
+			continue
+		}
+
+		record.Line = line
+		record.Email = strings.TrimSpace(record.Email)
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, nil, invalidFile("line %d is too long", line+1)
+		}
+		return nil, nil, err
+	}
+	if line == 0 {
+		return nil, nil, invalidFile("the file is empty")
+	}
+
+	return records, rowErrors, nil
+}
+
+// Writer writes exported users in either format
+type Writer struct {
+	format Format
+	w      *bufio.Writer
+	csv    *csv.Writer
+	header bool
+}
+
+// NewWriter returns a Writer for w
+func NewWriter(w io.Writer, format Format) *Writer {
+	buffered := bufio.NewWriter(w)
+
+	writer := &Writer{format: format, w: buffered}
+	if format == CSV {
+		writer.csv = csv.NewWriter(buffered)
+	}
+	return writer
+}
+
+// Write adds one user. Password fields are never written.
+func (w *Writer) Write(record Record) error {
+	record.Password, record.PasswordHash = "", ""
+
+	if w.format == NDJSON {
+		out, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if _, err := w.w.Write(out); err != nil {
+			return err
+		}
+		return w.w.WriteByte('\n')
+	}
+
+	if !w.header {
+		if err := w.csv.Write(exportColumns); err != nil {
+			return err
+		}
+		w.header = true
+	}
+
+	createdAt := ""
+	if record.CreatedAt != nil {
+		createdAt = record.CreatedAt.UTC().Format(time.RFC3339)
+	}
+
+	return w.csv.Write([]string{
+		strconv.Itoa(record.ID),
+		record.Email,
+		record.FirstName,
+		record.LastName,
+		formatBool(record.Active),
+		formatBool(record.Verified),
+		strconv.FormatBool(record.MFAEnabled),
+		strings.Join(record.Roles, rolesSeparator),
+		createdAt,
+	})
+}
+
+// Flush writes out anything buffered. A CSV export without users still gets
+// its header.
+func (w *Writer) Flush() error {
+	if w.csv != nil {
+		if !w.header {
+			if err := w.csv.Write(exportColumns); err != nil {
+				return err
+			}
+			w.header = true
+		}
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return w.w.Flush()
+}
+
+// formatBool writes an optional flag, unset flags as false
+func formatBool(value *bool) string {
+	return strconv.FormatBool(value != nil && *value)
+}
+
+// contains reports whether list holds value
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package bulk
+
+import (
+	"authentication/data"
+	"authentication/internal/password"
+	"fmt"
+	"io"
+	"net/mail"
+	"sort"
+	"strings"
+)
+
+// Options controls an import
+type Options struct {
+	// DryRun checks every record, against the database too, without
+	// creating anyone
+	DryRun bool
+
+	// Atomic creates nobody unless every record can be created
+	Atomic bool
+
+	// Policy is checked for plain text passwords. Hashed passwords cannot
+	// be checked and are taken as they are.
+	Policy password.Policy
+
+	// DefaultRole is given to users imported without roles
+	DefaultRole string
+}
+
+// Report tells how an import went, row by row for failures
+type Report struct {
+	Rows     int  `json:"rows"`
+	Valid    int  `json:"valid"`
+	Imported int  `json:"imported"`
+	Failed   int  `json:"failed"`
+	DryRun   bool `json:"dry_run"`
+	Atomic   bool `json:"atomic"`
+
+	Users  []ImportedUser `json:"users"`
+	Errors []RowError     `json:"errors"`
+}
+
+// ImportedUser is a user created by an import
+type ImportedUser struct {
+	Line  int    `json:"line"`
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+}
+
+// Import reads users from r and creates them. Imported users are active and
+// verified unless their record says otherwise, since nobody would receive a
+// verification email for them.
+func Import(users *data.User, r io.Reader, format Format, opts Options) (*Report, error) {
+	records, rowErrors, err := Read(r, format)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Rows:   len(records) + len(rowErrors),
+		DryRun: opts.DryRun,
+		Atomic: opts.Atomic,
+		Users:  []ImportedUser{},
+		Errors: rowErrors,
+	}
+
+	var valid []Record
+	var toImport []data.ImportUser
+	seen := make(map[string]int, len(records))
+
+	for _, record := range records {
+		problems := validate(record, opts.Policy)
+
+		key := strings.ToLower(record.Email)
+		if line, ok := seen[key]; ok && key != "" {
+			problems = append(problems, fmt.Sprintf("email is also on line %d", line))
+		} else {
+			seen[key] = record.Line
+		}
+
+		if len(problems) > 0 {
+			report.Errors = append(report.Errors, RowError{Line: record.Line, Email: record.Email, Errors: problems})
+			continue
+		}
+
+		roles := uniqueRoles(record.Roles)
+		if len(roles) == 0 && opts.DefaultRole != "" {
+			roles = []string{opts.DefaultRole}
+		}
+
+		valid = append(valid, record)
+		toImport = append(toImport, data.ImportUser{
+			Email:        record.Email,
+			FirstName:    record.FirstName,
+			LastName:     record.LastName,
+			Password:     record.Password,
+			PasswordHash: record.PasswordHash,
+			Active:       record.Active == nil || *record.Active,
+			Verified:     record.Verified == nil || *record.Verified,
+			Roles:        roles,
+		})
+	}
+
+	// With atomic, a row already known to be bad means nothing is created,
+	// the rest are still tried to report every problem at once
+	dryRun := opts.DryRun || (opts.Atomic && len(report.Errors) > 0)
+
+	if len(toImport) > 0 {
+		results, committed, err := users.Import(toImport, opts.Atomic, dryRun)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, result := range results {
+			record := valid[i]
+			if result.Err != nil {
+				report.Errors = append(report.Errors, RowError{Line: record.Line, Email: record.Email, Errors: []string{result.Err.Error()}})
+				continue
+			}
+
+			report.Valid++
+			if committed {
+				report.Users = append(report.Users, ImportedUser{Line: record.Line, ID: result.ID, Email: record.Email})
+			}
+		}
+	}
+
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Line < report.Errors[j].Line })
+	report.Imported = len(report.Users)
+	report.Failed = len(report.Errors)
+
+	return report, nil
+}
+
+// validate returns what is wrong with a record before it gets to the database
+func validate(record Record, policy password.Policy) []string {
+	var problems []string
+
+	if record.Email == "" {
+		problems = append(problems, "email is required")
+	} else if address, err := mail.ParseAddress(record.Email); err != nil || address.Address != record.Email {
+		problems = append(problems, "email is not a valid address")
+	}
+
+	switch {
+	case record.Password != "" && record.PasswordHash != "":
+		problems = append(problems, "only one of password and password_hash may be set")
+	case record.Password != "":
+		for _, v := range policy.Check(record.Password, record.Email, record.FirstName, record.LastName) {
+			problems = append(problems, "password "+v.Message)
+		}
+	case record.PasswordHash != "":
+		if err := data.ValidHash(record.PasswordHash); err != nil {
+			problems = append(problems, "password_hash: "+err.Error())
+		}
+	default:
+		problems = append(problems, "password or password_hash is required")
+	}
+
+	return problems
+}
+
+// uniqueRoles drops repeated role names
+func uniqueRoles(roles []string) []string {
+	var unique []string
+	for _, role := range roles {
+		if !contains(unique, role) {
+			unique = append(unique, role)
+		}
+	}
+	return unique
+}
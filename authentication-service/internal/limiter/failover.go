@@ -0,0 +1,141 @@
+package limiter
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Pinger is a Limiter whose backend can be health checked
+type Pinger interface {
+	Limiter
+	Ping(ctx context.Context) error
+}
+
+// Failover uses a primary Limiter while it works and a fallback one while it
+// does not. The first error from the primary switches over to the fallback, and
+// Watch switches back once the primary answers pings again. Failures counted by
+// the fallback are not copied back.
+type Failover struct {
+	primary  Pinger
+	fallback Limiter
+	healthy  atomic.Bool
+
+	// OnChange, when set, is called each time the primary goes down or
+	// comes back
+	OnChange func(healthy bool, err error)
+}
+
+// NewFailover returns a Failover starting on the primary if it answers a ping
+func NewFailover(ctx context.Context, primary Pinger, fallback Limiter) *Failover {
+	f := &Failover{primary: primary, fallback: fallback}
+	f.healthy.Store(primary.Ping(ctx) == nil)
+	return f
+}
+
+// Healthy reports whether the primary Limiter is in use
+func (f *Failover) Healthy() bool {
+	return f.healthy.Load()
+}
+
+// Watch pings the primary every interval until ctx is done, switching back to
+// it once it recovers
+func (f *Failover) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, interval)
+			f.setHealthy(f.primary.Ping(pingCtx))
+			cancel()
+		}
+	}
+}
+
+// setHealthy records the outcome of talking to the primary
+func (f *Failover) setHealthy(err error) {
+	healthy := err == nil
+	if f.healthy.Swap(healthy) != healthy && f.OnChange != nil {
+		f.OnChange(healthy, err)
+	}
+}
+
+// run calls op on the primary, or on the fallback if the primary is down or
+// fails
+func (f *Failover) run(op func(Limiter) error) error {
+	if f.Healthy() {
+		err := op(f.primary)
+		if err == nil {
+			return nil
+		}
+		f.setHealthy(err)
+	}
+	return op(f.fallback)
+}
+
+// Fail counts a failure on the Limiter in use
+func (f *Failover) Fail(ctx context.Context, key string, window time.Duration) (int64, error) {
+	var count int64
+	err := f.run(func(l Limiter) (err error) {
+		count, err = l.Fail(ctx, key, window)
+		return err
+	})
+	return count, err
+}
+
+// Block starts a wait on the Limiter in use
+func (f *Failover) Block(ctx context.Context, key string, d time.Duration) error {
+	return f.run(func(l Limiter) error {
+		return l.Block(ctx, key, d)
+	})
+}
+
+// Delay returns the wait left on the Limiter in use
+func (f *Failover) Delay(ctx context.Context, key string) (time.Duration, error) {
+	var delay time.Duration
+	err := f.run(func(l Limiter) (err error) {
+		delay, err = l.Delay(ctx, key)
+		return err
+	})
+	return delay, err
+}
+
+// Put stores a value on the Limiter in use. A value stored on the fallback is
+// lost once the primary is back, which for short lived values such as MFA
+// challenges only means starting over.
+func (f *Failover) Put(ctx context.Context, key, value string, ttl time.Duration) error {
+	return f.run(func(l Limiter) error {
+		return l.Put(ctx, key, value, ttl)
+	})
+}
+
+// Get returns a value from the Limiter in use
+func (f *Failover) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := f.run(func(l Limiter) (err error) {
+		value, err = l.Get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+// Reset forgets keys on both Limiters, so that an unlock also clears what was
+// counted during an outage
+func (f *Failover) Reset(ctx context.Context, keys ...string) error {
+	f.fallback.Reset(ctx, keys...)
+	return f.run(func(l Limiter) error {
+		return l.Reset(ctx, keys...)
+	})
+}
+
+// ResetPrefix forgets matching keys on both Limiters
+func (f *Failover) ResetPrefix(ctx context.Context, prefix string) error {
+	f.fallback.ResetPrefix(ctx, prefix)
+	return f.run(func(l Limiter) error {
+		return l.ResetPrefix(ctx, prefix)
+	})
+}
@@ -0,0 +1,31 @@
+// Package limiter stores the failed attempt counters and waits behind the login
+// brute-force protection, and other short lived login state such as MFA
+// challenges. Keys live in Redis so that every replica sees them, with an
+// in-process store to fall back on while Redis is down.
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// Limiter keeps failure counters and waits, both identified by key
+type Limiter interface {
+	// Fail counts a failure on key and returns the number of failures seen
+	// within window of the first one
+	Fail(ctx context.Context, key string, window time.Duration) (int64, error)
+
+	// Block makes key wait for d. Delay reports what is left of the wait.
+	Block(ctx context.Context, key string, d time.Duration) error
+	Delay(ctx context.Context, key string) (time.Duration, error)
+
+	// Put stores value under key for ttl. Get returns it, or an empty
+	// string once it has expired or been reset.
+	Put(ctx context.Context, key, value string, ttl time.Duration) error
+	Get(ctx context.Context, key string) (string, error)
+
+	// Reset forgets the given keys and ResetPrefix every key starting with
+	// prefix
+	Reset(ctx context.Context, keys ...string) error
+	ResetPrefix(ctx context.Context, prefix string) error
+}
@@ -0,0 +1,145 @@
+package limiter
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired keys are dropped from a Memory limiter
+const sweepInterval = time.Minute
+
+// Memory is a Limiter kept in the memory of the process. Each replica counts
+// on its own, so it is only meant to stand in while Redis is unavailable.
+type Memory struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type memoryEntry struct {
+	count   int64
+	value   string
+	expires time.Time
+}
+
+// NewMemory returns an empty in-process Limiter
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]*memoryEntry), now: time.Now}
+}
+
+// Fail increments the counter and restarts its expiry
+func (l *Memory) Fail(ctx context.Context, key string, window time.Duration) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	entry := l.live(key, now)
+	if entry == nil {
+		entry = &memoryEntry{}
+		l.entries[key] = entry
+	}
+	entry.count++
+	entry.expires = now.Add(window)
+
+	return entry.count, nil
+}
+
+// Block stores a key expiring once the wait is over
+func (l *Memory) Block(ctx context.Context, key string, d time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	l.entries[key] = &memoryEntry{count: 1, expires: now.Add(d)}
+
+	return nil
+}
+
+// Delay returns the time left before key expires
+func (l *Memory) Delay(ctx context.Context, key string) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if entry := l.live(key, now); entry != nil {
+		return entry.expires.Sub(now), nil
+	}
+	return 0, nil
+}
+
+// Put stores a key expiring after ttl
+func (l *Memory) Put(ctx context.Context, key, value string, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	l.entries[key] = &memoryEntry{value: value, expires: now.Add(ttl)}
+
+	return nil
+}
+
+// Get returns the value of key unless it has expired
+func (l *Memory) Get(ctx context.Context, key string) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry := l.live(key, l.now()); entry != nil {
+		return entry.value, nil
+	}
+	return "", nil
+}
+
+// Reset deletes the keys
+func (l *Memory) Reset(ctx context.Context, keys ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		delete(l.entries, key)
+	}
+	return nil
+}
+
+// ResetPrefix deletes every key starting with prefix
+func (l *Memory) ResetPrefix(ctx context.Context, prefix string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key := range l.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(l.entries, key)
+		}
+	}
+	return nil
+}
+
+// live returns the entry for key unless it has expired
+func (l *Memory) live(key string, now time.Time) *memoryEntry {
+	entry, ok := l.entries[key]
+	if !ok || !now.Before(entry.expires) {
+		return nil
+	}
+	return entry
+}
+
+// sweep drops expired entries, at most once per sweepInterval, so that keys
+// which are never looked at again do not pile up
+func (l *Memory) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, entry := range l.entries {
+		if !now.Before(entry.expires) {
+			delete(l.entries, key)
+		}
+	}
+}
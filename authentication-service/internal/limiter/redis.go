@@ -0,0 +1,105 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis is a Limiter shared by every replica through Redis
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis returns a Limiter storing its keys in Redis
+func NewRedis(client *redis.Client) *Redis {
+	return &Redis{client: client}
+}
+
+// Fail increments the counter and restarts its expiry
+func (l *Redis) Fail(ctx context.Context, key string, window time.Duration) (int64, error) {
+	pipe := l.client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, window)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// Block stores a key expiring once the wait is over
+func (l *Redis) Block(ctx context.Context, key string, d time.Duration) error {
+	return l.client.Set(ctx, key, 1, d).Err()
+}
+
+// Delay returns the time left before key expires
+func (l *Redis) Delay(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := l.client.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// PTTL reports missing keys and keys without expiry as negative values
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// Put stores a key expiring after ttl
+func (l *Redis) Put(ctx context.Context, key, value string, ttl time.Duration) error {
+	return l.client.Set(ctx, key, value, ttl).Err()
+}
+
+// Get returns the value of key, treating a missing key as empty
+func (l *Redis) Get(ctx context.Context, key string) (string, error) {
+	value, err := l.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return value, err
+}
+
+// Reset deletes the keys
+func (l *Redis) Reset(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return l.client.Del(ctx, keys...).Err()
+}
+
+// ResetPrefix finds the keys with SCAN and deletes them
+func (l *Redis) ResetPrefix(ctx context.Context, prefix string) error {
+	var keys []string
+
+	iter := l.client.Scan(ctx, 0, globEscape(prefix)+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	return l.Reset(ctx, keys...)
+}
+
+// Ping checks that Redis can be reached
+func (l *Redis) Ping(ctx context.Context) error {
+	return l.client.Ping(ctx).Err()
+}
+
+// globEscape escapes the characters SCAN patterns treat specially
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
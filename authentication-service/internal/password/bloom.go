@@ -0,0 +1,221 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// bloomMagic starts every bloom filter file
+const bloomMagic = "PWBLOOM1"
+
+// Bloom is a bloom filter of the SHA-1 hashes of breached passwords. It never
+// misses a password that was added and wrongly reports a password as breached
+// at the false positive rate it was sized for, which for a password check only
+// means asking the user for another one. Hashing with SHA-1 means lists in the
+// format published by Have I Been Pwned can be loaded as they are.
+type Bloom struct {
+	bits   []uint64
+	m      uint64
+	k      uint32
+	filled uint64
+}
+
+// NewBloom sizes a filter for n passwords with the given false positive rate
+func NewBloom(n int, falsePositiveRate float64) *Bloom {
+	if n < 1 {
+		n = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.001
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return &Bloom{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// Add puts a password in the filter
+func (b *Bloom) Add(password string) {
+	b.AddHash(sha1.Sum([]byte(password)))
+}
+
+// AddHash puts a password in the filter by its SHA-1 hash
+func (b *Bloom) AddHash(hash [sha1.Size]byte) {
+	h1, h2 := b.split(hash)
+	for i := uint64(0); i < uint64(b.k); i++ {
+		bit := (h1 + i*h2) % b.m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+	b.filled++
+}
+
+// Contains reports whether the password is, most likely, in the filter
+func (b *Bloom) Contains(password string) bool {
+	h1, h2 := b.split(sha1.Sum([]byte(password)))
+	for i := uint64(0); i < uint64(b.k); i++ {
+		bit := (h1 + i*h2) % b.m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns the number of passwords added to the filter
+func (b *Bloom) Len() int {
+	return int(b.filled)
+}
+
+// split derives the two hashes the bit positions are computed from, see
+// Kirsch and Mitzenmacher, "Less Hashing, Same Performance"
+func (b *Bloom) split(hash [sha1.Size]byte) (uint64, uint64) {
+	h1 := binary.BigEndian.Uint64(hash[0:8])
+	h2 := binary.BigEndian.Uint64(hash[8:16]) | 1
+	return h1, h2
+}
+
+// WriteTo saves the filter in the format ReadBloom reads
+func (b *Bloom) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, len(bloomMagic)+20)
+	copy(header, bloomMagic)
+	binary.BigEndian.PutUint64(header[8:], b.m)
+	binary.BigEndian.PutUint32(header[16:], b.k)
+	binary.BigEndian.PutUint64(header[20:], b.filled)
+
+	bw := bufio.NewWriter(w)
+	n, err := bw.Write(header)
+	if err != nil {
+		return int64(n), err
+	}
+
+	written := int64(n)
+	if err := binary.Write(bw, binary.BigEndian, b.bits); err != nil {
+		return written, err
+	}
+	written += int64(len(b.bits) * 8)
+
+	return written, bw.Flush()
+}
+
+// ReadBloom loads a filter saved with WriteTo
+func ReadBloom(r io.Reader) (*Bloom, error) {
+	header := make([]byte, len(bloomMagic)+20)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("read bloom filter header: %w", err)
+	}
+	if string(header[:len(bloomMagic)]) != bloomMagic {
+		return nil, errors.New("not a breached password bloom filter")
+	}
+
+	b := &Bloom{
+		m:      binary.BigEndian.Uint64(header[8:]),
+		k:      binary.BigEndian.Uint32(header[16:]),
+		filled: binary.BigEndian.Uint64(header[20:]),
+	}
+	if b.m == 0 || b.k == 0 {
+		return nil, errors.New("corrupt bloom filter header")
+	}
+
+	b.bits = make([]uint64, (b.m+63)/64)
+	if err := binary.Read(bufio.NewReader(r), binary.BigEndian, b.bits); err != nil {
+		return nil, fmt.Errorf("read bloom filter: %w", err)
+	}
+
+	return b, nil
+}
+
+// BuildBloom makes a filter from a list with one breached password per line.
+// Lines may also hold the hex SHA-1 of a password, optionally followed by a
+// colon and a count as in the Have I Been Pwned downloads. n is the expected
+// number of lines.
+func BuildBloom(r io.Reader, n int, falsePositiveRate float64) (*Bloom, error) {
+	b := NewBloom(n, falsePositiveRate)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		if hash, ok := parseSHA1(line); ok {
+			b.AddHash(hash)
+			continue
+		}
+		b.Add(line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read breached password list: %w", err)
+	}
+	return b, nil
+}
+
+// parseSHA1 parses a "HASH" or "HASH:count" line of a hash list
+func parseSHA1(line string) ([sha1.Size]byte, bool) {
+	var hash [sha1.Size]byte
+
+	value, _, _ := strings.Cut(line, ":")
+	if len(value) != hex.EncodedLen(sha1.Size) {
+		return hash, false
+	}
+	if _, err := hex.Decode(hash[:], []byte(value)); err != nil {
+		return hash, false
+	}
+	return hash, true
+}
+
+// LoadBloom opens a breached password list, either a filter saved with
+// WriteTo or a plain list as read by BuildBloom
+func LoadBloom(path string, falsePositiveRate float64) (*Bloom, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(bloomMagic))
+	if _, err := io.ReadFull(f, magic); err == nil && string(magic) == bloomMagic {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return ReadBloom(f)
+	}
+
+	lines, err := countLines(f)
+	if err != nil {
+		return nil, err
+	}
+	return BuildBloom(f, lines, falsePositiveRate)
+}
+
+// countLines counts the lines of f and rewinds it
+func countLines(f *os.File) (int, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines++
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("read breached password list: %w", err)
+	}
+
+	_, err := f.Seek(0, io.SeekStart)
+	return lines, err
+}
@@ -0,0 +1,166 @@
+// Package password decides which passwords users may choose: a configurable
+// policy of length and character class rules, a ban on passwords containing
+// the user's own details, and a check against a list of breached passwords.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rules a password can violate, reported as the rule of a Violation
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleLowercase = "lowercase"
+	RuleUppercase = "uppercase"
+	RuleDigit     = "digit"
+	RuleSymbol    = "symbol"
+	RulePersonal  = "personal_info"
+	RuleBreached  = "breached"
+)
+
+// minPersonalLength is the shortest name or email part that is looked for in
+// passwords, shorter ones would match too many unrelated passwords
+const minPersonalLength = 3
+
+// BreachedList tells whether a password is known to have leaked
+type BreachedList interface {
+	Contains(password string) bool
+}
+
+// Policy is the set of rules new passwords are checked against. Lengths are
+// counted in characters. A zero Policy accepts any password.
+type Policy struct {
+	MinLength int
+	MaxLength int
+
+	RequireLowercase bool
+	RequireUppercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+
+	// DisallowPersonal rejects passwords containing the email, or a part
+	// of it, or the name of the user
+	DisallowPersonal bool
+
+	// Breached, when set, rejects passwords found in a breach
+	Breached BreachedList
+}
+
+// DefaultPolicy returns the policy used unless configured otherwise. It
+// follows NIST SP 800-63B: a decent length and no breached or personal
+// passwords, rather than composition rules.
+func DefaultPolicy() Policy {
+	return Policy{
+		MinLength:        10,
+		MaxLength:        128,
+		DisallowPersonal: true,
+	}
+}
+
+// Violation is a rule a password breaks and a description for the user
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Check returns every rule the password breaks, or nil if it is acceptable.
+// personal holds the details of the user, such as their email and names.
+func (p Policy) Check(password string, personal ...string) []Violation {
+	var violations []Violation
+	add := func(rule, format string, args ...any) {
+		violations = append(violations, Violation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		add(RuleMinLength, "must be at least %d characters long", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add(RuleMaxLength, "must be at most %d characters long", p.MaxLength)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		case !unicode.IsSpace(c):
+			symbol = true
+		}
+	}
+
+	if p.RequireLowercase && !lower {
+		add(RuleLowercase, "must contain a lowercase letter")
+	}
+	if p.RequireUppercase && !upper {
+		add(RuleUppercase, "must contain an uppercase letter")
+	}
+	if p.RequireDigit && !digit {
+		add(RuleDigit, "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		add(RuleSymbol, "must contain a symbol")
+	}
+
+	if p.DisallowPersonal {
+		if part := personalPart(password, personal); part != "" {
+			add(RulePersonal, "must not contain %q from your name or email", part)
+		}
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		add(RuleBreached, "has appeared in a data breach, choose another one")
+	}
+
+	return violations
+}
+
+// personalPart returns the first of the user's details found in the password
+func personalPart(password string, personal []string) string {
+	password = strings.ToLower(password)
+
+	for _, value := range personalParts(personal) {
+		if strings.Contains(password, value) {
+			return value
+		}
+	}
+	return ""
+}
+
+// personalParts splits the details of a user into the words worth looking
+// for: whole values, the local part of emails and the words of names and
+// local parts
+func personalParts(personal []string) []string {
+	var parts []string
+	add := func(value string) {
+		if utf8.RuneCountInString(value) >= minPersonalLength {
+			parts = append(parts, value)
+		}
+	}
+
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		add(value)
+
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			add(local)
+			value = local
+		}
+
+		for _, word := range strings.FieldsFunc(value, func(c rune) bool {
+			return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+		}) {
+			add(word)
+		}
+	}
+
+	return parts
+}
@@ -0,0 +1,238 @@
+package integration
+
+import (
+	"authentication/data"
+	"authentication/pkg/authz"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var adminSecret = []byte("admin-test-secret")
+
+func adminRequest(t *testing.T, method, target string, body []byte, permissions ...string) *http.Request {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	if permissions != nil {
+		token, err := authz.Sign(adminSecret, authz.Claims{
+			Email:            "admin@example.com",
+			Permissions:      permissions,
+			RegisteredClaims: jwt.RegisteredClaims{Subject: "1"},
+		}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req
+}
+
+func TestAdminRoutesRequirePermissions(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+	routes := handlerApp.Routes()
+
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, adminRequest(t, http.MethodGet, "/admin/users", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without token, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, adminRequest(t, http.MethodGet, "/admin/users", nil, "logs:read"))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status %d without permission, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, adminRequest(t, http.MethodPut, "/admin/users/2", []byte(`{}`), "users:read"))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for write with read permission, got %d", http.StatusForbidden, rec.Code)
+	}
+}
+
+func TestAdminListUsers(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+
+	mock.ExpectQuery("select count").
+		WithArgs("%smith%").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("select (.+) from users where (.+) order by id limit").
+		WithArgs("%smith%", 10, 10).
+		WillReturnRows(userRows().
+			AddRow(2, "john@example.com", "John", "Smith", "hash", 1, true, false, "", false, time.Now(), time.Now()))
+
+	rec := httptest.NewRecorder()
+	handlerApp.Routes().ServeHTTP(rec, adminRequest(t, http.MethodGet, "/admin/users?search=smith&page=2&per_page=10", nil, "users:read"))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestAdminUpdateUserIsAudited(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+
+	mock.ExpectQuery("select (.+) from users where id").
+		WithArgs(2).
+		WillReturnRows(userRows().
+			AddRow(2, "john@example.com", "John", "Smith", "hash", 1, true, false, "", false, time.Now(), time.Now()))
+	mock.ExpectExec("update users set").
+		WithArgs("John", "Smith", 0, sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("update sessions set revoked_at").
+		WithArgs(sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("insert into admin_audit_log").
+		WithArgs(1, "admin@example.com", "user.update", 2, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rec := httptest.NewRecorder()
+	handlerApp.Routes().ServeHTTP(rec, adminRequest(t, http.MethodPut, "/admin/users/2", []byte(`{"active": 0}`), "users:write"))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestDeactivatedUserTokenIsRefused(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+	routes := handlerApp.Routes()
+
+	mock.ExpectQuery("select (.+) from users where id").
+		WithArgs(2).
+		WillReturnRows(userRows().
+			AddRow(2, "user@example.com", "John", "Smith", "hash", 1, true, false, "", false, time.Now(), time.Now()))
+	mock.ExpectExec("update users set").
+		WithArgs("John", "Smith", 0, sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("update sessions set revoked_at (.+) where user_id = \\$2").
+		WithArgs(sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("insert into admin_audit_log").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, adminRequest(t, http.MethodPut, "/admin/users/2", []byte(`{"active": 0}`), "users:write"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	// Session 9 of user 2 has been revoked along with the others
+	mock.ExpectQuery("select revoked_at from sessions").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"revoked_at"}).AddRow(time.Now()))
+
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, sessionRequest(t, http.MethodGet, "/me/logins"))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d for a deactivated user, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestAdminForcePasswordResetTerminatesSessions(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+
+	mock.ExpectQuery("select (.+) from users where id").
+		WithArgs(2).
+		WillReturnRows(userRows().
+			AddRow(2, "user@example.com", "John", "Smith", "hash", 1, true, false, "", false, time.Now(), time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec("update users set password_reset_required = true").
+		WithArgs(sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("insert into password_reset_tokens").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("update sessions set revoked_at").
+		WithArgs(sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("insert into admin_audit_log").
+		WithArgs(1, "admin@example.com", "user.force_password_reset", 2, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rec := httptest.NewRecorder()
+	handlerApp.Routes().ServeHTTP(rec, adminRequest(t, http.MethodPost, "/admin/users/2/password-reset", nil, "users:write"))
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestAdminAnonymizeKeepsEmailOutOfAuditLog(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+
+	mock.ExpectQuery("select (.+) from users where id").
+		WithArgs(7).
+		WillReturnRows(userRows().
+			AddRow(7, "john@example.com", "John", "Smith", "hash", 1, true, false, "", false, time.Now(), time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec("update users set").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("update login_history set").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("delete from sessions").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectExec("insert into admin_audit_log").
+		WithArgs(1, "admin@example.com", "user.anonymize", 7, []byte("null"), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rec := httptest.NewRecorder()
+	handlerApp.Routes().ServeHTTP(rec, adminRequest(t, http.MethodDelete, "/admin/users/7?mode=anonymize", nil, "users:write"))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestAnonymizeClearsLoginHistoryAndSessions(t *testing.T) {
+	user := data.User{ID: 7}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("update users set").
+		WithArgs("deleted-7@anonymized.invalid", sqlmock.AnyArg(), sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("update login_history set email = \\$1, ip_address = '', user_agent = ''").
+		WithArgs("deleted-7@anonymized.invalid", 7).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("delete from sessions").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	if err := user.Anonymize(); err != nil {
+		t.Fatalf("Anonymize failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
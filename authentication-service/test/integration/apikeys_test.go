@@ -0,0 +1,159 @@
+package integration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// apiKeyRows returns an empty result set with the columns loaded for a key
+func apiKeyRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "service", "prefix", "key_hash", "scopes",
+		"created_at", "last_used_at", "expires_at", "revoked_at"})
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+	routes := handlerApp.Routes()
+
+	mock.ExpectQuery("select name from permissions").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("logs:read").AddRow("logs:write"))
+	mock.ExpectQuery("insert into api_keys").
+		WithArgs("broker-service", sqlmock.AnyArg(), sqlmock.AnyArg(), "logs:write", sqlmock.AnyArg(), nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, adminRequest(t, http.MethodPost, "/admin/api-keys",
+		[]byte(`{"service":"broker-service","scopes":["logs:write"]}`), "api_keys:write", "logs:write"))
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	var created struct {
+		Data struct {
+			Key    string `json:"key"`
+			APIKey struct {
+				Prefix string `json:"prefix"`
+			} `json:"api_key"`
+		} `json:"data"`
+	}
+	json.NewDecoder(rec.Body).Decode(&created)
+
+	key, prefix := created.Data.Key, created.Data.APIKey.Prefix
+	if !strings.HasPrefix(key, prefix+"_") || !strings.HasPrefix(prefix, "ak_") {
+		t.Fatalf("expected key %q to start with its prefix %q", key, prefix)
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+
+	validate := func(target, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		return rec
+	}
+
+	mock.ExpectQuery("select (.+) from api_keys where prefix").
+		WithArgs(prefix).
+		WillReturnRows(apiKeyRows().AddRow(3, "broker-service", prefix, hash, "logs:write", time.Now(), nil, nil, nil))
+	mock.ExpectExec("update api_keys set last_used_at").
+		WithArgs(sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rec = validate("/api-keys/validate?scope=logs:write", key)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"service":"broker-service"`) {
+		t.Errorf("expected the service identity in %s", rec.Body.String())
+	}
+
+	// A revoked key is refused
+	mock.ExpectQuery("select (.+) from api_keys where prefix").
+		WithArgs(prefix).
+		WillReturnRows(apiKeyRows().AddRow(3, "broker-service", prefix, hash, "logs:write", time.Now(), nil, nil, time.Now()))
+
+	if rec := validate("/api-keys/validate", key); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	// Malformed keys never reach the database
+	if rec := validate("/api-keys/validate", "not-a-key"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("malformed key: expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestCreateAPIKeyRejectsUnknownScopes(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+
+	mock.ExpectQuery("select name from permissions").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("logs:read"))
+
+	rec := httptest.NewRecorder()
+	handlerApp.Routes().ServeHTTP(rec, adminRequest(t, http.MethodPost, "/admin/api-keys",
+		[]byte(`{"service":"broker-service","scopes":["everything"]}`), "api_keys:write"))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestCreateAPIKeyRefusesScopesTheCallerLacks(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+
+	mock.ExpectQuery("select name from permissions").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("users:write").AddRow("api_keys:write"))
+
+	rec := httptest.NewRecorder()
+	handlerApp.Routes().ServeHTTP(rec, adminRequest(t, http.MethodPost, "/admin/api-keys",
+		[]byte(`{"service":"broker-service","scopes":["users:write"]}`), "api_keys:write"))
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusForbidden, rec.Code, rec.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestRotateAPIKeyRefusesScopesTheCallerLacks(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+
+	mock.ExpectQuery("select (.+) from api_keys where id").
+		WithArgs(3).
+		WillReturnRows(apiKeyRows().AddRow(3, "ops-service", "ak_1a2b3c4d", "hash", "users:write", time.Now(), nil, nil, nil))
+
+	rec := httptest.NewRecorder()
+	handlerApp.Routes().ServeHTTP(rec, adminRequest(t, http.MethodPost, "/admin/api-keys/3/rotate", nil, "api_keys:write"))
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusForbidden, rec.Code, rec.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package integration
+
+import (
+	"authentication/internal/bulk"
+	"authentication/internal/password"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgconn"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// importFile has one good row, one with a weak password and one whose email
+// is already taken
+func importFile(t *testing.T) []byte {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("imported elsewhere"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []byte("email,first_name,last_name,password,password_hash,roles\n" +
+		"ann@example.com,Ann,Lee,correct horse battery,,admin;user\n" +
+		"bob@example.com,Bob,Ray,short,,\n" +
+		"taken@example.com,Tom,Kay,," + string(hash) + ",\n")
+}
+
+// expectImportRows expects the good and the taken row to be tried
+func expectImportRows() {
+	mock.ExpectBegin()
+
+	mock.ExpectExec("savepoint import_user").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("insert into users").
+		WithArgs("ann@example.com", "Ann", "Lee", sqlmock.AnyArg(), 1, true, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+	mock.ExpectExec("insert into user_roles").
+		WithArgs(10, sqlmock.AnyArg(), "admin").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("insert into user_roles").
+		WithArgs(10, sqlmock.AnyArg(), "user").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("release savepoint import_user").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	mock.ExpectExec("savepoint import_user").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("insert into users").
+		WithArgs("taken@example.com", "Tom", "Kay", sqlmock.AnyArg(), 1, true, sqlmock.AnyArg()).
+		WillReturnError(&pgconn.PgError{Code: "23505"})
+	mock.ExpectExec("rollback to savepoint import_user").WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// decodeReport reads the import report from a response
+func decodeReport(t *testing.T, rec *httptest.ResponseRecorder) bulk.Report {
+	t.Helper()
+
+	var resp struct {
+		Data bulk.Report `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Data
+}
+
+func TestImportUsersReportsEveryFailedRow(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+	handlerApp.PasswordPolicy = password.DefaultPolicy()
+
+	expectImportRows()
+	mock.ExpectCommit()
+	mock.ExpectExec("insert into admin_audit_log").
+		WithArgs(1, "admin@example.com", "user.import", 10, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req := adminRequest(t, http.MethodPost, "/admin/users/import", importFile(t), "users:write")
+	req.Header.Set("Content-Type", "text/csv")
+	rec := httptest.NewRecorder()
+	handlerApp.Routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+	}
+
+	report := decodeReport(t, rec)
+	if report.Rows != 3 || report.Imported != 1 || report.Failed != 2 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(report.Users) != 1 || report.Users[0].ID != 10 {
+		t.Errorf("expected user 10 to be imported, got %+v", report.Users)
+	}
+	if len(report.Errors) != 2 || report.Errors[0].Line != 3 || report.Errors[1].Line != 4 {
+		t.Fatalf("expected errors on lines 3 and 4, got %+v", report.Errors)
+	}
+	if !strings.Contains(report.Errors[0].Errors[0], "at least 10 characters") {
+		t.Errorf("expected the weak password to be explained, got %v", report.Errors[0].Errors)
+	}
+	if !strings.Contains(report.Errors[1].Errors[0], "already exists") {
+		t.Errorf("expected the taken email to be explained, got %v", report.Errors[1].Errors)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestAtomicImportCreatesNobodyOnError(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+	handlerApp.PasswordPolicy = password.DefaultPolicy()
+
+	// The remaining rows are still tried, to report their problems too
+	expectImportRows()
+	mock.ExpectRollback()
+
+	req := adminRequest(t, http.MethodPost, "/admin/users/import?format=csv&atomic=true", importFile(t), "users:write")
+	rec := httptest.NewRecorder()
+	handlerApp.Routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+	}
+
+	report := decodeReport(t, rec)
+	if report.Imported != 0 || report.Valid != 1 || report.Failed != 2 || len(report.Users) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestImportUsersRejectsUnusableFiles(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+	routes := handlerApp.Routes()
+
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, adminRequest(t, http.MethodPost, "/admin/users/import?format=xml", []byte("<users/>"), "users:write"))
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status %d for an unknown format, got %d", http.StatusUnsupportedMediaType, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, adminRequest(t, http.MethodPost, "/admin/users/import?format=csv", []byte("name,shoe_size\n"), "users:write"))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an unknown column, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestExportUsers(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("select id, email, first_name, last_name, user_active").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "user_active", "email_verified",
+			"mfa_enabled", "password_reset_required", "created_at", "updated_at", "roles"}).
+			AddRow(1, "admin@example.com", "Admin", "User", 1, true, true, false, created, created, "admin,user").
+			AddRow(2, "ann@example.com", "Ann", "Lee", 0, false, false, false, created, created, ""))
+
+	rec := httptest.NewRecorder()
+	handlerApp.Routes().ServeHTTP(rec, adminRequest(t, http.MethodGet, "/admin/users/export", nil, "users:read"))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("expected a CSV export, got %s", ct)
+	}
+
+	want := "id,email,first_name,last_name,active,email_verified,mfa_enabled,roles,created_at\n" +
+		"1,admin@example.com,Admin,User,true,true,true,admin;user,2024-05-01T12:00:00Z\n" +
+		"2,ann@example.com,Ann,Lee,false,false,false,,2024-05-01T12:00:00Z\n"
+	if rec.Body.String() != want {
+		t.Errorf("unexpected export:\n%s", rec.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
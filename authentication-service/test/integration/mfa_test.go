@@ -0,0 +1,275 @@
+package integration
+
+import (
+	"authentication/data"
+	"authentication/internal/limiter"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestEnableMFAStoresHashedRecoveryCodes(t *testing.T) {
+	codes, err := data.NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := data.User{ID: 3}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("update users set mfa_enabled = true").
+		WithArgs(sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("delete from mfa_recovery_codes").
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, code := range codes {
+		mock.ExpectExec("insert into mfa_recovery_codes").
+			WithArgs(3, hashOf{plain: code}, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectCommit()
+
+	if err := user.EnableMFA(codes); err != nil {
+		t.Fatalf("EnableMFA failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestUseRecoveryCodeOnlyOnce(t *testing.T) {
+	user := data.User{ID: 3}
+
+	mock.ExpectExec("update mfa_recovery_codes set used_at").
+		WithArgs(sqlmock.AnyArg(), 3, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("update mfa_recovery_codes set used_at").
+		WithArgs(sqlmock.AnyArg(), 3, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ok, err := user.UseRecoveryCode("ABCDE-12345")
+	if err != nil || !ok {
+		t.Fatalf("expected first use to succeed, got %v, %v", ok, err)
+	}
+
+	ok, err = user.UseRecoveryCode("abcde12345")
+	if err != nil || ok {
+		t.Fatalf("expected second use to fail, got %v, %v", ok, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestUseTOTPStepOnlyOnce(t *testing.T) {
+	user := data.User{ID: 3}
+
+	mock.ExpectExec("update users set mfa_last_step").
+		WithArgs(int64(57000000), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("update users set mfa_last_step").
+		WithArgs(int64(57000000), 3).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ok, err := user.UseTOTPStep(57000000)
+	if err != nil || !ok {
+		t.Fatalf("expected first use to succeed, got %v, %v", ok, err)
+	}
+
+	ok, err = user.UseTOTPStep(57000000)
+	if err != nil || ok {
+		t.Fatalf("expected replay to fail, got %v, %v", ok, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestEnrollMFARefusesUnverifiedUser(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("select (.+) from users where email").
+		WithArgs("user@example.com").
+		WillReturnRows(userRows().
+			AddRow(1, "user@example.com", "Test", "User", string(hash), 1, false, false, "", false, time.Now(), time.Now()))
+	mock.ExpectExec("update users set password").
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rec := postMFA(t, newAuthApp().EnrollMFA, map[string]string{"email": "user@example.com", "password": "secret"})
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestEnrollMFACountsFailedLogins(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.LoginLimits.IPEmail.Free = 1
+
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("select (.+) from users where email").
+			WithArgs("nobody@example.com").
+			WillReturnError(sql.ErrNoRows)
+	}
+
+	body := map[string]string{"email": "nobody@example.com", "password": "guess"}
+	for i := 0; i < 2; i++ {
+		if rec := postMFA(t, handlerApp.EnrollMFA, body); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected status %d, got %d", i+1, http.StatusUnauthorized, rec.Code)
+		}
+	}
+
+	rec := postMFA(t, handlerApp.EnrollMFA, body)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestConfirmMFARejectsReplayedCode(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "test", AccountName: "user@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("select (.+) from users where email").
+		WithArgs("user@example.com").
+		WillReturnRows(userRows().
+			AddRow(1, "user@example.com", "Test", "User", string(hash), 1, true, false, key.Secret(), false, time.Now(), time.Now()))
+	mock.ExpectExec("update users set password").
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("update users set mfa_last_step").
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rec := postMFA(t, newAuthApp().ConfirmMFA, map[string]string{"email": "user@example.com", "password": "secret", "code": code})
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestMFAChallengeWithoutRedis(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Redis is down, so the challenge is kept in the memory fallback
+	handlerApp := newAuthApp()
+	handlerApp.Limiter = limiter.NewFailover(context.Background(), limiter.NewRedis(handlerApp.Redis), limiter.NewMemory())
+
+	mock.ExpectQuery("select (.+) from users where email").
+		WithArgs("user@example.com").
+		WillReturnRows(userRows().
+			AddRow(1, "user@example.com", "Test", "User", string(hash), 1, true, true, "JBSWY3DPEHPK3PXP", false, time.Now(), time.Now()))
+	mock.ExpectExec("update users set password").
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rec := postMFA(t, handlerApp.Authenticate, map[string]string{"email": "user@example.com", "password": "secret"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var challenge struct {
+		Code string `json:"code"`
+		Data struct {
+			MFAToken string `json:"mfa_token"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&challenge); err != nil {
+		t.Fatal(err)
+	}
+	if challenge.Code != "mfa_required" || challenge.Data.MFAToken == "" {
+		t.Fatalf("expected an MFA challenge, got %+v", challenge)
+	}
+
+	// The challenge is found again: the code is checked rather than the
+	// challenge being refused
+	mock.ExpectQuery("select (.+) from users where id").
+		WithArgs(1).
+		WillReturnRows(userRows().
+			AddRow(1, "user@example.com", "Test", "User", string(hash), 1, true, true, "JBSWY3DPEHPK3PXP", false, time.Now(), time.Now()))
+	mock.ExpectExec("update mfa_recovery_codes set used_at").
+		WithArgs(sqlmock.AnyArg(), 1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rec = postMFA(t, handlerApp.VerifyMFA, map[string]string{"mfa_token": challenge.Data.MFAToken, "code": "abcde-12345"})
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "invalid code") {
+		t.Fatalf("expected the code to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+// postMFA sends a JSON body to one of the MFA handlers
+func postMFA(t *testing.T, handler http.HandlerFunc, body map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/mfa", bytes.NewReader(payload))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// hashOf matches the stored form of a recovery code, making sure the plain
+// text code never reaches the database
+type hashOf struct {
+	plain string
+}
+
+func (h hashOf) Match(v driver.Value) bool {
+	sum := sha256.Sum256([]byte(strings.ReplaceAll(h.plain, "-", "")))
+	return v == hex.EncodeToString(sum[:])
+}
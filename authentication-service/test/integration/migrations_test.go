@@ -0,0 +1,216 @@
+package integration
+
+import (
+	"authentication/data"
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMigrateUpAppliesPendingMigrationsUnderLock(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrator, err := data.NewMigrator(db)
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+
+	status := expectStatus(t, dbMock, migrator)
+
+	dbMock.ExpectExec("select pg_advisory_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectExec("create table if not exists schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectQuery("select version, applied_at from schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()))
+
+	for _, s := range status[1:] {
+		dbMock.ExpectBegin()
+		dbMock.ExpectExec(".+").WillReturnResult(sqlmock.NewResult(0, 0))
+		dbMock.ExpectExec("insert into schema_migrations").
+			WithArgs(s.Version, s.Name, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		dbMock.ExpectCommit()
+	}
+
+	dbMock.ExpectExec("select pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	applied, err := migrator.Up(context.Background())
+	if err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+
+	if len(applied) != len(status)-1 {
+		t.Errorf("expected %d migrations applied, got %d", len(status)-1, len(applied))
+	}
+
+	if err := dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestMigrateUpStopsAtFailingMigration(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrator, err := data.NewMigrator(db)
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+
+	dbMock.ExpectExec("select pg_advisory_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectExec("create table if not exists schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectQuery("select version, applied_at from schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(".+").WillReturnError(sql.ErrConnDone)
+	dbMock.ExpectRollback()
+	dbMock.ExpectExec("select pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	applied, err := migrator.Up(context.Background())
+	if err == nil {
+		t.Fatal("expected Up to fail")
+	}
+
+	if len(applied) != 0 {
+		t.Errorf("expected no migrations applied, got %d", len(applied))
+	}
+
+	if err := dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+// TestMigrationsAgainstPostgres runs every migration up, down and up again on a
+// real, throwaway database. CI provides one through TEST_POSTGRES_DSN, locally
+// it only runs when that is set, e.g.
+//
+//	docker run --rm -d -p 5433:5432 -e POSTGRES_PASSWORD=password postgres:16
+//	TEST_POSTGRES_DSN="host=localhost port=5433 user=postgres password=password sslmode=disable" go test ./test/integration
+func TestMigrationsAgainstPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrator, err := data.NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	assertAllApplied(t, migrator, true)
+
+	if _, err := migrator.Down(ctx, len(status)); err != nil {
+		t.Fatalf("Down failed: %v", err)
+	}
+	assertAllApplied(t, migrator, false)
+
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up after Down failed: %v", err)
+	}
+	assertAllApplied(t, migrator, true)
+
+	t.Run("seeded admin stays deactivated", func(t *testing.T) {
+		models := data.New(db)
+		defer data.New(mockDB)
+
+		const email = "seeded-admin@example.com"
+
+		if err := models.User.InsertAdmin(email, "hash"); err != nil {
+			t.Fatalf("InsertAdmin failed: %v", err)
+		}
+		if _, err := db.Exec(`update users set user_active = 0 where email = $1`, email); err != nil {
+			t.Fatal(err)
+		}
+
+		// the seed runs again on every start
+		if err := models.User.InsertAdmin(email, "other-hash"); err != nil {
+			t.Fatalf("InsertAdmin failed: %v", err)
+		}
+
+		var active int
+		var password string
+		err := db.QueryRow(`select user_active, password from users where email = $1`, email).Scan(&active, &password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if active != 0 {
+			t.Errorf("expected the admin to stay deactivated, got user_active = %d", active)
+		}
+		if password != "hash" {
+			t.Errorf("expected the password to be left alone, got %q", password)
+		}
+	})
+}
+
+func assertAllApplied(t *testing.T, migrator *data.Migrator, want bool) {
+	t.Helper()
+
+	status, err := migrator.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range status {
+		if (s.AppliedAt != nil) != want {
+			t.Errorf("migration %04d_%s: applied = %v, want %v", s.Version, s.Name, s.AppliedAt != nil, want)
+		}
+	}
+}
+
+// expectStatus reads the list of embedded migrations through Status, using a
+// database where none has been applied yet
+func expectStatus(t *testing.T, dbMock sqlmock.Sqlmock, migrator *data.Migrator) []data.MigrationStatus {
+	t.Helper()
+
+	dbMock.ExpectExec("select pg_advisory_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectExec("create table if not exists schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectQuery("select version, applied_at from schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+	dbMock.ExpectExec("select pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	status, err := migrator.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+
+	if len(status) == 0 {
+		t.Fatal("expected embedded migrations")
+	}
+
+	for i, s := range status {
+		if s.AppliedAt != nil {
+			t.Errorf("migration %04d_%s reported as applied", s.Version, s.Name)
+		}
+		if i > 0 && status[i-1].Version >= s.Version {
+			t.Errorf("migrations out of order: %d before %d", status[i-1].Version, s.Version)
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,164 @@
+package integration
+
+import (
+	"authentication/pkg/authz"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// oauthClientRows returns a result set holding one client with the given secret
+func oauthClientRows(secret, scopes string, revokedAt any) *sqlmock.Rows {
+	sum := sha256.Sum256([]byte(secret))
+	return sqlmock.NewRows([]string{"id", "client_id", "secret_hash", "name", "scopes", "created_at", "updated_at", "revoked_at"}).
+		AddRow(1, "cl_0123456789abcdef", hex.EncodeToString(sum[:]), "nightly export", scopes, time.Now(), time.Now(), revokedAt)
+}
+
+// tokenRequest builds a client credentials request, authenticating with HTTP
+// Basic unless the form carries the credentials
+func tokenRequest(form url.Values, basicSecret string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basicSecret != "" {
+		req.SetBasicAuth("cl_0123456789abcdef", basicSecret)
+	}
+	return req
+}
+
+func TestOAuthClientCredentialsGrant(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+	handlerApp.TokenTTL = time.Minute
+
+	mock.ExpectQuery("select (.+) from oauth_clients where client_id").
+		WithArgs("cl_0123456789abcdef").
+		WillReturnRows(oauthClientRows("s3cret", "logs:read logs:write", nil))
+
+	rec := httptest.NewRecorder()
+	handlerApp.Routes().ServeHTTP(rec, tokenRequest(url.Values{
+		"grant_type": {"client_credentials"},
+		"scope":      {"logs:read"},
+	}, "s3cret"))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Error("expected token responses not to be cached")
+	}
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+		Scope       string `json:"scope"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+
+	if resp.TokenType != "Bearer" || resp.ExpiresIn != 60 || resp.Scope != "logs:read" {
+		t.Errorf("unexpected token response %+v", resp)
+	}
+
+	claims, err := authz.Parse(adminSecret, resp.AccessToken)
+	if err != nil {
+		t.Fatalf("issued token does not parse: %v", err)
+	}
+	if claims.ClientID != "cl_0123456789abcdef" || !claims.HasPermission("logs:read") || claims.HasPermission("logs:write") {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestOAuthTokenErrors(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+	routes := handlerApp.Routes()
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		basic  string
+		rows   *sqlmock.Rows
+		status int
+		error  string
+	}{
+		{"missing grant type", url.Values{}, "s3cret", nil, http.StatusBadRequest, "invalid_request"},
+		{"password grant", url.Values{"grant_type": {"password"}}, "s3cret", nil, http.StatusBadRequest, "unsupported_grant_type"},
+		{"no credentials", url.Values{"grant_type": {"client_credentials"}}, "", nil, http.StatusBadRequest, "invalid_request"},
+		{"wrong secret", url.Values{"grant_type": {"client_credentials"}}, "wrong",
+			oauthClientRows("s3cret", "logs:read", nil), http.StatusUnauthorized, "invalid_client"},
+		{"wrong secret in body", url.Values{"grant_type": {"client_credentials"}, "client_id": {"cl_0123456789abcdef"}, "client_secret": {"wrong"}}, "",
+			oauthClientRows("s3cret", "logs:read", nil), http.StatusBadRequest, "invalid_client"},
+		{"revoked client", url.Values{"grant_type": {"client_credentials"}}, "s3cret",
+			oauthClientRows("s3cret", "logs:read", time.Now()), http.StatusUnauthorized, "invalid_client"},
+		{"scope not allowed", url.Values{"grant_type": {"client_credentials"}, "scope": {"users:write"}}, "s3cret",
+			oauthClientRows("s3cret", "logs:read", nil), http.StatusBadRequest, "invalid_scope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.rows != nil {
+				mock.ExpectQuery("select (.+) from oauth_clients where client_id").
+					WithArgs("cl_0123456789abcdef").
+					WillReturnRows(tt.rows)
+			}
+
+			rec := httptest.NewRecorder()
+			routes.ServeHTTP(rec, tokenRequest(tt.form, tt.basic))
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+
+			var resp struct {
+				Error string `json:"error"`
+			}
+			json.NewDecoder(rec.Body).Decode(&resp)
+			if resp.Error != tt.error {
+				t.Errorf("expected error %q, got %q", tt.error, resp.Error)
+			}
+
+			if tt.status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a WWW-Authenticate challenge")
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateOAuthClientRefusesScopesTheCallerLacks(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+
+	mock.ExpectQuery("select name from permissions").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("users:write").AddRow("oauth_clients:write"))
+
+	rec := httptest.NewRecorder()
+	handlerApp.Routes().ServeHTTP(rec, adminRequest(t, http.MethodPost, "/admin/oauth-clients",
+		[]byte(`{"name":"escalation","scopes":["users:write"]}`), "oauth_clients:write"))
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusForbidden, rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "users:write") {
+		t.Errorf("expected the refused scope in %s", rec.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package integration
+
+import (
+	"authentication/internal/password"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// strictPolicy returns a policy requiring digits, with one breached password
+func strictPolicy() password.Policy {
+	breached := password.NewBloom(10, 0.001)
+	breached.Add("letmein2024!")
+
+	policy := password.DefaultPolicy()
+	policy.RequireDigit = true
+	policy.Breached = breached
+	return policy
+}
+
+// violatedRules decodes the rules listed in a weak password response
+func violatedRules(t *testing.T, rec *httptest.ResponseRecorder) []string {
+	t.Helper()
+
+	var resp struct {
+		Code string `json:"code"`
+		Data struct {
+			Violations []password.Violation `json:"violations"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != "weak_password" {
+		t.Errorf("expected code weak_password, got %q", resp.Code)
+	}
+
+	var rules []string
+	for _, v := range resp.Data.Violations {
+		rules = append(rules, v.Rule)
+	}
+	return rules
+}
+
+func TestRegisterListsViolatedPasswordRules(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.PasswordPolicy = strictPolicy()
+
+	body, _ := json.Marshal(map[string]string{
+		"email":      "jane.doe@example.com",
+		"first_name": "Jane",
+		"password":   "janedoe",
+	})
+	rec := httptest.NewRecorder()
+	handlerApp.Register(rec, httptest.NewRequest(http.MethodPost, "/register", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	rules := violatedRules(t, rec)
+	want := []string{password.RuleMinLength, password.RuleDigit, password.RulePersonal}
+	if len(rules) != len(want) {
+		t.Fatalf("expected rules %v, got %v", want, rules)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("expected rules %v, got %v", want, rules)
+		}
+	}
+
+	// Nothing is stored for a refused password
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestResetPasswordKeepsTokenForRefusedPassword(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.PasswordPolicy = strictPolicy()
+
+	mock.ExpectQuery("select user_id from password_reset_tokens").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(2))
+	mock.ExpectQuery("select (.+) from users where id").
+		WithArgs(2).
+		WillReturnRows(userRows().
+			AddRow(2, "user@example.com", "Test", "User", "", 1, true, false, "", true, time.Now(), time.Now()))
+
+	body, _ := json.Marshal(map[string]string{"token": "abc", "password": "letmein2024!"})
+	rec := httptest.NewRecorder()
+	handlerApp.ResetPassword(rec, httptest.NewRequest(http.MethodPost, "/password/reset", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if rules := violatedRules(t, rec); len(rules) != 1 || rules[0] != password.RuleBreached {
+		t.Errorf("expected only the breached rule, got %v", rules)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestResetPasswordConsumesTokenWithPasswordChange(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.Events = &recordingPublisher{}
+
+	expectReset := func() {
+		mock.ExpectQuery("select user_id from password_reset_tokens").
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(2))
+		mock.ExpectQuery("select (.+) from users where id").
+			WithArgs(2).
+			WillReturnRows(userRows().
+				AddRow(2, "user@example.com", "Test", "User", "", 1, true, false, "", true, time.Now(), time.Now()))
+		mock.ExpectBegin()
+		mock.ExpectQuery("delete from password_reset_tokens where token_hash").
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(2))
+		mock.ExpectExec("delete from password_reset_tokens where user_id").
+			WithArgs(2).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	body, _ := json.Marshal(map[string]string{"token": "abc", "password": "a long and unguessable passphrase"})
+
+	// A failed password update puts the token back
+	expectReset()
+	mock.ExpectExec("update users set password").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 2).
+		WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	rec := httptest.NewRecorder()
+	handlerApp.ResetPassword(rec, httptest.NewRequest(http.MethodPost, "/password/reset", bytes.NewReader(body)))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusInternalServerError, rec.Code, rec.Body.String())
+	}
+
+	expectReset()
+	mock.ExpectExec("update users set password").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec = httptest.NewRecorder()
+	handlerApp.ResetPassword(rec, httptest.NewRequest(http.MethodPost, "/password/reset", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+	handlerApp.PasswordPolicy = strictPolicy()
+	publisher := &recordingPublisher{}
+	handlerApp.Events = publisher
+	routes := handlerApp.Routes()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("old password 1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	change := func(current, next string, accepted bool) *httptest.ResponseRecorder {
+		mock.ExpectQuery("select revoked_at from sessions").
+			WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"revoked_at"}).AddRow(nil))
+		mock.ExpectQuery("select (.+) from users where id").
+			WithArgs(2).
+			WillReturnRows(userRows().
+				AddRow(2, "user@example.com", "Test", "User", string(hash), 1, true, false, "", false, time.Now(), time.Now()))
+		if accepted {
+			mock.ExpectExec("update users set password").
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 2).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+
+		body, _ := json.Marshal(map[string]string{"current_password": current, "password": next})
+		req := sessionRequest(t, http.MethodPost, "/me/password")
+		req.Body = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)).Body
+
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := change("wrong password", "a much better password 7", false)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d for a wrong current password, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	rec = change("old password 1", "testuser2024", false)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d for a weak password, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if rules := violatedRules(t, rec); len(rules) != 1 || rules[0] != password.RulePersonal {
+		t.Errorf("expected only the personal info rule, got %v", rules)
+	}
+
+	rec = change("old password 1", "a much better password 7", true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if len(publisher.events) != 1 || publisher.events[0].Type != "password.changed" {
+		t.Errorf("expected a password.changed event, got %+v", publisher.events)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
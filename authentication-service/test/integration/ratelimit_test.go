@@ -0,0 +1,60 @@
+package integration
+
+import (
+	"authentication/api"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticateDelaysRepeatedFailures(t *testing.T) {
+	handlerApp := newAuthApp()
+	free := handlerApp.LoginLimits.IPEmail.Free
+
+	login := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"email": "nobody@example.com", "password": "guess"})
+		req := httptest.NewRequest(http.MethodPost, "/authenticate", bytes.NewReader(body))
+		req.RemoteAddr = "203.0.113.9:5555"
+		rec := httptest.NewRecorder()
+		handlerApp.Authenticate(rec, req)
+		return rec
+	}
+
+	// Every attempt up to and including the first one over the limit is
+	// checked, after which the client has to wait
+	for i := 0; i <= free; i++ {
+		mock.ExpectQuery("select (.+) from users where email").
+			WithArgs("nobody@example.com").
+			WillReturnError(sql.ErrNoRows)
+
+		if rec := login(); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected status %d, got %d", i+1, http.StatusUnauthorized, rec.Code)
+		}
+	}
+
+	rec := login()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+
+	// Trusted networks are never delayed
+	networks, _ := api.ParseTrustedNetworks("203.0.113.0/24")
+	handlerApp.LoginLimits.TrustedNetworks = networks
+	mock.ExpectQuery("select (.+) from users where email").
+		WithArgs("nobody@example.com").
+		WillReturnError(sql.ErrNoRows)
+
+	if rec := login(); rec.Code != http.StatusUnauthorized {
+		t.Errorf("trusted client: expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
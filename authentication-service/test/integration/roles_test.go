@@ -0,0 +1,81 @@
+package integration
+
+import (
+	"authentication/data"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAssignRole(t *testing.T) {
+	user := data.User{ID: 5}
+
+	mock.ExpectExec("insert into user_roles").
+		WithArgs(5, sqlmock.AnyArg(), "admin").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := user.AssignRole("admin"); err != nil {
+		t.Fatalf("AssignRole failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestAssignUnknownRole(t *testing.T) {
+	user := data.User{ID: 5}
+
+	mock.ExpectExec("insert into user_roles").
+		WithArgs(5, sqlmock.AnyArg(), "nope").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("select exists").
+		WithArgs("nope").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	if err := user.AssignRole("nope"); err != data.ErrRoleNotFound {
+		t.Fatalf("expected ErrRoleNotFound, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestRevokeRole(t *testing.T) {
+	user := data.User{ID: 5}
+
+	mock.ExpectExec("delete from user_roles").
+		WithArgs(5, "admin").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("select exists").
+		WithArgs("admin").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	if err := user.RevokeRole("admin"); err != nil {
+		t.Fatalf("RevokeRole failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestInsertAdminLeavesExistingUserAlone(t *testing.T) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`insert into users (.+) on conflict \(email\) do nothing`).
+		WithArgs("admin@example.com", "hash", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("insert into user_roles (.+) on conflict do nothing").
+		WithArgs("admin@example.com", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if err := (&data.User{}).InsertAdmin("admin@example.com", "hash"); err != nil {
+		t.Fatalf("InsertAdmin failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
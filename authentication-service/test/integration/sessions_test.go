@@ -0,0 +1,170 @@
+package integration
+
+import (
+	"authentication/data"
+	"authentication/event"
+	"authentication/pkg/authz"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// sessionRequest builds a request made with the access token of session 9 of
+// user 2
+func sessionRequest(t *testing.T, method, target string) *http.Request {
+	t.Helper()
+
+	token, err := authz.Sign(adminSecret, authz.Claims{
+		Email:            "user@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{ID: "9", Subject: "2"},
+	}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// recordingPublisher keeps the auth events it is given
+type recordingPublisher struct {
+	events []event.Event
+}
+
+func (p *recordingPublisher) Publish(e event.Event) {
+	p.events = append(p.events, e)
+}
+
+func TestFailedLoginIsRecorded(t *testing.T) {
+	handlerApp := newAuthApp()
+	publisher := &recordingPublisher{}
+	handlerApp.Events = publisher
+
+	mock.ExpectQuery("select (.+) from users where email").
+		WithArgs("nobody@example.com").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("insert into login_history").
+		WithArgs(nil, "nobody@example.com", false, "invalid_credentials", "192.0.2.1", "test-agent", "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	body, _ := json.Marshal(map[string]string{"email": "nobody@example.com", "password": "guess"})
+	req := httptest.NewRequest(http.MethodPost, "/authenticate", bytes.NewReader(body))
+	req.Header.Set("User-Agent", "test-agent")
+	rec := httptest.NewRecorder()
+	handlerApp.Authenticate(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	if len(publisher.events) != 1 {
+		t.Fatalf("expected one auth event, got %d", len(publisher.events))
+	}
+	e := publisher.events[0]
+	if e.Type != event.LoginFailure || e.Reason != "invalid_credentials" || e.IPAddress != "192.0.2.1" {
+		t.Errorf("unexpected auth event %+v", e)
+	}
+	if e.RoutingKey() != event.RoutingWarning {
+		t.Errorf("expected failed logins on %s, got %s", event.RoutingWarning, e.RoutingKey())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestLoginHistoryTruncatesOnCharacterBoundary(t *testing.T) {
+	// The 512th byte falls in the middle of the last character
+	userAgent := strings.Repeat("a", 511) + "é"
+
+	mock.ExpectExec("insert into login_history").
+		WithArgs(nil, "user@example.com", false, data.LoginInvalidCredentials, "192.0.2.1",
+			strings.Repeat("a", 511), "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := (&data.LoginHistory{}).Insert(data.LoginHistory{
+		Email:     "user@example.com",
+		Outcome:   data.LoginInvalidCredentials,
+		IPAddress: "192.0.2.1",
+		UserAgent: userAgent,
+	})
+	if err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestListAndTerminateSessions(t *testing.T) {
+	handlerApp := newAuthApp()
+	handlerApp.JWTSecret = adminSecret
+	routes := handlerApp.Routes()
+
+	sessionColumns := []string{"id", "user_id", "ip_address", "user_agent", "created_at", "expires_at"}
+
+	mock.ExpectQuery("select revoked_at from sessions").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"revoked_at"}).AddRow(nil))
+	mock.ExpectQuery("select (.+) from sessions where user_id").
+		WithArgs(2, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(sessionColumns).
+			AddRow(9, 2, "192.0.2.1", "laptop", time.Now(), time.Now().Add(time.Minute)).
+			AddRow(7, 2, "198.51.100.4", "phone", time.Now(), time.Now().Add(time.Minute)))
+
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, sessionRequest(t, http.MethodGet, "/me/sessions"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var list struct {
+		Data []struct {
+			ID      int  `json:"id"`
+			Current bool `json:"current"`
+		} `json:"data"`
+	}
+	json.NewDecoder(rec.Body).Decode(&list)
+	if len(list.Data) != 2 || !list.Data[0].Current || list.Data[1].Current {
+		t.Errorf("expected two sessions with the first one current, got %+v", list.Data)
+	}
+
+	// Terminating the other session
+	mock.ExpectQuery("select revoked_at from sessions").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"revoked_at"}).AddRow(nil))
+	mock.ExpectExec("update sessions set revoked_at").
+		WithArgs(sqlmock.AnyArg(), 7, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, sessionRequest(t, http.MethodDelete, "/me/sessions/7"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	// A terminated session cannot be used any more
+	mock.ExpectQuery("select revoked_at from sessions").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"revoked_at"}).AddRow(time.Now()))
+
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, sessionRequest(t, http.MethodGet, "/me/logins"))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d for a terminated session, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
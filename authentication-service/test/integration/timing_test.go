@@ -0,0 +1,95 @@
+package integration
+
+import (
+	"authentication/api"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestAuthenticateTimingParity checks that a wrong password for a registered
+// user and any password for an unknown email take about as long to reject,
+// and that both get the same response.
+func TestAuthenticateTimingParity(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing test skipped in short mode")
+	}
+
+	// The registered user still has a bcrypt hash from before Argon2id, the
+	// slower of the two formats the dummy check picks from
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse battery staple"), 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// httptest requests come from 192.0.2.1, trust it so the failures below
+	// are never delayed by the login limits
+	handlerApp := newAuthApp()
+	handlerApp.LoginLimits.TrustedNetworks, _ = api.ParseTrustedNetworks("192.0.2.0/24")
+
+	login := func(email string) (time.Duration, *httptest.ResponseRecorder) {
+		body, _ := json.Marshal(map[string]string{"email": email, "password": "wrong password"})
+		req := httptest.NewRequest(http.MethodPost, "/authenticate", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+
+		start := time.Now()
+		handlerApp.Authenticate(rec, req)
+		return time.Since(start), rec
+	}
+
+	const rounds = 20
+	var known, unknown []time.Duration
+
+	// Interleave the two cases so that noise from the machine hits both alike
+	for i := 0; i < rounds; i++ {
+		mock.ExpectQuery("select (.+) from users where email").
+			WithArgs("user@example.com").
+			WillReturnRows(userRows().
+				AddRow(1, "user@example.com", "Test", "User", string(hash), 1, true, false, "", false, time.Now(), time.Now()))
+
+		elapsed, rec := login("user@example.com")
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("known user: expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+		knownBody := rec.Body.String()
+		known = append(known, elapsed)
+
+		mock.ExpectQuery("select (.+) from users where email").
+			WithArgs("nobody@example.com").
+			WillReturnError(sql.ErrNoRows)
+
+		elapsed, rec = login("nobody@example.com")
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("unknown user: expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+		if rec.Body.String() != knownBody {
+			t.Fatalf("responses differ: %q and %q", knownBody, rec.Body.String())
+		}
+		unknown = append(unknown, elapsed)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+
+	knownMedian, unknownMedian := median(known), median(unknown)
+	ratio := float64(knownMedian) / float64(unknownMedian)
+	if ratio > 1.5 || ratio < 1/1.5 {
+		t.Errorf("login timing leaks user existence: median %v for known users, %v for unknown users",
+			knownMedian, unknownMedian)
+	}
+}
+
+// median returns the middle value of a set of durations
+func median(durations []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
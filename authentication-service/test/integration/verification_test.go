@@ -0,0 +1,173 @@
+package integration
+
+import (
+	"authentication/api"
+	"authentication/data"
+	"authentication/internal/limiter"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRegisterAnswersTheSameForExistingEmail(t *testing.T) {
+	handlerApp := newAuthApp()
+	body := `{"email": "john@example.com", "password": "a long and unguessable passphrase"}`
+
+	mock.ExpectQuery("insert into users").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectExec("insert into user_roles").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("insert into email_verification_tokens").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	created := httptest.NewRecorder()
+	handlerApp.Register(created, httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(body)))
+
+	mock.ExpectQuery("insert into users").
+		WillReturnError(&pgconn.PgError{Code: "23505"})
+
+	existing := httptest.NewRecorder()
+	handlerApp.Register(existing, httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(body)))
+
+	if created.Code != http.StatusCreated || existing.Code != http.StatusCreated {
+		t.Fatalf("expected status %d for both, got %d and %d: %s", http.StatusCreated, created.Code, existing.Code, existing.Body.String())
+	}
+
+	if created.Body.String() != existing.Body.String() {
+		t.Errorf("expected the same answer, got %s and %s", created.Body.String(), existing.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestVerifyEmailMarksEmailVerified(t *testing.T) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("delete from email_verification_tokens where token_hash").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(7))
+	mock.ExpectExec("update users set email_verified = true, updated_at = \\$1 where id = \\$2").
+		WithArgs(sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("delete from email_verification_tokens where user_id").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	handlerApp := app
+	handlerApp.Logger = logrus.New()
+
+	req := httptest.NewRequest(http.MethodGet, "/verify?token=abc", nil)
+	rec := httptest.NewRecorder()
+	handlerApp.VerifyEmail(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestVerifyEmailRejectsUnknownToken(t *testing.T) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("delete from email_verification_tokens where token_hash").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectRollback()
+
+	_, err := app.Models.EmailVerification.Verify("unknown")
+	if err != data.ErrInvalidToken {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unmet expectations: %v", err)
+	}
+}
+
+func TestAuthenticateRejectsUnverifiedAndInactiveUsers(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		active   int
+		verified bool
+		code     string
+	}{
+		{"unverified", 1, false, "email_not_verified"},
+		{"inactive", 0, true, "account_inactive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectQuery("select (.+) from users where email").
+				WithArgs("user@example.com").
+				WillReturnRows(userRows().
+					AddRow(1, "user@example.com", "Test", "User", string(hash), tt.active, tt.verified, false, "", false, time.Now(), time.Now()))
+
+			// The MinCost bcrypt hash is outdated and gets upgraded on login
+			mock.ExpectExec("update users set password").
+				WithArgs(sqlmock.AnyArg(), 1).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			handlerApp := newAuthApp()
+
+			body, _ := json.Marshal(map[string]string{"email": "user@example.com", "password": "secret"})
+			req := httptest.NewRequest(http.MethodPost, "/authenticate", bytes.NewReader(body))
+			rec := httptest.NewRecorder()
+			handlerApp.Authenticate(rec, req)
+
+			if rec.Code != http.StatusForbidden {
+				t.Fatalf("expected status %d, got %d", http.StatusForbidden, rec.Code)
+			}
+
+			var resp struct {
+				Code string `json:"code"`
+			}
+			json.NewDecoder(rec.Body).Decode(&resp)
+			if resp.Code != tt.code {
+				t.Errorf("expected code %q, got %q", tt.code, resp.Code)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+// newAuthApp returns a Config able to serve Authenticate against the mock
+// database. Redis points at a closed port, which the handler tolerates.
+func newAuthApp() *api.Config {
+	handlerApp := app
+	handlerApp.Logger = logrus.New()
+	handlerApp.Redis = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	handlerApp.Metrics.RequestCount = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_request_count"}, []string{"method", "endpoint"})
+	handlerApp.Metrics.RequestLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test_request_latency"}, []string{"method", "endpoint"})
+	handlerApp.Metrics.ErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_error_count"}, []string{"method", "endpoint"})
+	handlerApp.Metrics.LegacyPasswordHashes = prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_legacy_password_hashes"})
+	handlerApp.Metrics.LoginBlocked = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_login_blocked_total"}, []string{"reason"})
+	handlerApp.LoginLimits = api.DefaultLoginLimits()
+	handlerApp.Limiter = limiter.NewMemory()
+	return &handlerApp
+}
+
+// userRows returns an empty result set with the columns loaded for a user
+func userRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "password", "user_active",
+		"email_verified", "mfa_enabled", "mfa_secret", "password_reset_required", "created_at", "updated_at"})
+}
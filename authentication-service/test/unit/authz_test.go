@@ -0,0 +1,79 @@
+package unit
+
+import (
+	"authentication/pkg/authz"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var testSecret = []byte("test-secret")
+
+func newProtectedRouter() http.Handler {
+	mux := chi.NewRouter()
+	mux.Use(authz.Verifier(testSecret))
+	mux.With(authz.RequirePermission("logs:read")).Get("/logs", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+func TestRequirePermission(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions []string
+		secret      []byte
+		ttl         time.Duration
+		want        int
+	}{
+		{"exact permission", []string{"logs:read"}, testSecret, time.Minute, http.StatusOK},
+		{"wildcard permission", []string{"logs:*"}, testSecret, time.Minute, http.StatusOK},
+		{"missing permission", []string{"mail:send"}, testSecret, time.Minute, http.StatusForbidden},
+		{"wrong signature", []string{"logs:read"}, []byte("other-secret"), time.Minute, http.StatusUnauthorized},
+		{"expired token", []string{"logs:read"}, testSecret, -time.Minute, http.StatusUnauthorized},
+	}
+
+	router := newProtectedRouter()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := authz.Sign(tt.secret, authz.Claims{Permissions: tt.permissions}, tt.ttl)
+			if err != nil {
+				t.Fatalf("Sign failed: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/logs", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRequirePermissionWithoutToken(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/logs", nil)
+	rec := httptest.NewRecorder()
+	newProtectedRouter().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestParseRefusesEmptySecret(t *testing.T) {
+	token, err := authz.Sign([]byte{}, authz.Claims{Permissions: []string{"*"}}, time.Minute)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	if _, err := authz.Parse(nil, token); err != authz.ErrInvalidToken {
+		t.Errorf("expected ErrInvalidToken, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package unit
+
+import (
+	"authentication/internal/bulk"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadCSVImport(t *testing.T) {
+	file := "Email,first_name,password,active,roles\n" +
+		"ann@example.com,Ann,\" spaces kept \",false,admin; user\n" +
+		"bob@example.com,Bob,secret,maybe,\n" +
+		"\"carl@example.com\",\"Carl, Jr\",secret,,\n"
+
+	records, rowErrors, err := bulk.Read(strings.NewReader(file), bulk.CSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+
+	ann := records[0]
+	if ann.Line != 2 || ann.Password != " spaces kept " || ann.Active == nil || *ann.Active {
+		t.Errorf("unexpected first record %+v", ann)
+	}
+	if len(ann.Roles) != 2 || ann.Roles[0] != "admin" || ann.Roles[1] != "user" {
+		t.Errorf("expected roles admin and user, got %v", ann.Roles)
+	}
+
+	if records[1].FirstName != "Carl, Jr" || records[1].Active != nil || records[1].Line != 4 {
+		t.Errorf("unexpected second record %+v", records[1])
+	}
+
+	if len(rowErrors) != 1 || rowErrors[0].Line != 3 || rowErrors[0].Email != "bob@example.com" {
+		t.Errorf("expected an error on line 3, got %+v", rowErrors)
+	}
+}
+
+func TestReadRejectsUnusableFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		format bulk.Format
+		file   string
+	}{
+		{"empty csv", bulk.CSV, ""},
+		{"unknown column", bulk.CSV, "email,shoe_size\n"},
+		{"no email column", bulk.CSV, "first_name\n"},
+		{"empty ndjson", bulk.NDJSON, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := bulk.Read(strings.NewReader(tt.file), tt.format)
+			if !errors.Is(err, bulk.ErrInvalidFile) {
+				t.Errorf("expected ErrInvalidFile, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReadNDJSONImport(t *testing.T) {
+	file := `{"email":"ann@example.com","password_hash":"$2a$10$abc","roles":["admin"]}` + "\n\n" +
+		`{"email":"bob@example.com","shoe_size":44}` + "\n" +
+		`not json` + "\n"
+
+	records, rowErrors, err := bulk.Read(strings.NewReader(file), bulk.NDJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 1 || records[0].PasswordHash != "$2a$10$abc" || records[0].Line != 1 {
+		t.Errorf("unexpected records %+v", records)
+	}
+
+	if len(rowErrors) != 2 || rowErrors[0].Line != 3 || rowErrors[1].Line != 4 {
+		t.Errorf("expected errors on lines 3 and 4, got %+v", rowErrors)
+	}
+}
+
+func TestExportCanBeReadBack(t *testing.T) {
+	active := true
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, format := range []bulk.Format{bulk.CSV, bulk.NDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			var out bytes.Buffer
+			writer := bulk.NewWriter(&out, format)
+
+			err := writer.Write(bulk.Record{
+				ID:           3,
+				Email:        "ann@example.com",
+				FirstName:    "Ann",
+				PasswordHash: "$argon2id$secret",
+				Active:       &active,
+				Roles:        []string{"admin", "user"},
+				CreatedAt:    &created,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Contains(out.String(), "argon2id") {
+				t.Fatalf("password hash was exported: %s", out.String())
+			}
+
+			records, rowErrors, err := bulk.Read(&out, format)
+			if err != nil || len(rowErrors) > 0 {
+				t.Fatalf("expected the export to read back, got %v %+v", err, rowErrors)
+			}
+			if len(records) != 1 || records[0].Email != "ann@example.com" || len(records[0].Roles) != 2 {
+				t.Errorf("unexpected records %+v", records)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for value, want := range map[string]bulk.Format{
+		"csv":                     bulk.CSV,
+		".CSV":                    bulk.CSV,
+		"text/csv; charset=utf-8": bulk.CSV,
+		"ndjson":                  bulk.NDJSON,
+		".jsonl":                  bulk.NDJSON,
+		"application/x-ndjson":    bulk.NDJSON,
+	} {
+		if got, err := bulk.ParseFormat(value); err != nil || got != want {
+			t.Errorf("ParseFormat(%q): expected %s, got %s, %v", value, want, got, err)
+		}
+	}
+
+	if _, err := bulk.ParseFormat("application/json"); err == nil {
+		t.Error("expected plain JSON to be refused")
+	}
+}
@@ -0,0 +1,114 @@
+package unit
+
+import (
+	"authentication/event"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeBroker records published messages and can be made unreachable
+type fakeBroker struct {
+	mu        sync.Mutex
+	down      bool
+	keys      []string
+	published []event.Event
+}
+
+func (b *fakeBroker) dial() (event.Channel, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.down {
+		return nil, errors.New("connection refused")
+	}
+	return b, nil
+}
+
+func (b *fakeBroker) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.down {
+		return errors.New("channel closed")
+	}
+
+	var e event.Event
+	if err := json.Unmarshal(msg.Body, &e); err != nil {
+		return err
+	}
+	b.keys = append(b.keys, key)
+	b.published = append(b.published, e)
+	return nil
+}
+
+func (b *fakeBroker) Close() error { return nil }
+
+func (b *fakeBroker) setDown(down bool) {
+	b.mu.Lock()
+	b.down = down
+	b.mu.Unlock()
+}
+
+func (b *fakeBroker) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.published)
+}
+
+func TestPublisherBuffersWhileBrokerIsDown(t *testing.T) {
+	broker := &fakeBroker{down: true}
+
+	publisher := event.NewPublisher(broker.dial, 2, logrus.New())
+	publisher.Backoff = 5 * time.Millisecond
+
+	var dropped []event.Event
+	publisher.OnDrop = func(e event.Event) { dropped = append(dropped, e) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go publisher.Run(ctx)
+
+	// Publishing never blocks, and the oldest event goes once the buffer is full
+	publisher.Publish(event.Event{Type: event.LoginFailure, Data: "first"})
+	publisher.Publish(event.Event{Type: event.LoginFailure, Data: "second"})
+	publisher.Publish(event.Event{Type: event.LoginSuccess, Data: "third"})
+
+	if len(dropped) != 1 || dropped[0].Data != "first" {
+		t.Fatalf("expected the first event to be dropped, got %+v", dropped)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if broker.count() != 0 || publisher.Buffered() != 2 {
+		t.Fatalf("expected two buffered events while down, got %d sent and %d buffered", broker.count(), publisher.Buffered())
+	}
+
+	broker.setDown(false)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for broker.count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if len(broker.published) != 2 {
+		t.Fatalf("expected the buffered events to be sent, got %d", len(broker.published))
+	}
+	if broker.published[0].Data != "second" || broker.keys[0] != event.RoutingWarning {
+		t.Errorf("expected the failure first as a warning, got %q on %s", broker.published[0].Data, broker.keys[0])
+	}
+	if broker.published[1].Data != "third" || broker.keys[1] != event.RoutingInfo {
+		t.Errorf("expected the success second as info, got %q on %s", broker.published[1].Data, broker.keys[1])
+	}
+	if broker.published[1].Name != "event" || broker.published[1].Time.IsZero() {
+		t.Errorf("expected listener-service compatible events, got %+v", broker.published[1])
+	}
+}
@@ -0,0 +1,125 @@
+package unit
+
+import (
+	"authentication/data"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2idHashAndVerify(t *testing.T) {
+	h := data.DefaultHasher()
+
+	hash, err := h.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+
+	if !strings.HasPrefix(hash, h.CurrentPrefix()) {
+		t.Errorf("expected hash to start with %q, got %q", h.CurrentPrefix(), hash)
+	}
+
+	if ok, err := h.Verify("correct horse", hash); err != nil || !ok {
+		t.Errorf("expected password to match, got %v, %v", ok, err)
+	}
+
+	if ok, err := h.Verify("wrong horse", hash); err != nil || ok {
+		t.Errorf("expected password not to match, got %v, %v", ok, err)
+	}
+
+	if h.NeedsRehash(hash) {
+		t.Error("fresh hash should not need a rehash")
+	}
+}
+
+func TestVerifyLegacyBcryptHashes(t *testing.T) {
+	h := data.DefaultHasher()
+
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("admin123"), bcrypt.MinCost)
+
+	for _, hash := range []string{string(legacy), "$2y$" + string(legacy)[4:]} {
+		if ok, err := h.Verify("admin123", hash); err != nil || !ok {
+			t.Errorf("expected %q to match, got %v, %v", hash[:7], ok, err)
+		}
+		if !h.NeedsRehash(hash) {
+			t.Errorf("expected %q to need a rehash under argon2id", hash[:7])
+		}
+	}
+}
+
+func TestNeedsRehashOnParameterChange(t *testing.T) {
+	old := data.DefaultHasher()
+	hash, err := old.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stronger := data.DefaultHasher()
+	stronger.Argon2.Iterations++
+	if !stronger.NeedsRehash(hash) {
+		t.Error("expected hash with fewer iterations to need a rehash")
+	}
+
+	bcryptHasher := data.DefaultHasher()
+	bcryptHasher.Algorithm = data.AlgorithmBcrypt
+	bcryptHasher.BcryptCost = bcrypt.MinCost
+	if !bcryptHasher.NeedsRehash(hash) {
+		t.Error("expected argon2id hash to need a rehash under bcrypt")
+	}
+
+	bcryptHash, err := bcryptHasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcryptHasher.NeedsRehash(bcryptHash) {
+		t.Error("fresh bcrypt hash should not need a rehash")
+	}
+
+	bcryptHasher.BcryptCost++
+	if !bcryptHasher.NeedsRehash(bcryptHash) {
+		t.Error("expected bcrypt hash with lower cost to need a rehash")
+	}
+}
+
+func TestVerifyUnknownHash(t *testing.T) {
+	if _, err := data.DefaultHasher().Verify("secret", "plaintext"); err != data.ErrUnknownHash {
+		t.Errorf("expected ErrUnknownHash, got %v", err)
+	}
+}
+
+func TestDummyPasswordCheckUsesSlowerAlgorithm(t *testing.T) {
+	t.Cleanup(func() { data.SetPasswordHasher(data.DefaultHasher()) })
+
+	// Argon2id is configured but most stored hashes are still bcrypt cost 12
+	cheapArgon2 := data.DefaultHasher()
+	cheapArgon2.Argon2.Memory = 64
+	cheapArgon2.Argon2.Iterations = 1
+
+	// bcrypt is configured with a low cost while Argon2id stays expensive
+	cheapBcrypt := data.DefaultHasher()
+	cheapBcrypt.Algorithm = data.AlgorithmBcrypt
+	cheapBcrypt.BcryptCost = bcrypt.MinCost
+	cheapBcrypt.Argon2.Memory = 64 * 1024
+	cheapBcrypt.Argon2.Iterations = 4
+
+	tests := []struct {
+		name   string
+		hasher *data.Hasher
+		want   string
+	}{
+		{"bcrypt slower", cheapArgon2, data.AlgorithmBcrypt},
+		{"argon2id slower", cheapBcrypt, data.AlgorithmArgon2id},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data.SetPasswordHasher(tt.hasher)
+			data.DummyPasswordCheck("guess")
+
+			if got := data.DummyHashAlgorithm(); got != tt.want {
+				t.Errorf("expected dummy hash algorithm %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package unit
+
+import (
+	"authentication/api"
+	"authentication/internal/limiter"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := limiter.NewMemory()
+
+	for want := int64(1); want <= 3; want++ {
+		got, err := l.Fail(ctx, "login_attempts:email:a@example.com", time.Hour)
+		if err != nil || got != want {
+			t.Fatalf("expected failure %d, got %d, %v", want, got, err)
+		}
+	}
+
+	l.Block(ctx, "login_delay:email:a@example.com", time.Minute)
+	if d, _ := l.Delay(ctx, "login_delay:email:a@example.com"); d <= 0 || d > time.Minute {
+		t.Errorf("expected a delay of up to a minute, got %v", d)
+	}
+
+	l.Block(ctx, "short", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if d, _ := l.Delay(ctx, "short"); d != 0 {
+		t.Errorf("expected an expired delay to be gone, got %v", d)
+	}
+
+	l.ResetPrefix(ctx, "login_attempts:")
+	if got, _ := l.Fail(ctx, "login_attempts:email:a@example.com", time.Hour); got != 1 {
+		t.Errorf("expected the counter to restart after ResetPrefix, got %d", got)
+	}
+	if d, _ := l.Delay(ctx, "login_delay:email:a@example.com"); d == 0 {
+		t.Error("expected ResetPrefix to leave other keys alone")
+	}
+}
+
+// downRedis returns a Redis limiter pointing at a closed port
+func downRedis() *limiter.Redis {
+	return limiter.NewRedis(redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1}))
+}
+
+func TestFailoverFallsBackToMemory(t *testing.T) {
+	ctx := context.Background()
+	f := limiter.NewFailover(ctx, downRedis(), limiter.NewMemory())
+
+	if f.Healthy() {
+		t.Fatal("expected an unreachable Redis to be reported unhealthy")
+	}
+
+	for want := int64(1); want <= 2; want++ {
+		got, err := f.Fail(ctx, "key", time.Hour)
+		if err != nil || got != want {
+			t.Fatalf("expected failure %d from the fallback, got %d, %v", want, got, err)
+		}
+	}
+}
+
+func TestFailoverKeepsValuesInMemory(t *testing.T) {
+	ctx := context.Background()
+	f := limiter.NewFailover(ctx, downRedis(), limiter.NewMemory())
+
+	if err := f.Put(ctx, "mfa_challenge:token", "42", time.Minute); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if value, err := f.Get(ctx, "mfa_challenge:token"); err != nil || value != "42" {
+		t.Fatalf("expected the stored value, got %q, %v", value, err)
+	}
+
+	f.Put(ctx, "short", "1", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if value, _ := f.Get(ctx, "short"); value != "" {
+		t.Errorf("expected an expired value to be gone, got %q", value)
+	}
+
+	f.Reset(ctx, "mfa_challenge:token")
+	if value, _ := f.Get(ctx, "mfa_challenge:token"); value != "" {
+		t.Errorf("expected Reset to remove the value, got %q", value)
+	}
+}
+
+func TestReadinessReportsDegradedWithoutRedis(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectPing()
+
+	app := &api.Config{
+		DB:      db,
+		Logger:  logrus.New(),
+		Limiter: limiter.NewFailover(context.Background(), downRedis(), limiter.NewMemory()),
+	}
+
+	rec := httptest.NewRecorder()
+	app.ReadinessHandler(rec, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if !strings.HasPrefix(rec.Body.String(), "DEGRADED") {
+		t.Errorf("expected a degraded readiness report, got %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,119 @@
+package unit
+
+import (
+	"authentication/internal/password"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := password.Policy{
+		MinLength:        8,
+		MaxLength:        20,
+		RequireLowercase: true,
+		RequireUppercase: true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+		DisallowPersonal: true,
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{"acceptable", "Tr0ub4dor&3", nil},
+		{"short", "Ab1!", []string{password.RuleMinLength}},
+		{"long", "Aa1!" + strings.Repeat("x", 20), []string{password.RuleMaxLength}},
+		{"no classes", "        ", []string{password.RuleLowercase, password.RuleUppercase, password.RuleDigit, password.RuleSymbol}},
+		{"email local part", "Xmaria.lopez1!", []string{password.RulePersonal}},
+		{"last name any case", "LOPEZ-rocks-9", []string{password.RulePersonal}},
+		{"unicode length", "Ünïcödé1!", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := policy.Check(tt.password, "maria.lopez@example.com", "Maria", "Lopez")
+
+			var rules []string
+			for _, v := range violations {
+				rules = append(rules, v.Rule)
+				if v.Message == "" {
+					t.Errorf("rule %s has no message", v.Rule)
+				}
+			}
+			if fmt.Sprint(rules) != fmt.Sprint(tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, rules)
+			}
+		})
+	}
+}
+
+func TestBreachedPasswordBloom(t *testing.T) {
+	// Plain passwords and Have I Been Pwned style hashes can be mixed
+	hash := sha1.Sum([]byte("hunter2"))
+	list := fmt.Sprintf("123456\npassword\n%X:2413945\n", hash)
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := password.LoadBloom(path, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var saved bytes.Buffer
+	if _, err := filter.WriteTo(&saved); err != nil {
+		t.Fatal(err)
+	}
+
+	compiled := filepath.Join(t.TempDir(), "breached.bloom")
+	if err := os.WriteFile(compiled, saved.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := password.LoadBloom(compiled, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 3 {
+		t.Errorf("expected 3 passwords, got %d", loaded.Len())
+	}
+
+	for _, breached := range []string{"123456", "password", "hunter2"} {
+		if !loaded.Contains(breached) {
+			t.Errorf("expected %q to be breached", breached)
+		}
+	}
+
+	policy := password.Policy{Breached: loaded}
+	if v := policy.Check("hunter2"); len(v) != 1 || v[0].Rule != password.RuleBreached {
+		t.Errorf("expected the breached rule, got %v", v)
+	}
+}
+
+func TestBloomFalsePositiveRate(t *testing.T) {
+	filter := password.NewBloom(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		filter.Add(fmt.Sprintf("breached-%d", i))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if filter.Contains(fmt.Sprintf("fine-%d", i)) {
+			falsePositives++
+		}
+	}
+
+	// Sized for 1%, allow for some variance
+	if falsePositives > 200 {
+		t.Errorf("expected about 100 false positives, got %d", falsePositives)
+	}
+}
@@ -0,0 +1,58 @@
+package unit
+
+import (
+	"authentication/api"
+	"testing"
+	"time"
+)
+
+func TestLoginDelayGrowsProgressively(t *testing.T) {
+	limits := api.DefaultLoginLimits()
+	limit := api.LoginLimit{Free: 3, Window: time.Hour, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{1, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{200, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := limits.Delay(limit, tt.failures); got != tt.want {
+			t.Errorf("Delay after %d failures: expected %v, got %v", tt.failures, tt.want, got)
+		}
+	}
+}
+
+func TestTrustedNetworks(t *testing.T) {
+	networks, err := api.ParseTrustedNetworks("10.0.0.0/8, 192.168.1.7,fd00::/8")
+	if err != nil {
+		t.Fatalf("ParseTrustedNetworks failed: %v", err)
+	}
+
+	limits := api.DefaultLoginLimits()
+	limits.TrustedNetworks = networks
+
+	for ip, want := range map[string]bool{
+		"10.1.2.3":    true,
+		"192.168.1.7": true,
+		"192.168.1.8": false,
+		"fd00::1":     true,
+		"8.8.8.8":     false,
+		"not-an-ip":   false,
+	} {
+		if got := limits.Trusted(ip); got != want {
+			t.Errorf("Trusted(%q): expected %v, got %v", ip, want, got)
+		}
+	}
+
+	if _, err := api.ParseTrustedNetworks("10.0.0.0/33"); err == nil {
+		t.Error("expected an invalid network to be rejected")
+	}
+}
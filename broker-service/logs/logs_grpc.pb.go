@@ -1,7 +1,7 @@
 // Code generated by protoc-gen-go-grpc. DO NOT EDIT.
 // versions:
-// - protoc-gen-go-grpc v1.2.0
-// - protoc             v3.20.0
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.3
 // source: logs.proto
 
 package logs
@@ -15,14 +15,31 @@ import (
 
 // This is a compile-time assertion to ensure that this generated file
 // is compatible with the grpc package it is being compiled against.
-// Requires gRPC-Go v1.32.0 or later.
-const _ = grpc.SupportPackageIsVersion7
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	LogService_WriteLog_FullMethodName      = "/logs.LogService/WriteLog"
+	LogService_WriteLogs_FullMethodName     = "/logs.LogService/WriteLogs"
+	LogService_WriteLogBatch_FullMethodName = "/logs.LogService/WriteLogBatch"
+	LogService_QueryLogs_FullMethodName     = "/logs.LogService/QueryLogs"
+	LogService_TailLogs_FullMethodName      = "/logs.LogService/TailLogs"
+)
 
 // LogServiceClient is the client API for LogService service.
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type LogServiceClient interface {
 	WriteLog(ctx context.Context, in *LogRequest, opts ...grpc.CallOption) (*LogResponse, error)
+	// WriteLogs writes a stream of entries and answers once it is closed
+	WriteLogs(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[LogRequest, LogSummary], error)
+	WriteLogBatch(ctx context.Context, in *LogBatchRequest, opts ...grpc.CallOption) (*LogSummary, error)
+	// QueryLogs streams the stored entries matching the query, newest first
+	// unless ascending is set
+	QueryLogs(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Log], error)
+	// TailLogs streams entries as they are written, until the call is
+	// cancelled
+	TailLogs(ctx context.Context, in *TailRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Log], error)
 }
 
 type logServiceClient struct {
@@ -34,30 +51,117 @@ func NewLogServiceClient(cc grpc.ClientConnInterface) LogServiceClient {
 }
 
 func (c *logServiceClient) WriteLog(ctx context.Context, in *LogRequest, opts ...grpc.CallOption) (*LogResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(LogResponse)
-	err := c.cc.Invoke(ctx, "/logs.LogService/WriteLog", in, out, opts...)
+	err := c.cc.Invoke(ctx, LogService_WriteLog_FullMethodName, in, out, cOpts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
+func (c *logServiceClient) WriteLogs(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[LogRequest, LogSummary], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LogService_ServiceDesc.Streams[0], LogService_WriteLogs_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[LogRequest, LogSummary]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogService_WriteLogsClient = grpc.ClientStreamingClient[LogRequest, LogSummary]
+
+func (c *logServiceClient) WriteLogBatch(ctx context.Context, in *LogBatchRequest, opts ...grpc.CallOption) (*LogSummary, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LogSummary)
+	err := c.cc.Invoke(ctx, LogService_WriteLogBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logServiceClient) QueryLogs(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Log], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LogService_ServiceDesc.Streams[1], LogService_QueryLogs_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[QueryRequest, Log]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogService_QueryLogsClient = grpc.ServerStreamingClient[Log]
+
+func (c *logServiceClient) TailLogs(ctx context.Context, in *TailRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Log], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LogService_ServiceDesc.Streams[2], LogService_TailLogs_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[TailRequest, Log]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogService_TailLogsClient = grpc.ServerStreamingClient[Log]
+
 // LogServiceServer is the server API for LogService service.
 // All implementations must embed UnimplementedLogServiceServer
-// for forward compatibility
+// for forward compatibility.
 type LogServiceServer interface {
 	WriteLog(context.Context, *LogRequest) (*LogResponse, error)
+	// WriteLogs writes a stream of entries and answers once it is closed
+	WriteLogs(grpc.ClientStreamingServer[LogRequest, LogSummary]) error
+	WriteLogBatch(context.Context, *LogBatchRequest) (*LogSummary, error)
+	// QueryLogs streams the stored entries matching the query, newest first
+	// unless ascending is set
+	QueryLogs(*QueryRequest, grpc.ServerStreamingServer[Log]) error
+	// TailLogs streams entries as they are written, until the call is
+	// cancelled
+	TailLogs(*TailRequest, grpc.ServerStreamingServer[Log]) error
 	mustEmbedUnimplementedLogServiceServer()
 }
 
-// UnimplementedLogServiceServer must be embedded to have forward compatible implementations.
-type UnimplementedLogServiceServer struct {
-}
+// UnimplementedLogServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLogServiceServer struct{}
 
 func (UnimplementedLogServiceServer) WriteLog(context.Context, *LogRequest) (*LogResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method WriteLog not implemented")
 }
+func (UnimplementedLogServiceServer) WriteLogs(grpc.ClientStreamingServer[LogRequest, LogSummary]) error {
+	return status.Errorf(codes.Unimplemented, "method WriteLogs not implemented")
+}
+func (UnimplementedLogServiceServer) WriteLogBatch(context.Context, *LogBatchRequest) (*LogSummary, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WriteLogBatch not implemented")
+}
+func (UnimplementedLogServiceServer) QueryLogs(*QueryRequest, grpc.ServerStreamingServer[Log]) error {
+	return status.Errorf(codes.Unimplemented, "method QueryLogs not implemented")
+}
+func (UnimplementedLogServiceServer) TailLogs(*TailRequest, grpc.ServerStreamingServer[Log]) error {
+	return status.Errorf(codes.Unimplemented, "method TailLogs not implemented")
+}
 func (UnimplementedLogServiceServer) mustEmbedUnimplementedLogServiceServer() {}
+func (UnimplementedLogServiceServer) testEmbeddedByValue()                    {}
 
 // UnsafeLogServiceServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to LogServiceServer will
@@ -67,6 +171,13 @@ type UnsafeLogServiceServer interface {
 }
 
 func RegisterLogServiceServer(s grpc.ServiceRegistrar, srv LogServiceServer) {
+	// If the following call pancis, it indicates UnimplementedLogServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
 	s.RegisterService(&LogService_ServiceDesc, srv)
 }
 
@@ -80,7 +191,7 @@ func _LogService_WriteLog_Handler(srv interface{}, ctx context.Context, dec func
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/logs.LogService/WriteLog",
+		FullMethod: LogService_WriteLog_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
 		return srv.(LogServiceServer).WriteLog(ctx, req.(*LogRequest))
@@ -88,6 +199,53 @@ func _LogService_WriteLog_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _LogService_WriteLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LogServiceServer).WriteLogs(&grpc.GenericServerStream[LogRequest, LogSummary]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogService_WriteLogsServer = grpc.ClientStreamingServer[LogRequest, LogSummary]
+
+func _LogService_WriteLogBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServiceServer).WriteLogBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LogService_WriteLogBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServiceServer).WriteLogBatch(ctx, req.(*LogBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LogService_QueryLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(QueryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LogServiceServer).QueryLogs(m, &grpc.GenericServerStream[QueryRequest, Log]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogService_QueryLogsServer = grpc.ServerStreamingServer[Log]
+
+func _LogService_TailLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TailRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LogServiceServer).TailLogs(m, &grpc.GenericServerStream[TailRequest, Log]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogService_TailLogsServer = grpc.ServerStreamingServer[Log]
+
 // LogService_ServiceDesc is the grpc.ServiceDesc for LogService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -99,7 +257,27 @@ var LogService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "WriteLog",
 			Handler:    _LogService_WriteLog_Handler,
 		},
+		{
+			MethodName: "WriteLogBatch",
+			Handler:    _LogService_WriteLogBatch_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WriteLogs",
+			Handler:       _LogService_WriteLogs_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "QueryLogs",
+			Handler:       _LogService_QueryLogs_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "TailLogs",
+			Handler:       _LogService_TailLogs_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "logs.proto",
 }
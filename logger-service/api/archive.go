@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"log-service/internal/archive"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RunArchiver moves the entries older than after to the archive now and then
+// every interval, until ctx is done
+func (app *Config) RunArchiver(ctx context.Context, a *archive.Archive, after, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		app.archiveOld(ctx, a, after)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// archiveOld runs the archive once and records what it moved
+func (app *Config) archiveOld(ctx context.Context, a *archive.Archive, after time.Duration) {
+	result, err := a.Move(ctx, app.Models.LogEntry, time.Now().Add(-after))
+
+	ArchivedEntriesTotal.Add(float64(result.Archived))
+	ArchiveSegmentsTotal.Add(float64(result.Segments))
+	if result.Segments > 0 {
+		Log.WithFields(logrus.Fields{
+			"action":   "archiveOld",
+			"segments": result.Segments,
+			"archived": result.Archived,
+			"deleted":  result.Deleted,
+		}).Info("Archived old log entries")
+	}
+
+	if err != nil && ctx.Err() == nil {
+		ArchiveErrors.Inc()
+		Log.WithError(err).Error("Failed to archive old log entries")
+	}
+}
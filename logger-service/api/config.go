@@ -1,15 +1,35 @@
 package api
 
 import (
+	"context"
 	"log-service/data"
 
 	"github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// Config struct holds the models and the MongoDB client
+// Config struct holds the models and the logger. Single log entries go
+// through Writer when it is set, and are stored right away otherwise. Reading
+// logs and the admin routes take an access token from authentication-service
+// signed with JWTSecret. Log tails end when Done is closed, as the service
+// shuts down.
 type Config struct {
-	Models data.Models
-	Client *mongo.Client
-	Logger *logrus.Logger
+	Models    data.Models
+	Logger    *logrus.Logger
+	Writer    *data.BufferedWriter
+	JWTSecret []byte
+	Done      <-chan struct{}
+}
+
+// writeEntry stores one log entry, through writer when there is one. With
+// wait it only returns once the entry is stored.
+func writeEntry(ctx context.Context, models data.Models, writer *data.BufferedWriter, entry data.LogEntry, wait bool) error {
+	if writer == nil {
+		_, err := models.LogEntry.Insert(ctx, entry)
+		return err
+	}
+
+	if wait {
+		return writer.WriteSync(ctx, entry)
+	}
+	return writer.Write(entry)
 }
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log-service/internal/export"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ExportLogs streams the entries matching the filters of ListLogs, oldest
+// first, as NDJSON or CSV chosen with ?format= (NDJSON by default) and gzip
+// compressed with ?gzip=true
+func (app *Config) ExportLogs(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+
+	filter, err := ParseLogFilter(values)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	opts := export.Options{Format: export.NDJSON, Filter: filter}
+	if value := values.Get("format"); value != "" {
+		if opts.Format, err = export.ParseFormat(value); err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	switch values.Get("gzip") {
+	case "", "false":
+	case "true":
+		opts.Gzip = true
+	default:
+		app.errorJSON(w, errors.New("gzip must be true or false"))
+		return
+	}
+
+	logger := Log.WithFields(logrus.Fields{
+		"action": "ExportLogs",
+		"query":  r.URL.RawQuery,
+	})
+
+	if opts.Gzip {
+		w.Header().Set("Content-Type", "application/gzip")
+	} else {
+		w.Header().Set("Content-Type", opts.Format.ContentType())
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, opts.FileName()))
+
+	out := &sentWriter{ResponseWriter: w}
+	count, err := export.Export(r.Context(), app.Models.LogEntry, out, opts)
+	if err != nil {
+		logger.WithError(err).Error("Failed to export logs")
+
+		// Once the first entries have gone out with the status line, all that
+		// can be done is to cut the file short
+		if !out.sent {
+			w.Header().Del("Content-Disposition")
+			app.errorJSON(w, errors.New("unable to export logs"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	logger.WithField("entries", count).Info("Logs exported")
+}
+
+// sentWriter records whether anything was written to the response
+type sentWriter struct {
+	http.ResponseWriter
+	sent bool
+}
+
+func (s *sentWriter) Write(p []byte) (int, error) {
+	s.sent = true
+	return s.ResponseWriter.Write(p)
+}
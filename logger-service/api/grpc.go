@@ -2,24 +2,42 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log-service/data"
 	"log-service/logs"
 	"net"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
 	gRpcPort = "50001"
+
+	// writeBatchSize is how many streamed entries are written to the store at
+	// once
+	writeBatchSize = 500
+
+	// tailBuffer is how many new entries a TailLogs call may fall behind
+	// before entries are skipped
+	tailBuffer = 256
 )
 
 type LogServer struct {
 	logs.UnimplementedLogServiceServer
 	Models data.Models
+	Writer *data.BufferedWriter
 }
 
 // WriteLog handles the WriteLog gRPC call
@@ -30,17 +48,28 @@ func (l *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.L
 	input := req.GetLogEntry()
 
 	// Log entry creation
-	logEntry := data.LogEntry{
-		Name: input.Name,
-		Data: input.Data,
-	}
+	logEntry := entryFromProto(input)
 
-	err := l.Models.LogEntry.Insert(logEntry)
+	err := writeEntry(ctx, l.Models, l.Writer, logEntry, req.GetWait())
 
 	// Record duration for gRPC request
 	duration := time.Since(start).Seconds()
 	GrpcRequestDuration.WithLabelValues("WriteLog").Observe(duration)
 
+	if errors.Is(err, data.ErrInvalidEntry) {
+		return &logs.LogResponse{Result: "failed"}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if errors.Is(err, data.ErrBufferFull) {
+		LogBufferRejected.Inc()
+		return &logs.LogResponse{Result: "failed"}, status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	if errors.Is(err, data.ErrWriterClosed) {
+		LogBufferRejected.Inc()
+		return &logs.LogResponse{Result: "failed"}, status.Error(codes.Unavailable, err.Error())
+	}
+
 	if err != nil {
 		// Log failure and increment error counter for log insertion
 		Log.WithFields(logrus.Fields{
@@ -68,6 +97,304 @@ func (l *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.L
 	return res, nil
 }
 
+// WriteLogs writes a stream of log entries, in batches of writeBatchSize, and
+// answers with a summary once the client closes the stream
+func (l *LogServer) WriteLogs(stream logs.LogService_WriteLogsServer) error {
+	start := time.Now()
+	defer func() {
+		GrpcRequestDuration.WithLabelValues("WriteLogs").Observe(time.Since(start).Seconds())
+	}()
+
+	summary := &logs.LogSummary{}
+	batch := make([]data.LogEntry, 0, writeBatchSize)
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		entry := entryFromProto(req.GetLogEntry())
+		if err := entry.Normalize(); err != nil {
+			return status.Errorf(codes.InvalidArgument, "entry %d: %v", summary.Received, err)
+		}
+
+		summary.Received++
+		batch = append(batch, entry)
+
+		if len(batch) == writeBatchSize {
+			l.writeBatch(stream.Context(), "WriteLogs", batch, summary)
+			batch = batch[:0]
+		}
+	}
+
+	l.writeBatch(stream.Context(), "WriteLogs", batch, summary)
+	if summary.Received == 0 {
+		summary.Result = "no entries"
+	}
+
+	if summary.Written == 0 && summary.Failed > 0 {
+		return status.Error(codes.Internal, "unable to write log entries")
+	}
+	return stream.SendAndClose(summary)
+}
+
+// WriteLogBatch writes every entry of the request with a single InsertMany
+func (l *LogServer) WriteLogBatch(ctx context.Context, req *logs.LogBatchRequest) (*logs.LogSummary, error) {
+	start := time.Now()
+	defer func() {
+		GrpcRequestDuration.WithLabelValues("WriteLogBatch").Observe(time.Since(start).Seconds())
+	}()
+
+	if len(req.GetLogEntries()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no log entries")
+	}
+
+	batch := make([]data.LogEntry, 0, len(req.GetLogEntries()))
+	for i, input := range req.GetLogEntries() {
+		entry := entryFromProto(input)
+		if err := entry.Normalize(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "entry %d: %v", i, err)
+		}
+		batch = append(batch, entry)
+	}
+
+	summary := &logs.LogSummary{Received: int64(len(batch))}
+	l.writeBatch(ctx, "WriteLogBatch", batch, summary)
+
+	if summary.Written == 0 {
+		return nil, status.Error(codes.Internal, "unable to write log entries")
+	}
+	return summary, nil
+}
+
+// writeBatch writes a batch of entries and adds the outcome to the summary
+func (l *LogServer) writeBatch(ctx context.Context, action string, batch []data.LogEntry, summary *logs.LogSummary) {
+	if len(batch) == 0 {
+		return
+	}
+
+	stored, err := l.Models.LogEntry.InsertMany(ctx, batch)
+	written := len(stored)
+	failed := len(batch) - written
+
+	summary.Written += int64(written)
+	summary.Failed += int64(failed)
+	summary.Result = "logged!"
+	if summary.Failed > 0 {
+		summary.Result = fmt.Sprintf("%d of %d entries failed", summary.Failed, summary.Written+summary.Failed)
+	}
+
+	if written > 0 {
+		LogInsertionTotal.WithLabelValues("success").Add(float64(written))
+	}
+
+	if err != nil {
+		Log.WithFields(logrus.Fields{
+			"action":  action,
+			"error":   err,
+			"written": written,
+			"failed":  failed,
+		}).Error("Failed to insert log entries")
+
+		LogInsertionErrors.Add(float64(failed))
+		return
+	}
+
+	Log.WithFields(logrus.Fields{
+		"action":  action,
+		"written": written,
+	}).Info("Successfully gRPC logged entries")
+}
+
+// QueryLogs streams the stored entries matching the request, fetching them
+// from the store a page at a time
+func (l *LogServer) QueryLogs(req *logs.QueryRequest, stream logs.LogService_QueryLogsServer) error {
+	start := time.Now()
+	defer func() {
+		GrpcRequestDuration.WithLabelValues("QueryLogs").Observe(time.Since(start).Seconds())
+	}()
+
+	if req.GetLimit() < 0 {
+		return status.Error(codes.InvalidArgument, "limit must not be negative")
+	}
+
+	filter := data.LogFilter{
+		Name:     req.GetName(),
+		Severity: severities(req.GetSeverity()),
+		Source:   req.GetSource(),
+		TraceID:  req.GetTraceId(),
+		Search:   strings.TrimSpace(req.GetQ()),
+	}
+	if req.GetFrom() != nil {
+		filter.From = req.GetFrom().AsTime()
+	}
+	if req.GetTo() != nil {
+		filter.To = req.GetTo().AsTime()
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return status.Error(codes.InvalidArgument, "from must be before to")
+	}
+
+	query := data.LogQuery{Filter: filter, Ascending: req.GetAscending()}
+	remaining := req.GetLimit()
+
+	for {
+		query.Limit = data.MaxPageSize
+		if req.GetLimit() > 0 && remaining < data.MaxPageSize {
+			query.Limit = int(remaining)
+		}
+
+		page, err := l.Models.LogEntry.Find(stream.Context(), query)
+		if err != nil {
+			if ctxErr := stream.Context().Err(); ctxErr != nil {
+				return status.FromContextError(ctxErr).Err()
+			}
+			Log.WithFields(logrus.Fields{
+				"action": "QueryLogs",
+				"error":  err,
+			}).Error("Failed to query logs")
+			return status.Error(codes.Internal, "unable to query logs")
+		}
+
+		for _, entry := range page.Entries {
+			if err := stream.Send(entryToProto(entry)); err != nil {
+				return err
+			}
+		}
+
+		remaining -= int64(len(page.Entries))
+		if page.NextCursor == "" || (req.GetLimit() > 0 && remaining <= 0) {
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// TailLogs streams the entries written after the call was made, until the
+// client cancels it: to any instance while a Mongo change stream feeds the
+// subscriptions, to this instance otherwise. A client that cannot keep up
+// misses entries rather than slowing down writers.
+func (l *LogServer) TailLogs(req *logs.TailRequest, stream logs.LogService_TailLogsServer) error {
+	sub := data.Subscribe(data.LogFilter{
+		Name:     req.GetName(),
+		Severity: severities(req.GetSeverity()),
+		Source:   req.GetSource(),
+		TraceID:  req.GetTraceId(),
+	}, tailBuffer)
+	defer sub.Close()
+
+	logger := Log.WithFields(logrus.Fields{
+		"action": "TailLogs",
+		"name":   req.GetName(),
+	})
+	logger.Info("Tail started")
+
+	// Headers tell the client that entries written from now on will reach it
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			logger.WithField("dropped", sub.Dropped()).Info("Tail ended")
+			return nil
+		case entry := <-sub.C:
+			if err := stream.Send(entryToProto(entry)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// entryFromProto and entryToProto convert log entries between their gRPC
+// and stored forms
+func entryFromProto(input *logs.Log) data.LogEntry {
+	entry := data.LogEntry{
+		Name:       input.GetName(),
+		Data:       input.GetData(),
+		Source:     input.GetSource(),
+		Host:       input.GetHost(),
+		TraceID:    input.GetTraceId(),
+		SpanID:     input.GetSpanId(),
+		Attributes: input.GetAttributes().AsMap(),
+	}
+
+	// Unknown values, from clients with a newer proto, are refused by
+	// Normalize
+	if input.GetSeverity() != logs.Severity_SEVERITY_UNSPECIFIED {
+		entry.Severity = strings.ToLower(strings.TrimPrefix(input.GetSeverity().String(), "SEVERITY_"))
+	}
+
+	return entry
+}
+
+func entryToProto(entry *data.LogEntry) *logs.Log {
+	out := &logs.Log{
+		Id:        entry.ID,
+		Name:      entry.Name,
+		Data:      entry.Data,
+		CreatedAt: timestamppb.New(entry.CreatedAt),
+		Severity:  logs.Severity(logs.Severity_value["SEVERITY_"+strings.ToUpper(entry.Severity)]),
+		Source:    entry.Source,
+		Host:      entry.Host,
+		TraceId:   entry.TraceID,
+		SpanId:    entry.SpanID,
+	}
+
+	if len(entry.Attributes) > 0 {
+		attributes, err := structpb.NewStruct(protoAttributes(entry.Attributes))
+		if err != nil {
+			Log.WithError(err).WithField("id", entry.ID).Warn("Failed to convert log attributes")
+		}
+		out.Attributes = attributes
+	}
+
+	return out
+}
+
+// protoAttributes turns the lists Mongo decodes attributes into back into
+// the plain slices structpb accepts
+func protoAttributes(attributes map[string]any) map[string]any {
+	out := make(map[string]any, len(attributes))
+	for key, value := range attributes {
+		out[key] = protoValue(value)
+	}
+	return out
+}
+
+func protoValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		return protoAttributes(v)
+	case primitive.A:
+		return protoValue([]any(v))
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = protoValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// severities normalises requested severities the way the HTTP API does
+func severities(values []string) []string {
+	var out []string
+	for _, severity := range values {
+		if severity = strings.ToLower(strings.TrimSpace(severity)); severity != "" {
+			out = append(out, severity)
+		}
+	}
+	return out
+}
+
 // GRPCListen starts the gRPC server and listens for incoming requests
 func (app *Config) GRPCListen() {
 	// Configure logrus for JSON output
@@ -81,7 +408,7 @@ func (app *Config) GRPCListen() {
 
 	s := grpc.NewServer()
 
-	logs.RegisterLogServiceServer(s, &LogServer{Models: app.Models})
+	logs.RegisterLogServiceServer(s, &LogServer{Models: app.Models, Writer: app.Writer})
 
 	Log.Infof("gRPC Server started on port %s", gRpcPort)
 
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"log-service/data"
 	"net/http"
 	"time"
@@ -12,8 +13,14 @@ import (
 )
 
 type JSONPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name       string         `json:"name"`
+	Data       string         `json:"data"`
+	Severity   string         `json:"severity,omitempty"`
+	Source     string         `json:"source,omitempty"`
+	Host       string         `json:"host,omitempty"`
+	TraceID    string         `json:"trace_id,omitempty"`
+	SpanID     string         `json:"span_id,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
 }
 
 func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
@@ -29,6 +36,8 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 	span.SetAttributes(
 		attribute.String("log.name", requestPayload.Name),
 		attribute.String("log.data", requestPayload.Data),
+		attribute.String("log.severity", requestPayload.Severity),
+		attribute.String("log.source", requestPayload.Source),
 	)
 
 	logger := Log.WithFields(logrus.Fields{
@@ -38,12 +47,36 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 	})
 
 	event := data.LogEntry{
-		Name: requestPayload.Name,
-		Data: requestPayload.Data,
+		Name:       requestPayload.Name,
+		Data:       requestPayload.Data,
+		Severity:   requestPayload.Severity,
+		Source:     requestPayload.Source,
+		Host:       requestPayload.Host,
+		TraceID:    requestPayload.TraceID,
+		SpanID:     requestPayload.SpanID,
+		Attributes: requestPayload.Attributes,
 	}
 
-	err := app.Models.LogEntry.Insert(event)
+	wait := r.URL.Query().Get("wait") == "true"
+	err := writeEntry(r.Context(), app.Models, app.Writer, event, wait)
 	duration := time.Since(start).Seconds()
+
+	if errors.Is(err, data.ErrInvalidEntry) {
+		logger.WithError(err).Warn("Refused invalid log entry")
+		span.SetStatus(codes.Error, "invalid log entry")
+		app.errorJSON(w, err)
+		return
+	}
+
+	if errors.Is(err, data.ErrBufferFull) || errors.Is(err, data.ErrWriterClosed) {
+		logger.WithError(err).Warn("Refused log entry, the buffer is not taking any")
+		LogBufferRejected.Inc()
+		span.SetStatus(codes.Error, "log buffer full")
+		w.Header().Set("Retry-After", "1")
+		app.errorJSON(w, err, http.StatusServiceUnavailable)
+		return
+	}
+
 	LogInsertionDuration.WithLabelValues("success").Observe(duration)
 
 	if err != nil {
@@ -52,7 +85,7 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 		LogInsertionDuration.WithLabelValues("failure").Observe(duration)
 
 		span.RecordError(err)
-		span.SetStatus(codes.Error, "MongoDB insert failed")
+		span.SetStatus(codes.Error, "log insert failed")
 
 		app.errorJSON(w, err)
 		return
@@ -65,6 +98,9 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 		Error:   false,
 		Message: "logged",
 	}
+	if app.Writer != nil && !wait {
+		resp.Message = "queued"
+	}
 
 	app.writeJSON(w, http.StatusAccepted, resp)
 }
@@ -30,6 +30,82 @@ var (
 		[]string{"status"}, // You can add status label to track success/failure
 	)
 
+	// Track the log entries deleted by each retention policy
+	RetentionPurgedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "logger_service_retention_purged_total",
+			Help: "Total number of log entries deleted by retention policies.",
+		},
+		[]string{"policy"},
+	)
+
+	// Track the retention runs that could not apply every policy
+	RetentionPurgeErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "logger_service_retention_purge_errors_total",
+			Help: "Total number of retention runs that failed to apply a policy.",
+		},
+	)
+
+	// Track the log entries moved to the archive
+	ArchivedEntriesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "logger_service_archived_entries_total",
+			Help: "Total number of log entries moved to archive segments.",
+		},
+	)
+
+	// Track the archive segments written
+	ArchiveSegmentsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "logger_service_archive_segments_total",
+			Help: "Total number of archive segments written.",
+		},
+	)
+
+	// Track the archive runs that failed
+	ArchiveErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "logger_service_archive_errors_total",
+			Help: "Total number of archive runs that failed.",
+		},
+	)
+
+	// Track the clients following log tails
+	TailClients = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "logger_service_tail_clients",
+			Help: "Number of clients following a log tail.",
+		},
+		[]string{"transport"},
+	)
+
+	// Track why log tails end
+	TailDisconnects = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "logger_service_tail_disconnects_total",
+			Help: "Total number of log tails ended, by reason.",
+		},
+		[]string{"reason"},
+	)
+
+	// Track the entries refused because the write buffer was full
+	LogBufferRejected = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "logger_service_log_buffer_rejected_total",
+			Help: "Total number of log entries refused because the write buffer was full.",
+		},
+	)
+
+	// Track the batches the buffered writer flushes
+	LogBufferFlushes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "logger_service_log_buffer_flushes_total",
+			Help: "Total number of batches flushed from the write buffer.",
+		},
+		[]string{"status"},
+	)
+
 	// Track gRPC request durations
 	GrpcRequestDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -47,4 +123,13 @@ func init() {
 	prometheus.MustRegister(LogInsertionErrors)
 	prometheus.MustRegister(LogInsertionDuration)
 	prometheus.MustRegister(GrpcRequestDuration)
+	prometheus.MustRegister(LogBufferRejected)
+	prometheus.MustRegister(LogBufferFlushes)
+	prometheus.MustRegister(RetentionPurgedTotal)
+	prometheus.MustRegister(RetentionPurgeErrors)
+	prometheus.MustRegister(ArchivedEntriesTotal)
+	prometheus.MustRegister(ArchiveSegmentsTotal)
+	prometheus.MustRegister(ArchiveErrors)
+	prometheus.MustRegister(TailClients)
+	prometheus.MustRegister(TailDisconnects)
 }
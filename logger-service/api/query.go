@@ -0,0 +1,142 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log-service/data"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// ListLogs returns one page of log entries. The entries can be filtered with
+// ?name=, ?severity= (comma separated), ?source=, ?trace_id=, ?q= (words of
+// data) and ?from= / ?to= (RFC 3339), sorted with ?sort=asc|desc (newest first by
+// default) and paged with ?limit= and the ?cursor= of the previous page.
+func (app *Config) ListLogs(w http.ResponseWriter, r *http.Request) {
+	logger := Log.WithFields(logrus.Fields{
+		"action": "ListLogs",
+		"query":  r.URL.RawQuery,
+	})
+
+	query, err := parseLogQuery(r.URL.Query())
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	page, err := app.Models.LogEntry.Find(r.Context(), query)
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidCursor) {
+			app.errorJSON(w, err)
+			return
+		}
+		logger.WithError(err).Error("Failed to query logs")
+		app.errorJSON(w, errors.New("unable to query logs"), http.StatusInternalServerError)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d log entries", len(page.Entries)),
+		Data:    page,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// GetLog returns one log entry by id
+func (app *Config) GetLog(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	entry, err := app.Models.LogEntry.FindOne(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		Log.WithError(err).WithField("id", id).Error("Failed to load log entry")
+		app.errorJSON(w, errors.New("unable to load log entry"), http.StatusInternalServerError)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: "log entry " + id,
+		Data:    entry,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// parseLogQuery reads a log query from URL parameters
+func parseLogQuery(values url.Values) (data.LogQuery, error) {
+	filter, err := ParseLogFilter(values)
+	if err != nil {
+		return data.LogQuery{}, err
+	}
+
+	query := data.LogQuery{
+		Filter: filter,
+		Cursor: values.Get("cursor"),
+	}
+
+	switch values.Get("sort") {
+	case "", "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		return query, errors.New("sort must be asc or desc")
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > data.MaxPageSize {
+			return query, fmt.Errorf("limit must be between 1 and %d", data.MaxPageSize)
+		}
+		query.Limit = limit
+	}
+
+	return query, nil
+}
+
+// ParseLogFilter reads the filters shared by every log query from URL
+// parameters, which the export command takes as flags as well
+func ParseLogFilter(values url.Values) (data.LogFilter, error) {
+	filter := data.LogFilter{
+		Name:    values.Get("name"),
+		Search:  strings.TrimSpace(values.Get("q")),
+		Source:  values.Get("source"),
+		TraceID: values.Get("trace_id"),
+	}
+
+	for _, severity := range strings.Split(values.Get("severity"), ",") {
+		if severity = strings.ToLower(strings.TrimSpace(severity)); severity != "" {
+			filter.Severity = append(filter.Severity, severity)
+		}
+	}
+
+	for name, dest := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		value := values.Get(name)
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("%s must be an RFC 3339 time such as 2024-05-01T12:00:00Z", name)
+		}
+		*dest = t
+	}
+
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, errors.New("from must be before to")
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log-service/data"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RetentionRequest is the body of PUT /admin/retention
+type RetentionRequest struct {
+	Policies []data.RetentionPolicy `json:"policies"`
+}
+
+// RunRetention applies the retention policies now and then every interval,
+// until ctx is done
+func (app *Config) RunRetention(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		app.purgeExpired(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeExpired applies the retention policies once and records what each
+// deleted
+func (app *Config) purgeExpired(ctx context.Context) ([]data.PurgeResult, error) {
+	results, err := data.PurgeExpired(ctx, app.Models.LogEntry, time.Now())
+
+	for _, result := range results {
+		RetentionPurgedTotal.WithLabelValues(result.Policy).Add(float64(result.Deleted))
+		if result.Deleted > 0 {
+			Log.WithFields(logrus.Fields{
+				"action":  "purgeExpired",
+				"policy":  result.Policy,
+				"deleted": result.Deleted,
+			}).Info("Deleted expired log entries")
+		}
+	}
+
+	if err != nil {
+		RetentionPurgeErrors.Inc()
+		Log.WithError(err).Error("Failed to apply retention policies")
+	}
+
+	return results, err
+}
+
+// GetRetention returns the retention policies
+func (app *Config) GetRetention(w http.ResponseWriter, r *http.Request) {
+	policies, err := app.Models.LogEntry.RetentionPolicies(r.Context())
+	if err != nil {
+		Log.WithError(err).Error("Failed to load retention policies")
+		app.errorJSON(w, errors.New("unable to load retention policies"), http.StatusInternalServerError)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d retention policies", len(policies)),
+		Data:    RetentionRequest{Policies: policies},
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// SetRetention replaces the retention policies with those of the body. They
+// apply from the next retention run.
+func (app *Config) SetRetention(w http.ResponseWriter, r *http.Request) {
+	var req RetentionRequest
+	if err := app.readJSON(w, r, &req); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if req.Policies == nil {
+		req.Policies = []data.RetentionPolicy{}
+	}
+	if err := data.NormalizePolicies(req.Policies); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if err := app.Models.LogEntry.SetRetentionPolicies(r.Context(), req.Policies); err != nil {
+		Log.WithError(err).Error("Failed to save retention policies")
+		app.errorJSON(w, errors.New("unable to save retention policies"), http.StatusInternalServerError)
+		return
+	}
+
+	Log.WithFields(logrus.Fields{
+		"action":   "SetRetention",
+		"policies": req.Policies,
+	}).Info("Retention policies changed")
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d retention policies", len(req.Policies)),
+		Data:    req,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// PurgeNow applies the retention policies without waiting for the next run
+func (app *Config) PurgeNow(w http.ResponseWriter, r *http.Request) {
+	results, err := app.purgeExpired(r.Context())
+	if err != nil {
+		app.errorJSON(w, errors.New("unable to apply every retention policy"), http.StatusInternalServerError)
+		return
+	}
+
+	var deleted int64
+	for _, result := range results {
+		deleted += result.Deleted
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d log entries deleted", deleted),
+		Data:    results,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
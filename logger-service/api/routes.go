@@ -1,6 +1,7 @@
 package api
 
 import (
+	"log-service/pkg/authz"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -30,6 +31,29 @@ func (app *Config) Routes() http.Handler {
 	// Application-specific routes
 	mux.Post("/log", app.WriteLog)
 
+	// Reading logs takes the logs:read permission
+	mux.Group(func(mux chi.Router) {
+		mux.Use(authz.Verifier(app.JWTSecret))
+		mux.Use(authz.RequirePermission("logs:read"))
+
+		mux.Get("/logs", app.ListLogs)
+		mux.Get("/logs/export", app.ExportLogs)
+		mux.Get("/logs/stats", app.LogStats)
+		mux.Get("/logs/stats/top", app.TopMessages)
+		mux.Get("/logs/tail", app.TailLogs)
+		mux.Get("/logs/{id}", app.GetLog)
+	})
+
+	// Admin API, managing retention takes the logs:admin permission
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Use(authz.Verifier(app.JWTSecret))
+		mux.Use(authz.RequirePermission("logs:admin"))
+
+		mux.Get("/retention", app.GetRetention)
+		mux.Put("/retention", app.SetRetention)
+		mux.Post("/retention/purge", app.PurgeNow)
+	})
+
 	return mux
 }
 
@@ -44,10 +68,10 @@ func (app *Config) HealthzHandler(w http.ResponseWriter, r *http.Request) {
 func (app *Config) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
 	app.Logger.Info("Readiness probe hit: /ready")
 
-	// Check if MongoDB is connected
-	if app.Client.Ping(r.Context(), nil) != nil {
-		app.Logger.Warn("Readiness probe failed: MongoDB is not connected")
-		http.Error(w, "MongoDB not connected", http.StatusServiceUnavailable)
+	// Check if the log store can be used
+	if err := app.Models.LogEntry.Ping(r.Context()); err != nil {
+		app.Logger.WithError(err).Warn("Readiness probe failed: log store is not available")
+		http.Error(w, "log store not available", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -2,11 +2,11 @@ package api
 
 import (
 	"context"
+	"encoding/gob"
 	"fmt"
 	"log-service/data"
 	"net"
 	"net/rpc"
-	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -21,10 +21,28 @@ type RPCServer struct {
 	Config *Config
 }
 
-// RPCPayload is the type for data we receive from RPC
+// RPCPayload is the type for data we receive from RPC. Only Name and Data
+// are required, older clients send nothing else.
 type RPCPayload struct {
-	Name string
-	Data string
+	Name       string
+	Data       string
+	Severity   string
+	Source     string
+	Host       string
+	TraceID    string
+	SpanID     string
+	Attributes map[string]any
+
+	// Wait answers only once the entry is stored, when the service buffers
+	// writes
+	Wait bool
+}
+
+func init() {
+	// Nested attribute values travel as interfaces, gob has to know them.
+	// Clients sending nested values register the same types.
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
 }
 
 // LogInfo writes our payload to mongo
@@ -38,16 +56,21 @@ func (r *RPCServer) LogInfo(payload RPCPayload, resp *string) error {
 	// Log the incoming payload
 	logger.Info("Processing log entry via RPC")
 
-	// Insert log entry into MongoDB
-	collection := r.Config.Client.Database("logs").Collection("logs")
-	_, err := collection.InsertOne(context.TODO(), data.LogEntry{
-		Name:      payload.Name,
-		Data:      payload.Data,
-		CreatedAt: time.Now(),
-	})
+	// Write the log entry through the store, so that it also reaches the
+	// live feed
+	err := writeEntry(context.Background(), r.Config.Models, r.Config.Writer, data.LogEntry{
+		Name:       payload.Name,
+		Data:       payload.Data,
+		Severity:   payload.Severity,
+		Source:     payload.Source,
+		Host:       payload.Host,
+		TraceID:    payload.TraceID,
+		SpanID:     payload.SpanID,
+		Attributes: payload.Attributes,
+	}, payload.Wait)
 	if err != nil {
 		// Log error if insertion fails
-		logger.WithError(err).Error("Error writing to MongoDB")
+		logger.WithError(err).Error("Error writing log entry")
 		return err
 	}
 
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log-service/data"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogStats counts log entries, grouped with ?group_by=name|severity|source
+// and in time buckets with ?bucket=minute|hour|day. It takes the filters of
+// ListLogs and covers the last 24 hours without ?from=.
+func (app *Config) LogStats(w http.ResponseWriter, r *http.Request) {
+	logger := Log.WithFields(logrus.Fields{
+		"action": "LogStats",
+		"query":  r.URL.RawQuery,
+	})
+
+	values := r.URL.Query()
+
+	filter, err := ParseLogFilter(values)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	query := data.StatsQuery{
+		Filter:  filter,
+		GroupBy: values.Get("group_by"),
+		Bucket:  values.Get("bucket"),
+	}
+	if err := query.Prepare(time.Now()); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	rows, err := app.Models.LogEntry.Stats(r.Context(), query)
+	if err != nil {
+		logger.WithError(err).Error("Failed to count logs")
+		app.errorJSON(w, errors.New("unable to count logs"), http.StatusInternalServerError)
+		return
+	}
+
+	var total int64
+	for _, row := range rows {
+		total += row.Count
+	}
+
+	stats := map[string]any{
+		"from":     query.Filter.From,
+		"group_by": query.GroupBy,
+		"bucket":   query.Bucket,
+		"total":    total,
+		"rows":     rows,
+	}
+	if !query.Filter.To.IsZero() {
+		stats["to"] = query.Filter.To
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d log entries", total),
+		Data:    stats,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// TopMessages returns the most frequent messages, as many as ?limit= (10 by
+// default). It takes the filters of ListLogs and covers the last 24 hours
+// without ?from=.
+func (app *Config) TopMessages(w http.ResponseWriter, r *http.Request) {
+	logger := Log.WithFields(logrus.Fields{
+		"action": "TopMessages",
+		"query":  r.URL.RawQuery,
+	})
+
+	values := r.URL.Query()
+
+	filter, err := ParseLogFilter(values)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var n int
+	if value := values.Get("limit"); value != "" {
+		if n, err = strconv.Atoi(value); err != nil || n < 1 {
+			app.errorJSON(w, fmt.Errorf("limit must be between 1 and %d", data.MaxTopMessages))
+			return
+		}
+	}
+	if n, err = data.PrepareTop(&filter, n, time.Now()); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	top, err := app.Models.LogEntry.TopMessages(r.Context(), filter, n)
+	if err != nil {
+		logger.WithError(err).Error("Failed to find top messages")
+		app.errorJSON(w, errors.New("unable to find top messages"), http.StatusInternalServerError)
+		return
+	}
+
+	view := map[string]any{
+		"from":     filter.From,
+		"messages": top,
+	}
+	if !filter.To.IsZero() {
+		view["to"] = filter.To
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d messages", len(top)),
+		Data:    view,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
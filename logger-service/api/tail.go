@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log-service/data"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// tailWriteTimeout disconnects tail clients that stop reading
+	tailWriteTimeout = 10 * time.Second
+
+	// tailHeartbeat keeps idle tails open through proxies
+	tailHeartbeat = 15 * time.Second
+)
+
+// Reasons a tail ends, as counted by TailDisconnects
+const (
+	tailLeft     = "left"
+	tailSlow     = "slow"
+	tailFailed   = "failed"
+	tailShutdown = "shutdown"
+)
+
+// errTailTooSlow is sent to the clients that fell too far behind
+var errTailTooSlow = errors.New("client too slow, entries were dropped")
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  512,
+	WriteBufferSize: 4096,
+
+	// Like the CORS policy of the API, any web page may tail the logs
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// TailLogs streams the entries written from now on, filtered with ?name=,
+// ?severity=, ?source=, ?trace_id= and ?q= like ListLogs. The request is
+// upgraded to a WebSocket when it asks for one, receiving every entry as a
+// JSON text message, and answered with server-sent events otherwise. A client
+// that falls more than tailBuffer entries behind is disconnected rather than
+// slowing down writers or silently missing entries.
+func (app *Config) TailLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := ParseLogFilter(r.URL.Query())
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if !filter.From.IsZero() || !filter.To.IsZero() {
+		app.errorJSON(w, errors.New("from and to do not apply to a tail"))
+		return
+	}
+
+	// Subscribed first, so that the client gets every entry written once it
+	// is answered
+	sub := data.Subscribe(filter, tailBuffer)
+	defer sub.Close()
+
+	transport := "sse"
+	if websocket.IsWebSocketUpgrade(r) {
+		transport = "websocket"
+	}
+
+	logger := Log.WithFields(logrus.Fields{
+		"action":    "TailLogs",
+		"transport": transport,
+		"query":     r.URL.RawQuery,
+	})
+
+	var sink tailSink
+	if transport == "websocket" {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader answered the request
+			logger.WithError(err).Warn("Failed to upgrade tail to WebSocket")
+			return
+		}
+		defer conn.Close()
+		sink = newWebSocketSink(conn)
+	} else {
+		sink, err = newEventSink(w, r)
+		if err != nil {
+			logger.WithError(err).Error("Failed to start tail")
+			return
+		}
+	}
+
+	TailClients.WithLabelValues(transport).Inc()
+	defer TailClients.WithLabelValues(transport).Dec()
+	logger.Info("Tail started")
+
+	reason := app.tail(sub, sink)
+
+	TailDisconnects.WithLabelValues(reason).Inc()
+	logger.WithFields(logrus.Fields{
+		"reason":  reason,
+		"dropped": sub.Dropped(),
+	}).Info("Tail ended")
+}
+
+// tail sends the entries of sub to the client until it leaves, falls
+// behind, cannot be written to or the service shuts down, and returns why
+// it ended
+func (app *Config) tail(sub *data.Subscription, sink tailSink) string {
+	heartbeat := time.NewTicker(tailHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case <-sink.gone():
+			return tailLeft
+		case <-app.Done:
+			sink.close(tailShutdown)
+			return tailShutdown
+		case <-heartbeat.C:
+			err = sink.heartbeat()
+		case entry := <-sub.C:
+			err = sink.send(entry)
+		}
+		if err != nil {
+			return tailFailed
+		}
+
+		if sub.Dropped() > 0 {
+			sink.close(tailSlow)
+			return tailSlow
+		}
+	}
+}
+
+// tailSink sends a tail to one client
+type tailSink interface {
+	send(entry *data.LogEntry) error
+	heartbeat() error
+
+	// close tells the client why the tail ends, as far as it can be told
+	close(reason string)
+
+	// gone is closed once the client has left
+	gone() <-chan struct{}
+}
+
+// webSocketSink sends every entry as a JSON text message
+type webSocketSink struct {
+	conn *websocket.Conn
+	left chan struct{}
+}
+
+func newWebSocketSink(conn *websocket.Conn) *webSocketSink {
+	s := &webSocketSink{conn: conn, left: make(chan struct{})}
+
+	// Reading answers pings and notices the client leaving. Whatever the
+	// client sends is ignored.
+	conn.SetReadLimit(512)
+	go func() {
+		defer close(s.left)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *webSocketSink) send(entry *data.LogEntry) error {
+	s.conn.SetWriteDeadline(time.Now().Add(tailWriteTimeout))
+	return s.conn.WriteJSON(entry)
+}
+
+func (s *webSocketSink) heartbeat() error {
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(tailWriteTimeout))
+}
+
+func (s *webSocketSink) close(reason string) {
+	code, text := websocket.CloseGoingAway, "service shutting down"
+	if reason == tailSlow {
+		code, text = websocket.ClosePolicyViolation, errTailTooSlow.Error()
+	}
+	s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(tailWriteTimeout))
+}
+
+func (s *webSocketSink) gone() <-chan struct{} {
+	return s.left
+}
+
+// eventSink sends every entry as a server-sent event named log, with the id
+// of the entry
+type eventSink struct {
+	w          io.Writer
+	controller *http.ResponseController
+	left       <-chan struct{}
+}
+
+func newEventSink(w http.ResponseWriter, r *http.Request) (*eventSink, error) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	s := &eventSink{w: w, controller: http.NewResponseController(w), left: r.Context().Done()}
+
+	// Sent right away so that the client knows the tail started
+	return s, s.write(": tailing\n\n")
+}
+
+func (s *eventSink) send(entry *data.LogEntry) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.write(fmt.Sprintf("id: %s\nevent: log\ndata: %s\n\n", entry.ID, raw))
+}
+
+func (s *eventSink) heartbeat() error {
+	return s.write(": ping\n\n")
+}
+
+func (s *eventSink) close(reason string) {
+	if reason != tailSlow {
+		return
+	}
+	raw, _ := json.Marshal(map[string]string{"error": errTailTooSlow.Error()})
+	s.write(fmt.Sprintf("event: error\ndata: %s\n\n", raw))
+}
+
+func (s *eventSink) gone() <-chan struct{} {
+	return s.left
+}
+
+// write sends one event right away, giving up on clients that stop reading
+func (s *eventSink) write(event string) error {
+	// Not every writer has deadlines, the test recorder among them
+	s.controller.SetWriteDeadline(time.Now().Add(tailWriteTimeout))
+
+	if _, err := io.WriteString(s.w, event); err != nil {
+		return err
+	}
+	return s.controller.Flush()
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"log-service/data"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NewWriter starts a buffered writer on the models, recording its flushes
+func NewWriter(models data.Models, opts data.WriterOptions) *data.BufferedWriter {
+	opts.OnFlush = func(written int, err error) {
+		if err != nil {
+			LogBufferFlushes.WithLabelValues("failure").Inc()
+			LogInsertionErrors.Inc()
+			Log.WithError(err).WithFields(logrus.Fields{
+				"action":  "flush",
+				"written": written,
+			}).Error("Failed to flush buffered log entries")
+			return
+		}
+		LogBufferFlushes.WithLabelValues("success").Inc()
+	}
+
+	return data.NewBufferedWriter(models.LogEntry, opts)
+}
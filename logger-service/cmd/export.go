@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log-service/api"
+	"log-service/internal/export"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const exportUsage = `usage: loggerApp export [-format ndjson|csv] [-gzip] [-name NAME] [-severity LIST]
+                        [-source SOURCE] [-trace_id ID] [-q WORDS] [-from TIME] [-to TIME] [FILE]`
+
+// runExportCommand implements "loggerApp export", the command line
+// counterpart of GET /logs/export, reading from the store chosen with
+// LOG_STORE. The filter flags are the query parameters of the endpoint. The
+// export is written to standard output when FILE is "-" or missing.
+func runExportCommand(args []string) {
+	// Standard output may carry the export itself
+	api.Log.SetOutput(os.Stderr)
+	logger := api.Log
+
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	formatName := flags.String("format", "", "ndjson or csv, taken from the file extension by default")
+	compress := flags.Bool("gzip", false, "gzip the export, the default for files ending in .gz")
+	filters := map[string]*string{}
+	for _, name := range []string{"name", "severity", "source", "trace_id", "q", "from", "to"} {
+		filters[name] = flags.String(name, "", "only export entries matching ?"+name+"= of GET /logs")
+	}
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, exportUsage) }
+	flags.Parse(args)
+
+	path := flags.Arg(0)
+	if path == "" {
+		path = "-"
+	}
+
+	opts, err := exportOptions(*formatName, *compress, path)
+	if err != nil {
+		logger.WithError(err).Fatal("Unknown export format")
+	}
+
+	values := url.Values{}
+	for name, value := range filters {
+		if *value != "" {
+			values.Set(name, *value)
+		}
+	}
+	if opts.Filter, err = api.ParseLogFilter(values); err != nil {
+		logger.WithError(err).Fatal("Invalid filter")
+	}
+
+	ctx := context.Background()
+
+	store, err := openStore(ctx)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to open log store")
+	}
+	defer store.Close(ctx)
+
+	out := io.Writer(os.Stdout)
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to create export file")
+		}
+		defer f.Close()
+		out = f
+	}
+
+	count, err := export.Export(ctx, store, out, opts)
+	if err != nil {
+		logger.WithError(err).Fatal("Export failed")
+	}
+	fmt.Fprintf(os.Stderr, "exported %d log entries\n", count)
+}
+
+// exportOptions returns the format named on the command line, or else the
+// one of the file extension, NDJSON for standard output. Files ending in .gz
+// are compressed.
+func exportOptions(name string, compress bool, path string) (export.Options, error) {
+	opts := export.Options{Format: export.NDJSON, Gzip: compress}
+
+	if path != "-" && strings.HasSuffix(path, ".gz") {
+		opts.Gzip = true
+		path = strings.TrimSuffix(path, ".gz")
+	}
+
+	var err error
+	switch {
+	case name != "":
+		opts.Format, err = export.ParseFormat(name)
+	case path != "-":
+		opts.Format, err = export.ParseFormat(filepath.Ext(path))
+	}
+	return opts, err
+}
@@ -5,13 +5,18 @@ import (
 	"fmt"
 	"log-service/api"
 	"log-service/data"
+	"log-service/internal/archive"
 	"log-service/internal/tracing"
 	"net/http"
 	"net/rpc"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel"
 )
 
@@ -21,8 +26,6 @@ const (
 	mongoURL = "mongodb://mongo:27017"
 )
 
-var client *mongo.Client
-
 type Config struct {
 	Models data.Models
 }
@@ -31,6 +34,17 @@ func main() {
 	api.InitLogger()
 	logger := api.Log
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			runExportCommand(os.Args[2:])
+			return
+		case "restore":
+			runRestoreCommand(os.Args[2:])
+			return
+		}
+	}
+
 	ctx := context.Background()
 
 	// Initialize tracing
@@ -46,30 +60,68 @@ func main() {
 
 	logger.WithField("service", "logger-service").Info("Starting logger service")
 
-	// Trace MongoDB connection
-	ctx, mongoSpan := tracer.Start(ctx, "connect_to_mongodb")
-	mongoClient, err := api.ConnectToMongo(mongoURL)
-	mongoSpan.End()
+	// Trace opening the log store
+	ctx, storeSpan := tracer.Start(ctx, "open_log_store")
+	store, err := openStore(ctx)
+	storeSpan.End()
 	if err != nil {
-		logger.WithError(err).Fatal("Failed to connect to MongoDB")
+		logger.WithError(err).Fatal("Failed to open log store")
 	}
-	client = mongoClient
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+	// Closed as the web server shuts down, ending the log tails
+	done := make(chan struct{})
 
-	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
-			logger.WithError(err).Error("Error disconnecting from MongoDB")
-			panic(err)
+	app := api.Config{
+		Models:    data.NewModels(store),
+		Logger:    logger,
+		JWTSecret: []byte(os.Getenv("JWT_SECRET")),
+		Done:      done,
+	}
+
+	// Buffer single log entries and write them in batches
+	opts, buffered, err := writerOptions()
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid write buffer settings")
+	}
+	if buffered {
+		app.Writer = api.NewWriter(app.Models, opts)
+	} else {
+		logger.Warn("LOG_BUFFER_SIZE is 0, log entries are written one at a time")
+	}
+	if len(app.JWTSecret) == 0 {
+		logger.Warn("JWT_SECRET is not set, every request to read logs or manage retention will be refused")
+	}
+
+	// Apply the retention policies in the background
+	interval, err := jobInterval("LOG_RETENTION_INTERVAL")
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid LOG_RETENTION_INTERVAL")
+	}
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go app.RunRetention(jobsCtx, interval)
+
+	// Move old entries to the archive in the background
+	if dir := os.Getenv("LOG_ARCHIVE_DIR"); dir != "" {
+		after, interval, err := archiveSettings()
+		if err != nil {
+			logger.WithError(err).Fatal("Invalid archive settings")
 		}
-		logger.Info("Disconnected from MongoDB")
-	}()
+		logs, err := archive.Open(dir, archive.Options{})
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to open log archive")
+		}
+		logger.WithFields(logrus.Fields{"dir": dir, "after": after.String()}).Info("Archiving old log entries")
+		go app.RunArchiver(jobsCtx, logs, after, interval)
+	}
 
-	app := api.Config{
-		Models: data.New(client),
-		Client: client,
-		Logger: logger,
+	// Tail the entries written by every instance where Mongo has change streams
+	if mongoStore, ok := store.(*data.MongoStore); ok {
+		if err := mongoStore.WatchChanges(jobsCtx); err != nil {
+			logger.WithError(err).Info("Change streams are not available, log tails only see this instance")
+		} else {
+			logger.Info("Following log changes through a Mongo change stream")
+		}
 	}
 
 	// Prometheus metrics
@@ -99,9 +151,143 @@ func main() {
 		Addr:    fmt.Sprintf(":%s", webPort),
 		Handler: app.Routes(),
 	}
+	srv.RegisterOnShutdown(func() { close(done) })
 	srvSpan.End()
 
-	if err := srv.ListenAndServe(); err != nil {
-		logger.WithError(err).Fatal("Web server encountered a fatal error")
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.WithError(err).Fatal("Web server encountered a fatal error")
+		}
+	}()
+
+	// Run until asked to stop, then write what is still buffered
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-signals.Done()
+	logger.Info("Shutting down logger service")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.WithError(err).Error("Error shutting down web server")
+	}
+	stopJobs()
+
+	if app.Writer != nil {
+		if err := app.Writer.Close(shutdownCtx); err != nil {
+			logger.WithError(err).Error("Error flushing buffered log entries")
+		} else {
+			logger.Info("Flushed buffered log entries")
+		}
+	}
+
+	if err := store.Close(shutdownCtx); err != nil {
+		logger.WithError(err).Error("Error closing log store")
+		return
+	}
+	logger.Info("Closed log store")
+}
+
+// writerOptions reads the write buffer settings: LOG_BUFFER_SIZE entries may
+// wait (10000 by default, 0 writes every entry right away), flushed by
+// LOG_BATCH_SIZE (500 by default) or every LOG_FLUSH_INTERVAL (1s by default)
+func writerOptions() (data.WriterOptions, bool, error) {
+	opts := data.WriterOptions{Capacity: 10000, BatchSize: 500, FlushInterval: time.Second}
+
+	for key, value := range map[string]*int{"LOG_BUFFER_SIZE": &opts.Capacity, "LOG_BATCH_SIZE": &opts.BatchSize} {
+		raw := os.Getenv(key)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return opts, false, fmt.Errorf("%s must be a whole number of entries", key)
+		}
+		*value = n
+	}
+
+	if raw := os.Getenv("LOG_FLUSH_INTERVAL"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil || interval <= 0 {
+			return opts, false, fmt.Errorf("LOG_FLUSH_INTERVAL must be a positive duration")
+		}
+		opts.FlushInterval = interval
+	}
+
+	return opts, opts.Capacity > 0, nil
+}
+
+// archiveSettings reads how old entries are archived: those older than
+// LOG_ARCHIVE_DAYS (30 by default), every LOG_ARCHIVE_INTERVAL (hourly by
+// default)
+func archiveSettings() (time.Duration, time.Duration, error) {
+	days := 30
+	if raw := os.Getenv("LOG_ARCHIVE_DAYS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("LOG_ARCHIVE_DAYS must be a whole number of days")
+		}
+		days = n
+	}
+
+	interval, err := jobInterval("LOG_ARCHIVE_INTERVAL")
+	if err != nil {
+		return 0, 0, fmt.Errorf("LOG_ARCHIVE_INTERVAL: %w", err)
+	}
+
+	return time.Duration(days) * 24 * time.Hour, interval, nil
+}
+
+// jobInterval returns how often a background job runs, set with the key
+// variable (hourly by default)
+func jobInterval(key string) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return time.Hour, nil
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if interval < time.Minute {
+		return 0, fmt.Errorf("%s is less than a minute", interval)
+	}
+	return interval, nil
+}
+
+// openStore opens the log store chosen with LOG_STORE: mongo (the default),
+// memory, or file, appending to the LOG_FILE file (logs.ndjson by default)
+func openStore(ctx context.Context) (data.LogStore, error) {
+	switch kind := os.Getenv("LOG_STORE"); kind {
+	case "", "mongo":
+		mongoClient, err := api.ConnectToMongo(mongoURL)
+		if err != nil {
+			return nil, err
+		}
+		store := data.NewMongoStore(mongoClient)
+
+		// The service can run without them, queries are only slower
+		if err := store.CreateIndexes(ctx); err != nil {
+			api.Log.WithError(err).Error("Failed to create log indexes")
+		}
+		return store, nil
+	case "memory":
+		api.Log.Warn("Log entries are kept in memory and lost when the service stops")
+		return data.NewMemoryStore(), nil
+	case "file":
+		path := os.Getenv("LOG_FILE")
+		if path == "" {
+			path = "logs.ndjson"
+		}
+		api.Log.WithField("path", path).Info("Log entries are kept in a file")
+		store, err := data.OpenFileStore(path)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown LOG_STORE %q, use mongo, memory or file", kind)
 	}
 }
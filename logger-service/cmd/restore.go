@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log-service/api"
+	"log-service/internal/archive"
+	"net/url"
+	"os"
+	"time"
+)
+
+const restoreUsage = `usage: loggerApp restore [-dir DIR] [-list] -from TIME [-to TIME]`
+
+// runRestoreCommand implements "loggerApp restore", importing the archived
+// entries created between -from and -to back into the store chosen with
+// LOG_STORE. Times are those of ?from= and ?to= of GET /logs.
+func runRestoreCommand(args []string) {
+	logger := api.Log
+
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	dir := flags.String("dir", os.Getenv("LOG_ARCHIVE_DIR"), "archive directory, LOG_ARCHIVE_DIR by default")
+	list := flags.Bool("list", false, "list the segments holding the range instead of restoring them")
+	from := flags.String("from", "", "restore entries created from this time")
+	to := flags.String("to", "", "restore entries created before this time, now by default")
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, restoreUsage) }
+	flags.Parse(args)
+
+	if *dir == "" || *from == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	filter, err := api.ParseLogFilter(url.Values{"from": {*from}, "to": {*to}})
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid time range")
+	}
+
+	logs, err := archive.Open(*dir, archive.Options{})
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to open log archive")
+	}
+
+	if *list {
+		for _, segment := range logs.Segments(filter.From, filter.To) {
+			fmt.Printf("%s\t%s\t%s\t%d\n", segment.File, segment.From.Format(time.RFC3339), segment.To.Format(time.RFC3339), segment.Entries)
+		}
+		return
+	}
+
+	ctx := context.Background()
+
+	store, err := openStore(ctx)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to open log store")
+	}
+	defer store.Close(ctx)
+
+	restored, err := logs.Restore(ctx, store, filter.From, filter.To, time.Now())
+	if err != nil {
+		logger.WithError(err).WithField("restored", restored).Fatal("Restore failed")
+	}
+	fmt.Fprintf(os.Stderr, "restored %d log entries\n", restored)
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// changeStreamRetry is how long a broken change stream waits before it is
+// opened again
+const changeStreamRetry = 5 * time.Second
+
+// WatchChanges feeds the subscriptions from a change stream on the logs
+// collection until ctx is done, so that they see the entries written by every
+// instance of the service. Change streams need a replica set: on a standalone
+// server an error is returned right away and subscriptions keep seeing the
+// entries written by this instance only. While a broken stream is opened
+// again they fall back to those too, and entries may be missed or repeated.
+func (s *MongoStore) WatchChanges(ctx context.Context) error {
+	stream, err := s.watch(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	feed.changes.Store(true)
+	go s.follow(ctx, stream)
+	return nil
+}
+
+// watch opens a change stream of inserted entries, after token if set
+func (s *MongoStore) watch(ctx context.Context, token bson.Raw) (*mongo.ChangeStream, error) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+
+	opts := options.ChangeStream()
+	if token != nil {
+		opts.SetResumeAfter(token)
+	}
+	return s.collection().Watch(ctx, pipeline, opts)
+}
+
+// follow publishes the entries of the stream, opening it again when it breaks
+func (s *MongoStore) follow(ctx context.Context, stream *mongo.ChangeStream) {
+	defer feed.changes.Store(false)
+
+	for {
+		for stream.Next(ctx) {
+			var event struct {
+				FullDocument LogEntry `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&event); err != nil {
+				log.Println("Error decoding change of logs:", err)
+				continue
+			}
+			entry := event.FullDocument
+			feed.publish(&entry)
+		}
+
+		token := stream.ResumeToken()
+		err := stream.Err()
+		stream.Close(context.Background())
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Println("Change stream of logs broke, following this instance only:", err)
+		feed.changes.Store(false)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(changeStreamRetry):
+			}
+
+			// The resume token may have left the oplog, then start afresh
+			stream, err = s.watch(ctx, token)
+			if err != nil && token != nil {
+				stream, err = s.watch(ctx, nil)
+			}
+			if err == nil {
+				break
+			}
+			log.Println("Error opening change stream of logs:", err)
+		}
+
+		log.Println("Change stream of logs opened again")
+		feed.changes.Store(true)
+	}
+}
@@ -0,0 +1,135 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Severities of log entries, from the least to the most severe
+const (
+	SeverityDebug   = "debug"
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+	SeverityFatal   = "fatal"
+)
+
+const (
+	// MaxAttributes bounds how many attributes one entry may carry
+	MaxAttributes = 64
+
+	// maxAttributeKey bounds the length of attribute names
+	maxAttributeKey = 128
+)
+
+// ErrInvalidEntry is returned for log entries that cannot be stored
+var ErrInvalidEntry = errors.New("invalid log entry")
+
+// severities maps every accepted spelling to the stored severity
+var severities = map[string]string{
+	"":              SeverityInfo,
+	SeverityDebug:   SeverityDebug,
+	SeverityInfo:    SeverityInfo,
+	SeverityWarning: SeverityWarning,
+	"warn":          SeverityWarning,
+	SeverityError:   SeverityError,
+	SeverityFatal:   SeverityFatal,
+}
+
+// Normalize checks an entry before it is stored and brings it to its stored
+// form: the severity is lower case and defaults to info, and attribute numbers
+// without a fraction become integers so that they are stored as such.
+func (l *LogEntry) Normalize() error {
+	severity, ok := severities[strings.ToLower(strings.TrimSpace(l.Severity))]
+	if !ok {
+		return fmt.Errorf("%w: unknown severity %q", ErrInvalidEntry, l.Severity)
+	}
+	l.Severity = severity
+
+	if len(l.Attributes) > MaxAttributes {
+		return fmt.Errorf("%w: more than %d attributes", ErrInvalidEntry, MaxAttributes)
+	}
+
+	attributes, err := normalizeDocument(l.Attributes)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEntry, err)
+	}
+	l.Attributes = attributes
+
+	return nil
+}
+
+// normalizeDocument checks the keys of an attribute document and normalizes
+// its values
+func normalizeDocument(doc map[string]any) (map[string]any, error) {
+	if len(doc) == 0 {
+		return nil, nil
+	}
+
+	out := make(map[string]any, len(doc))
+	for key, value := range doc {
+		// Mongo gives dots and leading dollars a meaning in field names
+		if key == "" || len(key) > maxAttributeKey || strings.Contains(key, ".") || strings.HasPrefix(key, "$") {
+			return nil, fmt.Errorf("invalid attribute name %q", key)
+		}
+
+		normalized, err := normalizeValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %q: %w", key, err)
+		}
+		out[key] = normalized
+	}
+
+	return out, nil
+}
+
+// normalizeValue returns an attribute value in its stored form
+func normalizeValue(value any) (any, error) {
+	switch v := value.(type) {
+	case nil, string, bool, int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v), nil
+		}
+		return v, nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		return v.Float64()
+	case map[string]any:
+		doc, err := normalizeDocument(v)
+		if doc == nil && err == nil {
+			doc = map[string]any{}
+		}
+		return doc, err
+	case []any:
+		return normalizeList(v)
+	case primitive.A:
+		return normalizeList(v)
+	default:
+		return nil, fmt.Errorf("unsupported value of type %T", value)
+	}
+}
+
+func normalizeList(list []any) ([]any, error) {
+	out := make([]any, len(list))
+	for i, value := range list {
+		normalized, err := normalizeValue(value)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = normalized
+	}
+	return out, nil
+}
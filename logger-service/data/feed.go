@@ -0,0 +1,163 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+)
+
+// feed hands every log entry written by this instance to the subscriptions
+// following new entries, whatever the store, unless a Mongo change stream
+// hands them the entries written by every instance instead
+var feed = &broadcaster{subs: map[*Subscription]struct{}{}}
+
+type broadcaster struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+
+	// changes is set while a change stream feeds the subscriptions
+	changes atomic.Bool
+}
+
+// Subscription receives the log entries matching its filter that are written
+// after it was made. Entries are dropped rather than holding up writers when
+// the subscriber falls more than its buffer behind.
+type Subscription struct {
+	C <-chan *LogEntry
+
+	c       chan *LogEntry
+	filter  LogFilter
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+// Subscribe follows the entries written from now on that match filter.
+// Entries written by other instances of the service are only seen while
+// MongoStore.WatchChanges runs. Close the subscription when done.
+func Subscribe(filter LogFilter, buffer int) *Subscription {
+	c := make(chan *LogEntry, buffer)
+	sub := &Subscription{C: c, c: c, filter: filter}
+
+	feed.mu.Lock()
+	feed.subs[sub] = struct{}{}
+	feed.mu.Unlock()
+
+	return sub
+}
+
+// Dropped returns how many entries were skipped because the subscriber was
+// too slow
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close stops the subscription and closes C
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		feed.mu.Lock()
+		delete(feed.subs, s)
+		feed.mu.Unlock()
+		close(s.c)
+	})
+}
+
+// publish hands newly written entries to the subscriptions they match
+func (b *broadcaster) publish(entries ...*LogEntry) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		for _, entry := range entries {
+			if !sub.filter.Matches(entry) {
+				continue
+			}
+			select {
+			case sub.c <- entry:
+			default:
+				sub.dropped.Add(1)
+			}
+		}
+	}
+}
+
+// publishingStore hands the entries written through a store to the feed
+type publishingStore struct {
+	LogStore
+}
+
+func (s publishingStore) Insert(ctx context.Context, entry LogEntry) (*LogEntry, error) {
+	stored, err := s.LogStore.Insert(ctx, entry)
+	if err == nil && !feed.changes.Load() {
+		feed.publish(stored)
+	}
+	return stored, err
+}
+
+func (s publishingStore) InsertMany(ctx context.Context, entries []LogEntry) ([]*LogEntry, error) {
+	written, err := s.LogStore.InsertMany(ctx, entries)
+	if !feed.changes.Load() {
+		feed.publish(written...)
+	}
+	return written, err
+}
+
+// Matches reports whether an entry is selected by the filter. Search words
+// are compared case-insensitively with the words of the data, any of them
+// matching like a text index query, but without stemming.
+func (f LogFilter) Matches(entry *LogEntry) bool {
+	if f.Name != "" && entry.Name != f.Name {
+		return false
+	}
+	if !f.From.IsZero() && entry.CreatedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !entry.CreatedAt.Before(f.To) {
+		return false
+	}
+	if f.Source != "" && entry.Source != f.Source {
+		return false
+	}
+	if f.TraceID != "" && entry.TraceID != f.TraceID {
+		return false
+	}
+
+	if len(f.Severity) > 0 {
+		found := false
+		for _, severity := range f.Severity {
+			if entry.Severity == severity {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.Search != "" {
+		words := map[string]bool{}
+		for _, word := range strings.FieldsFunc(strings.ToLower(entry.Data), isSeparator) {
+			words[word] = true
+		}
+
+		found := false
+		for _, word := range strings.FieldsFunc(strings.ToLower(f.Search), isSeparator) {
+			if words[word] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isSeparator reports whether r separates words
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
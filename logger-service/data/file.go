@@ -0,0 +1,323 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileStore appends every log entry to a file of newline delimited JSON and
+// answers queries from memory, loading the file again when it is opened. It
+// suits a single instance without Mongo, such as a development machine.
+// Retention policies are kept next to it, in a file named after it with a
+// .retention.json suffix.
+type FileStore struct {
+	*MemoryStore
+
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int64
+}
+
+// OpenFileStore opens or creates the file at path and loads its entries. A
+// last line cut short, by a crash in the middle of a write, is dropped.
+func OpenFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{MemoryStore: NewMemoryStore(), path: path, file: file}
+
+	if err := s.load(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := s.loadPolicies(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", s.policiesPath(), err)
+	}
+
+	return s, nil
+}
+
+// load reads every complete line of the file into memory
+func (s *FileStore) load() error {
+	reader := bufio.NewReader(s.file)
+
+	for line := 1; ; line++ {
+		raw, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(raw) > 0 {
+				// Cut short, the next write starts a fresh line
+				if err := s.file.Truncate(s.size); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		entry, err := decodeLine(raw)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		s.MemoryStore.add(entry)
+		s.size += int64(len(raw))
+	}
+
+	_, err := s.file.Seek(s.size, io.SeekStart)
+	return err
+}
+
+// loadPolicies reads the retention policies, if any were set
+func (s *FileStore) loadPolicies() error {
+	raw, err := os.ReadFile(s.policiesPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var policies []RetentionPolicy
+	if err := json.Unmarshal(raw, &policies); err != nil {
+		return err
+	}
+	if err := NormalizePolicies(policies); err != nil {
+		return err
+	}
+
+	return s.MemoryStore.SetRetentionPolicies(context.Background(), policies)
+}
+
+func (s *FileStore) policiesPath() string {
+	return s.path + ".retention.json"
+}
+
+// decodeLine reads one stored entry. Numbers are decoded as they were
+// written and normalized again, so that attributes keep their types.
+func decodeLine(raw []byte) (*LogEntry, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var entry LogEntry
+	if err := decoder.Decode(&entry); err != nil {
+		return nil, err
+	}
+	if entry.ID == "" {
+		return nil, errors.New("entry without an id")
+	}
+	if err := entry.Normalize(); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// Insert stores one entry
+func (s *FileStore) Insert(ctx context.Context, entry LogEntry) (*LogEntry, error) {
+	// Checked first so that the error does not name the entry of a batch
+	if err := entry.Normalize(); err != nil {
+		return nil, err
+	}
+
+	written, err := s.InsertMany(ctx, []LogEntry{entry})
+	if err != nil {
+		return nil, err
+	}
+	return written[0], nil
+}
+
+// InsertMany appends a batch of entries to the file in one write, all of
+// them or none
+func (s *FileStore) InsertMany(ctx context.Context, entries []LogEntry) ([]*LogEntry, error) {
+	stored, err := prepare(entries, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(stored); err != nil {
+		return nil, err
+	}
+
+	s.MemoryStore.add(stored...)
+	return cloneAll(stored), nil
+}
+
+// Import appends the entries the store does not hold yet, as they were
+// exported, in one write
+func (s *FileStore) Import(ctx context.Context, entries []LogEntry) (int64, error) {
+	imported, err := prepareImport(entries)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	imported = s.MemoryStore.fresh(imported)
+	if len(imported) == 0 {
+		return 0, nil
+	}
+	if err := s.append(imported); err != nil {
+		return 0, err
+	}
+
+	s.MemoryStore.add(imported...)
+	return int64(len(imported)), nil
+}
+
+// append writes entries at the end of the file in one write
+func (s *FileStore) append(entries []*LogEntry) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(buf.Bytes())
+	if err != nil {
+		// Leave no partial line behind
+		if n > 0 {
+			s.file.Truncate(s.size)
+			s.file.Seek(s.size, io.SeekStart)
+		}
+		return err
+	}
+	s.size += int64(n)
+	return nil
+}
+
+// Purge deletes the entries matching a rule by writing the file again
+// without them
+func (s *FileStore) Purge(ctx context.Context, rule PurgeRule) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.rewrite(rule.matches); err != nil {
+		return 0, err
+	}
+	return s.MemoryStore.Purge(ctx, rule)
+}
+
+// Delete deletes the entries with the given ids by writing the file again
+// without them
+func (s *FileStore) Delete(ctx context.Context, ids []string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.rewrite(hasID(ids)); err != nil {
+		return 0, err
+	}
+	return s.MemoryStore.Delete(ctx, ids)
+}
+
+// rewrite writes the file again without the entries doomed selects
+func (s *FileStore) rewrite(doomed func(*LogEntry) bool) error {
+	kept, deleted := s.MemoryStore.kept(doomed)
+	if deleted == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range kept {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	// Until the rename the file still holds every entry
+	if err := writeFileAtomic(s.path, buf.Bytes()); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(int64(buf.Len()), io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+
+	s.file.Close()
+	s.file = file
+	s.size = int64(buf.Len())
+	return nil
+}
+
+// SetRetentionPolicies replaces the retention policies and writes them to
+// their file
+func (s *FileStore) SetRetentionPolicies(ctx context.Context, policies []RetentionPolicy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if policies == nil {
+		policies = []RetentionPolicy{}
+	}
+
+	raw, err := json.MarshalIndent(policies, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.policiesPath(), raw); err != nil {
+		return err
+	}
+
+	return s.MemoryStore.SetRetentionPolicies(ctx, policies)
+}
+
+// writeFileAtomic replaces the file at path with content, so that a crash
+// leaves either the old content or the new one
+func writeFileAtomic(path string, content []byte) error {
+	tmp := path + ".tmp"
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Ping checks that the file is still open
+func (s *FileStore) Ping(ctx context.Context) error {
+	_, err := s.file.Stat()
+	return err
+}
+
+// Close closes the file
+func (s *FileStore) Close(ctx context.Context) error {
+	return s.file.Close()
+}
@@ -0,0 +1,334 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps log entries in process memory, for development and
+// tests. They are lost when the service stops.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	entries  []*LogEntry
+	byID     map[string]*LogEntry
+	policies []RetentionPolicy
+}
+
+// NewMemoryStore returns an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{byID: map[string]*LogEntry{}}
+}
+
+// Insert stores one entry
+func (s *MemoryStore) Insert(ctx context.Context, entry LogEntry) (*LogEntry, error) {
+	stored, err := newDocument(entry, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	stored.ID = primitive.NewObjectID().Hex()
+
+	s.add(&stored)
+	return clone(&stored), nil
+}
+
+// InsertMany stores a batch of entries, all of them or none
+func (s *MemoryStore) InsertMany(ctx context.Context, entries []LogEntry) ([]*LogEntry, error) {
+	stored, err := prepare(entries, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	s.add(stored...)
+	return cloneAll(stored), nil
+}
+
+// Find returns one page of the entries matching the query. Search matches
+// whole words like the Mongo text index, without its stemming.
+func (s *MemoryStore) Find(ctx context.Context, query LogQuery) (*LogPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return findIn(s.entries, query)
+}
+
+// FindOne returns the entry with the given id, or ErrNotFound
+func (s *MemoryStore) FindOne(ctx context.Context, id string) (*LogEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.byID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(entry), nil
+}
+
+// Stats counts the entries matching a query
+func (s *MemoryStore) Stats(ctx context.Context, query StatsQuery) ([]StatsRow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return statsIn(s.entries, query), nil
+}
+
+// TopMessages returns the most frequent messages of the entries matching
+// filter
+func (s *MemoryStore) TopMessages(ctx context.Context, filter LogFilter, n int) ([]MessageCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return topIn(s.entries, filter, n), nil
+}
+
+// Import stores entries as they were exported, skipping those already held
+func (s *MemoryStore) Import(ctx context.Context, entries []LogEntry) (int64, error) {
+	imported, err := prepareImport(entries)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var written int64
+	for _, entry := range imported {
+		if _, ok := s.byID[entry.ID]; ok {
+			continue
+		}
+		s.entries = append(s.entries, entry)
+		s.byID[entry.ID] = entry
+		written++
+	}
+	return written, nil
+}
+
+// Purge deletes the entries matching a rule
+func (s *MemoryStore) Purge(ctx context.Context, rule PurgeRule) (int64, error) {
+	return s.remove(rule.matches), nil
+}
+
+// Delete deletes the entries with the given ids
+func (s *MemoryStore) Delete(ctx context.Context, ids []string) (int64, error) {
+	return s.remove(hasID(ids)), nil
+}
+
+// RetentionPolicies returns the retention policies
+func (s *MemoryStore) RetentionPolicies(ctx context.Context) ([]RetentionPolicy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]RetentionPolicy{}, s.policies...), nil
+}
+
+// SetRetentionPolicies replaces the retention policies
+func (s *MemoryStore) SetRetentionPolicies(ctx context.Context, policies []RetentionPolicy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.policies = append([]RetentionPolicy{}, policies...)
+	return nil
+}
+
+// Ping always succeeds
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing, the entries stay readable
+func (s *MemoryStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// add keeps entries that are ready to be stored
+func (s *MemoryStore) add(entries ...*LogEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range entries {
+		s.entries = append(s.entries, entry)
+		s.byID[entry.ID] = entry
+	}
+}
+
+// remove deletes the entries doomed selects and returns how many it deleted
+func (s *MemoryStore) remove(doomed func(*LogEntry) bool) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.entries[:0]
+	var deleted int64
+	for _, entry := range s.entries {
+		if doomed(entry) {
+			delete(s.byID, entry.ID)
+			deleted++
+			continue
+		}
+		kept = append(kept, entry)
+	}
+
+	// Let the deleted entries be collected
+	clear(s.entries[len(kept):])
+	s.entries = kept
+
+	return deleted
+}
+
+// kept returns the entries doomed leaves, in the order they were added, and
+// how many it would delete
+func (s *MemoryStore) kept(doomed func(*LogEntry) bool) ([]*LogEntry, int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var kept []*LogEntry
+	for _, entry := range s.entries {
+		if !doomed(entry) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, int64(len(s.entries) - len(kept))
+}
+
+// fresh returns the entries whose id the store does not hold yet
+func (s *MemoryStore) fresh(entries []*LogEntry) []*LogEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []*LogEntry
+	for _, entry := range entries {
+		if _, ok := s.byID[entry.ID]; !ok {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// hasID selects the entries with one of the ids
+func hasID(ids []string) func(*LogEntry) bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return func(entry *LogEntry) bool { return set[entry.ID] }
+}
+
+// prepare returns entries as they are stored, with new ids, refusing the
+// batch if any of them is invalid
+func prepare(entries []LogEntry, now time.Time) ([]*LogEntry, error) {
+	stored := make([]*LogEntry, len(entries))
+	for i, entry := range entries {
+		doc, err := newDocument(entry, now)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		doc.ID = primitive.NewObjectID().Hex()
+		stored[i] = &doc
+	}
+	return stored, nil
+}
+
+// prepareImport checks entries that are imported with their id and times and
+// returns them as they are stored, each id once, refusing the batch if any of
+// them is invalid
+func prepareImport(entries []LogEntry) ([]*LogEntry, error) {
+	seen := make(map[string]bool, len(entries))
+	imported := make([]*LogEntry, 0, len(entries))
+	for i, entry := range entries {
+		if err := entry.Normalize(); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		if _, err := primitive.ObjectIDFromHex(entry.ID); err != nil {
+			return nil, fmt.Errorf("entry %d: %w: id %q is not an object id", i, ErrInvalidEntry, entry.ID)
+		}
+		if entry.CreatedAt.IsZero() {
+			return nil, fmt.Errorf("entry %d: %w: no creation time", i, ErrInvalidEntry)
+		}
+		if seen[entry.ID] {
+			continue
+		}
+		seen[entry.ID] = true
+
+		entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Millisecond)
+		entry.UpdatedAt = entry.UpdatedAt.UTC().Truncate(time.Millisecond)
+		imported = append(imported, &entry)
+	}
+	return imported, nil
+}
+
+// findIn pages through entries held in memory the way MongoStore.Find does
+// through the collection: sorted by creation time and then id, continuing
+// after the position in the cursor
+func findIn(entries []*LogEntry, query LogQuery) (*LogPage, error) {
+	var after *pageCursor
+	if query.Cursor != "" {
+		cursor, err := decodeCursor(query.Cursor)
+		if err != nil || cursor.Ascending != query.Ascending {
+			return nil, ErrInvalidCursor
+		}
+		after = &cursor
+	}
+
+	var matched []*LogEntry
+	for _, entry := range entries {
+		if !query.Filter.Matches(entry) {
+			continue
+		}
+		if after != nil {
+			position := &LogEntry{ID: after.ID.Hex(), CreatedAt: after.CreatedAt}
+			if query.Ascending && !sortsBefore(position, entry) || !query.Ascending && !sortsBefore(entry, position) {
+				continue
+			}
+		}
+		matched = append(matched, entry)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if query.Ascending {
+			return sortsBefore(matched[i], matched[j])
+		}
+		return sortsBefore(matched[j], matched[i])
+	})
+
+	page := &LogPage{Entries: []*LogEntry{}}
+
+	limit := query.limit()
+	if len(matched) > limit {
+		matched = matched[:limit]
+
+		last := matched[limit-1]
+		id, err := primitive.ObjectIDFromHex(last.ID)
+		if err != nil {
+			return nil, err
+		}
+		page.NextCursor = encodeCursor(pageCursor{CreatedAt: last.CreatedAt, ID: id, Ascending: query.Ascending})
+	}
+
+	page.Entries = append(page.Entries, cloneAll(matched)...)
+	return page, nil
+}
+
+// sortsBefore reports whether a comes before b in ascending order
+func sortsBefore(a, b *LogEntry) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+// clone and cloneAll copy stored entries, so that callers cannot change them
+func clone(entry *LogEntry) *LogEntry {
+	c := *entry
+	return &c
+}
+
+func cloneAll(entries []*LogEntry) []*LogEntry {
+	out := make([]*LogEntry, len(entries))
+	for i, entry := range entries {
+		out[i] = clone(entry)
+	}
+	return out
+}
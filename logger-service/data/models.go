@@ -2,145 +2,107 @@ package data
 
 import (
 	"context"
-	"log"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var client *mongo.Client
+// LogStore keeps log entries. Every ingestion path and query goes through
+// one, so that the service can run on Mongo or, for development and tests,
+// in memory or on a file.
+type LogStore interface {
+	// Insert stores one entry and returns it as stored, with its id and
+	// creation time. Entries that fail Normalize are refused with an error
+	// wrapping ErrInvalidEntry.
+	Insert(ctx context.Context, entry LogEntry) (*LogEntry, error)
 
-func New(mongo *mongo.Client) Models {
-	client = mongo
-
-	return Models{
-		LogEntry: LogEntry{},
-	}
-}
-
-type Models struct {
-	LogEntry LogEntry
-}
+	// InsertMany stores a batch of entries and returns those written. Nothing
+	// is written if any entry fails Normalize. Otherwise a store may write
+	// some entries and fail the others, returning both.
+	InsertMany(ctx context.Context, entries []LogEntry) ([]*LogEntry, error)
 
-type LogEntry struct {
-	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
-	Name      string    `bson:"name" json:"name"`
-	Data      string    `bson:"data" json:"data"`
-	CreatedAt time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
-}
+	// Find returns one page of the entries matching the query
+	Find(ctx context.Context, query LogQuery) (*LogPage, error)
 
-func (l *LogEntry) Insert(entry LogEntry) error {
-	collection := client.Database("logs").Collection("logs")
-
-	_, err := collection.InsertOne(context.TODO(), LogEntry{
-		Name:      entry.Name,
-		Data:      entry.Data,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	})
-	if err != nil {
-		log.Println("Error inserting into logs:", err)
-		return err
-	}
+	// FindOne returns the entry with the given id, or ErrNotFound
+	FindOne(ctx context.Context, id string) (*LogEntry, error)
 
-	return nil
-}
+	// Stats counts the entries matching a query that passed Prepare
+	Stats(ctx context.Context, query StatsQuery) ([]StatsRow, error)
 
-func (l *LogEntry) All() ([]*LogEntry, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+	// TopMessages returns the n most frequent messages of the entries
+	// matching filter, the most frequent first
+	TopMessages(ctx context.Context, filter LogFilter, n int) ([]MessageCount, error)
 
-	collection := client.Database("logs").Collection("logs")
+	// Import stores entries as they were exported, keeping their ids and
+	// times, and returns how many it wrote. Entries whose id is already
+	// stored are skipped, so that importing twice does no harm.
+	Import(ctx context.Context, entries []LogEntry) (int64, error)
 
-	opts := options.Find()
-	opts.SetSort(bson.D{bson.E{Key: "created_at", Value: -1}})
+	// Delete deletes the entries with the given ids and returns how many it
+	// deleted
+	Delete(ctx context.Context, ids []string) (int64, error)
 
-	cursor, err := collection.Find(context.TODO(), bson.D{}, opts)
-	if err != nil {
-		log.Println("Finding all docs error:", err)
-		return nil, err
-	}
-	defer cursor.Close(ctx)
+	// Purge deletes the entries matching a rule and returns how many it
+	// deleted
+	Purge(ctx context.Context, rule PurgeRule) (int64, error)
 
-	var logs []*LogEntry
+	// RetentionPolicies returns the retention policies kept with the entries
+	RetentionPolicies(ctx context.Context) ([]RetentionPolicy, error)
 
-	for cursor.Next(ctx) {
-		var item LogEntry
+	// SetRetentionPolicies replaces the retention policies with a set that
+	// passed NormalizePolicies
+	SetRetentionPolicies(ctx context.Context, policies []RetentionPolicy) error
 
-		err := cursor.Decode(&item)
-		if err != nil {
-			log.Print("Error decoding log into slice:", err)
-			return nil, err
-		} else {
-			logs = append(logs, &item)
-		}
-	}
+	// Ping reports whether the store can be used
+	Ping(ctx context.Context) error
 
-	return logs, nil
+	// Close releases the store
+	Close(ctx context.Context) error
 }
 
-func (l *LogEntry) GetOne(id string) (*LogEntry, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	collection := client.Database("logs").Collection("logs")
-
-	docID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return nil, err
-	}
-
-	var entry LogEntry
-	err = collection.FindOne(ctx, bson.M{"_id": docID}).Decode(&entry)
-	if err != nil {
-		return nil, err
-	}
-
-	return &entry, nil
+// New returns the models backed by Mongo
+func New(mongo *mongo.Client) Models {
+	return NewModels(NewMongoStore(mongo))
 }
 
-func (l *LogEntry) DropCollection() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	collection := client.Database("logs").Collection("logs")
-
-	if err := collection.Drop(ctx); err != nil {
-		return err
+// NewModels returns the models backed by a store. Entries written through
+// them also reach the live feed.
+func NewModels(store LogStore) Models {
+	return Models{
+		LogEntry: publishingStore{store},
 	}
-
-	return nil
 }
 
-func (l *LogEntry) Update() (*mongo.UpdateResult, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	collection := client.Database("logs").Collection("logs")
+type Models struct {
+	LogEntry LogStore
+}
 
-	docID, err := primitive.ObjectIDFromHex(l.ID)
-	if err != nil {
-		return nil, err
-	}
+type LogEntry struct {
+	ID         string         `bson:"_id,omitempty" json:"id,omitempty"`
+	Name       string         `bson:"name" json:"name"`
+	Data       string         `bson:"data" json:"data"`
+	Severity   string         `bson:"severity,omitempty" json:"severity,omitempty"`
+	Source     string         `bson:"source,omitempty" json:"source,omitempty"`
+	Host       string         `bson:"host,omitempty" json:"host,omitempty"`
+	TraceID    string         `bson:"trace_id,omitempty" json:"trace_id,omitempty"`
+	SpanID     string         `bson:"span_id,omitempty" json:"span_id,omitempty"`
+	Attributes map[string]any `bson:"attributes,omitempty" json:"attributes,omitempty"`
+	CreatedAt  time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time      `bson:"updated_at" json:"updated_at"`
+}
 
-	result, err := collection.UpdateOne(
-		ctx,
-		bson.M{"_id": docID},
-		bson.D{
-			bson.E{Key: "$set", Value: bson.D{
-				bson.E{Key: "name", Value: l.Name},
-				bson.E{Key: "data", Value: l.Data},
-				bson.E{Key: "updated_at", Value: time.Now()},
-			}}},
-	)
-
-	if err != nil {
-		return nil, err
+// newDocument returns entry as it is stored when written at now. Times are
+// kept to the millisecond in UTC, as Mongo stores them, so that every store
+// returns the same times.
+func newDocument(entry LogEntry, now time.Time) (LogEntry, error) {
+	if err := entry.Normalize(); err != nil {
+		return entry, err
 	}
 
-	return result, nil
+	now = now.UTC().Truncate(time.Millisecond)
+	entry.ID = ""
+	entry.CreatedAt = now
+	entry.UpdatedAt = now
+	return entry, nil
 }
@@ -0,0 +1,450 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// writeTimeout bounds every write to Mongo
+	writeTimeout = 15 * time.Second
+
+	// purgeTimeout bounds deleting the entries of one retention policy, which
+	// may be many after a policy is shortened
+	purgeTimeout = 10 * time.Minute
+)
+
+// duplicateKey is the code of writes refused by a unique index
+const duplicateKey = 11000
+
+// retentionID is the id of the settings document holding the retention
+// policies, replaced as a whole so that a set is never half written
+const retentionID = "retention"
+
+// MongoStore keeps log entries in the logs.logs collection
+type MongoStore struct {
+	client *mongo.Client
+}
+
+// NewMongoStore returns a store on a connected client
+func NewMongoStore(client *mongo.Client) *MongoStore {
+	return &MongoStore{client: client}
+}
+
+func (s *MongoStore) collection() *mongo.Collection {
+	return s.client.Database("logs").Collection("logs")
+}
+
+func (s *MongoStore) settings() *mongo.Collection {
+	return s.client.Database("logs").Collection("settings")
+}
+
+// Insert stores one entry
+func (s *MongoStore) Insert(ctx context.Context, entry LogEntry) (*LogEntry, error) {
+	stored, err := newDocument(entry, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	result, err := s.collection().InsertOne(ctx, stored)
+	if err != nil {
+		log.Println("Error inserting into logs:", err)
+		return nil, err
+	}
+
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		stored.ID = id.Hex()
+	}
+
+	return &stored, nil
+}
+
+// InsertMany writes a batch of entries in one request. The entries are
+// independent: when some cannot be written the others still are, and the
+// error is a mongo.BulkWriteException listing the failed ones.
+func (s *MongoStore) InsertMany(ctx context.Context, entries []LogEntry) ([]*LogEntry, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	// Ids are assigned here rather than by the driver, so that each entry
+	// keeps its own id whichever others fail
+	now := time.Now()
+	docs := make([]interface{}, len(entries))
+	stored := make([]LogEntry, len(entries))
+	for i, entry := range entries {
+		doc, err := newDocument(entry, now)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		doc.ID = primitive.NewObjectID().Hex()
+		stored[i] = doc
+
+		docs[i], err = importDocument(&stored[i])
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	result, err := s.collection().InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if result == nil {
+		log.Println("Error inserting into logs:", err)
+		return nil, err
+	}
+
+	failed := map[int]bool{}
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		for _, writeErr := range bulkErr.WriteErrors {
+			failed[writeErr.Index] = true
+		}
+	} else if err != nil {
+		log.Println("Error inserting into logs:", err)
+		return nil, err
+	}
+
+	written := make([]*LogEntry, 0, len(stored)-len(failed))
+	for i := range stored {
+		if !failed[i] {
+			written = append(written, &stored[i])
+		}
+	}
+
+	if err != nil {
+		log.Println("Error inserting into logs:", err)
+	}
+	return written, err
+}
+
+// CreateIndexes creates the indexes log queries rely on. Creating an index
+// that already exists does nothing.
+func (s *MongoStore) CreateIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	collection := s.collection()
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "severity", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "source", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "trace_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "data", Value: "text"}}},
+	})
+	return err
+}
+
+// Find returns one page of the log entries matching the query
+func (s *MongoStore) Find(ctx context.Context, query LogQuery) (*LogPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	limit := query.limit()
+
+	filter := query.Filter.bson()
+
+	if query.Cursor != "" {
+		cursor, err := decodeCursor(query.Cursor)
+		if err != nil || cursor.Ascending != query.Ascending {
+			return nil, ErrInvalidCursor
+		}
+
+		op := "$lt"
+		if query.Ascending {
+			op = "$gt"
+		}
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.M{"created_at": bson.M{op: cursor.CreatedAt}},
+			bson.M{"created_at": cursor.CreatedAt, "_id": bson.M{op: cursor.ID}},
+		}})
+	}
+
+	direction := -1
+	if query.Ascending {
+		direction = 1
+	}
+
+	// One more than asked for tells whether there is a next page
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(limit + 1))
+
+	collection := s.collection()
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	page := &LogPage{Entries: []*LogEntry{}}
+	for cursor.Next(ctx) {
+		var entry LogEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return nil, err
+		}
+		page.Entries = append(page.Entries, &entry)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Entries) > limit {
+		page.Entries = page.Entries[:limit]
+
+		last := page.Entries[limit-1]
+		id, err := primitive.ObjectIDFromHex(last.ID)
+		if err != nil {
+			return nil, err
+		}
+		page.NextCursor = encodeCursor(pageCursor{CreatedAt: last.CreatedAt, ID: id, Ascending: query.Ascending})
+	}
+
+	return page, nil
+}
+
+// FindOne returns the log entry with the given id, or ErrNotFound
+func (s *MongoStore) FindOne(ctx context.Context, id string) (*LogEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	docID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	collection := s.collection()
+
+	var entry LogEntry
+	err = collection.FindOne(ctx, bson.M{"_id": docID}).Decode(&entry)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// Stats counts the entries matching a query with an aggregation
+func (s *MongoStore) Stats(ctx context.Context, query StatsQuery) ([]StatsRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	cursor, err := s.collection().Aggregate(ctx, query.pipeline(), options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rows := []StatsRow{}
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID struct {
+				Group string    `bson:"group"`
+				Time  time.Time `bson:"time"`
+			} `bson:"_id"`
+			Count int64 `bson:"count"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+
+		row := StatsRow{Group: doc.ID.Group, Count: doc.Count}
+		if query.Bucket != "" {
+			t := doc.ID.Time.UTC()
+			row.Time = &t
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, cursor.Err()
+}
+
+// TopMessages returns the most frequent messages of the entries matching
+// filter with an aggregation
+func (s *MongoStore) TopMessages(ctx context.Context, filter LogFilter, n int) ([]MessageCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	cursor, err := s.collection().Aggregate(ctx, topPipeline(filter, n), options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	top := []MessageCount{}
+	for cursor.Next(ctx) {
+		var doc struct {
+			Message  string    `bson:"_id"`
+			Count    int64     `bson:"count"`
+			LastSeen time.Time `bson:"last_seen"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		top = append(top, MessageCount{Message: doc.Message, Count: doc.Count, LastSeen: doc.LastSeen.UTC()})
+	}
+
+	return top, cursor.Err()
+}
+
+// Import writes entries as they were exported, with their ids and times.
+// Entries whose id is already stored fail with a duplicate key error, which
+// is not one.
+func (s *MongoStore) Import(ctx context.Context, entries []LogEntry) (int64, error) {
+	imported, err := prepareImport(entries)
+	if err != nil || len(imported) == 0 {
+		return 0, err
+	}
+
+	docs := make([]interface{}, len(imported))
+	for i, entry := range imported {
+		if docs[i], err = importDocument(entry); err != nil {
+			return 0, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	_, err = s.collection().InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+
+	var bulkErr mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bulkErr) {
+		return 0, err
+	}
+
+	// Only the entries without a write error were imported
+	count := int64(len(docs) - len(bulkErr.WriteErrors))
+	if bulkErr.WriteConcernError != nil {
+		return count, err
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != duplicateKey {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// importDocument returns entry as stored, with its id as an object id like
+// the ids Mongo gives
+func importDocument(entry *LogEntry) (bson.D, error) {
+	id, err := primitive.ObjectIDFromHex(entry.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := *entry
+	doc.ID = ""
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields bson.D
+	if err := bson.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	return append(bson.D{{Key: "_id", Value: id}}, fields...), nil
+}
+
+// Delete deletes the entries with the given ids
+func (s *MongoStore) Delete(ctx context.Context, ids []string) (int64, error) {
+	docIDs := make(bson.A, 0, len(ids))
+	for _, id := range ids {
+		docID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			// No entry has it
+			continue
+		}
+		docIDs = append(docIDs, docID)
+	}
+	if len(docIDs) == 0 {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, purgeTimeout)
+	defer cancel()
+
+	result, err := s.collection().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": docIDs}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// Purge deletes the entries matching a rule
+func (s *MongoStore) Purge(ctx context.Context, rule PurgeRule) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, purgeTimeout)
+	defer cancel()
+
+	result, err := s.collection().DeleteMany(ctx, rule.bson())
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// RetentionPolicies returns the retention policies, none until some are set
+func (s *MongoStore) RetentionPolicies(ctx context.Context) ([]RetentionPolicy, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	var doc struct {
+		Policies []RetentionPolicy `bson:"policies"`
+	}
+	err := s.settings().FindOne(ctx, bson.M{"_id": retentionID}).Decode(&doc)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	if doc.Policies == nil {
+		return []RetentionPolicy{}, nil
+	}
+	return doc.Policies, nil
+}
+
+// SetRetentionPolicies replaces the retention policies
+func (s *MongoStore) SetRetentionPolicies(ctx context.Context, policies []RetentionPolicy) error {
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	if policies == nil {
+		policies = []RetentionPolicy{}
+	}
+
+	_, err := s.settings().ReplaceOne(ctx,
+		bson.M{"_id": retentionID},
+		bson.M{"_id": retentionID, "policies": policies},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// Ping checks that Mongo answers
+func (s *MongoStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx, nil)
+}
+
+// Close disconnects from Mongo
+func (s *MongoStore) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
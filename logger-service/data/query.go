@@ -0,0 +1,132 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// queryTimeout bounds every query made for the log API
+	queryTimeout = 10 * time.Second
+
+	// DefaultPageSize and MaxPageSize bound how many entries one page of a
+	// query holds
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// ErrInvalidCursor is returned for a cursor that was not issued for the query
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrNotFound is returned when no log entry has the requested id
+var ErrNotFound = errors.New("log entry not found")
+
+// LogFilter narrows down a log query. Zero fields do not filter.
+type LogFilter struct {
+	Name     string
+	From     time.Time
+	To       time.Time
+	Search   string
+	Severity []string
+	Source   string
+	TraceID  string
+}
+
+// LogQuery is one page of a log query. Entries are sorted by creation time,
+// newest first unless Ascending is set, and Cursor is the NextCursor of the
+// previous page.
+type LogQuery struct {
+	Filter    LogFilter
+	Ascending bool
+	Limit     int
+	Cursor    string
+}
+
+// LogPage is one page of log entries. NextCursor is empty on the last page.
+type LogPage struct {
+	Entries    []*LogEntry `json:"entries"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// pageCursor is the position after the last entry of a page. Entries are
+// ordered by creation time and then id, which is unique.
+type pageCursor struct {
+	CreatedAt time.Time          `json:"t"`
+	ID        primitive.ObjectID `json:"id"`
+	Ascending bool               `json:"asc"`
+}
+
+// limit returns the page size of the query, within bounds
+func (q LogQuery) limit() int {
+	if q.Limit <= 0 {
+		return DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return q.Limit
+}
+
+// bson returns the Mongo filter selecting the entries that match f
+func (f LogFilter) bson() bson.D {
+	filter := bson.D{}
+
+	if f.Name != "" {
+		filter = append(filter, bson.E{Key: "name", Value: f.Name})
+	}
+
+	if !f.From.IsZero() || !f.To.IsZero() {
+		createdAt := bson.M{}
+		if !f.From.IsZero() {
+			createdAt["$gte"] = f.From
+		}
+		if !f.To.IsZero() {
+			createdAt["$lt"] = f.To
+		}
+		filter = append(filter, bson.E{Key: "created_at", Value: createdAt})
+	}
+
+	if len(f.Severity) == 1 {
+		filter = append(filter, bson.E{Key: "severity", Value: f.Severity[0]})
+	} else if len(f.Severity) > 1 {
+		filter = append(filter, bson.E{Key: "severity", Value: bson.M{"$in": f.Severity}})
+	}
+
+	if f.Source != "" {
+		filter = append(filter, bson.E{Key: "source", Value: f.Source})
+	}
+
+	if f.TraceID != "" {
+		filter = append(filter, bson.E{Key: "trace_id", Value: f.TraceID})
+	}
+
+	// Matched against the text index on data, by whole words
+	if f.Search != "" {
+		filter = append(filter, bson.E{Key: "$text", Value: bson.M{"$search": f.Search}})
+	}
+
+	return filter
+}
+
+// encodeCursor and decodeCursor turn a page position into an opaque string
+// and back
+func encodeCursor(c pageCursor) string {
+	out, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+func decodeCursor(value string) (pageCursor, error) {
+	var c pageCursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(raw, &c)
+	return c, err
+}
@@ -0,0 +1,204 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// MaxRetentionDays bounds how long a policy may keep entries
+const MaxRetentionDays = 3650
+
+// ErrInvalidPolicy is returned for retention policies that cannot be used
+var ErrInvalidPolicy = errors.New("invalid retention policy")
+
+// policyID is what a policy id may look like, as it labels metrics
+var policyID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// RetentionPolicy deletes the log entries it matches once they are older than
+// Days. A policy matches on the log name, the severity, both, or neither to
+// cover every entry. When several policies match an entry the most specific
+// one applies: name and severity, then name, then severity, then neither.
+type RetentionPolicy struct {
+	ID       string `bson:"id" json:"id"`
+	LogName  string `bson:"log_name,omitempty" json:"log_name,omitempty"`
+	Severity string `bson:"severity,omitempty" json:"severity,omitempty"`
+	Days     int    `bson:"days" json:"days"`
+}
+
+// EntryMatch selects log entries by name and severity. Empty fields match
+// every entry.
+type EntryMatch struct {
+	Name     string
+	Severity string
+}
+
+// PurgeRule selects the entries a policy deletes: those matching Match,
+// created before Before and matching none of Except, which are left to more
+// specific policies
+type PurgeRule struct {
+	Match  EntryMatch
+	Before time.Time
+	Except []EntryMatch
+}
+
+// PurgeResult is how many entries one policy deleted
+type PurgeResult struct {
+	Policy  string `json:"policy"`
+	Deleted int64  `json:"deleted"`
+}
+
+// Normalize checks a policy and lower cases its severity
+func (p *RetentionPolicy) Normalize() error {
+	if !policyID.MatchString(p.ID) {
+		return fmt.Errorf("%w: id %q must be lower case letters, digits, - and _", ErrInvalidPolicy, p.ID)
+	}
+
+	if p.Severity != "" {
+		severity, ok := severities[strings.ToLower(strings.TrimSpace(p.Severity))]
+		if !ok {
+			return fmt.Errorf("%w: unknown severity %q", ErrInvalidPolicy, p.Severity)
+		}
+		p.Severity = severity
+	}
+
+	if p.Days < 1 || p.Days > MaxRetentionDays {
+		return fmt.Errorf("%w: days must be between 1 and %d", ErrInvalidPolicy, MaxRetentionDays)
+	}
+
+	return nil
+}
+
+// NormalizePolicies checks a whole set of policies: each of them, and that no
+// two share an id or match the same entries
+func NormalizePolicies(policies []RetentionPolicy) error {
+	ids := map[string]bool{}
+	matches := map[EntryMatch]string{}
+
+	for i := range policies {
+		p := &policies[i]
+		if err := p.Normalize(); err != nil {
+			return err
+		}
+
+		if ids[p.ID] {
+			return fmt.Errorf("%w: id %q is used twice", ErrInvalidPolicy, p.ID)
+		}
+		ids[p.ID] = true
+
+		if other, ok := matches[p.match()]; ok {
+			return fmt.Errorf("%w: %q and %q match the same entries", ErrInvalidPolicy, other, p.ID)
+		}
+		matches[p.match()] = p.ID
+	}
+
+	return nil
+}
+
+func (p RetentionPolicy) match() EntryMatch {
+	return EntryMatch{Name: p.LogName, Severity: p.Severity}
+}
+
+// rank orders policies from the least to the most specific
+func (p RetentionPolicy) rank() int {
+	rank := 0
+	if p.LogName != "" {
+		rank += 2
+	}
+	if p.Severity != "" {
+		rank++
+	}
+	return rank
+}
+
+// Rule returns the entries p deletes at now, leaving out those a more
+// specific policy of the set applies to
+func (p RetentionPolicy) Rule(policies []RetentionPolicy, now time.Time) PurgeRule {
+	rule := PurgeRule{
+		Match:  p.match(),
+		Before: now.AddDate(0, 0, -p.Days),
+	}
+
+	for _, other := range policies {
+		if other.rank() <= p.rank() {
+			continue
+		}
+		// A more specific policy that never matches the same entries is no exception
+		if p.LogName != "" && other.LogName != "" && p.LogName != other.LogName {
+			continue
+		}
+		if p.Severity != "" && other.Severity != "" && p.Severity != other.Severity {
+			continue
+		}
+		rule.Except = append(rule.Except, other.match())
+	}
+
+	return rule
+}
+
+// PurgeExpired applies every retention policy of a store. Every policy is
+// applied even when another fails; the results list what each deleted.
+func PurgeExpired(ctx context.Context, store LogStore, now time.Time) ([]PurgeResult, error) {
+	policies, err := store.RetentionPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]PurgeResult, 0, len(policies))
+	var errs []error
+	for _, p := range policies {
+		deleted, err := store.Purge(ctx, p.Rule(policies, now))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("policy %s: %w", p.ID, err))
+		}
+		results = append(results, PurgeResult{Policy: p.ID, Deleted: deleted})
+	}
+
+	return results, errors.Join(errs...)
+}
+
+func (m EntryMatch) matches(entry *LogEntry) bool {
+	return (m.Name == "" || m.Name == entry.Name) && (m.Severity == "" || m.Severity == entry.Severity)
+}
+
+func (m EntryMatch) bson() bson.M {
+	filter := bson.M{}
+	if m.Name != "" {
+		filter["name"] = m.Name
+	}
+	if m.Severity != "" {
+		filter["severity"] = m.Severity
+	}
+	return filter
+}
+
+func (r PurgeRule) matches(entry *LogEntry) bool {
+	if !r.Match.matches(entry) || !entry.CreatedAt.Before(r.Before) {
+		return false
+	}
+	for _, except := range r.Except {
+		if except.matches(entry) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r PurgeRule) bson() bson.M {
+	filter := r.Match.bson()
+	filter["created_at"] = bson.M{"$lt": r.Before}
+
+	if len(r.Except) > 0 {
+		except := make(bson.A, len(r.Except))
+		for i, m := range r.Except {
+			except[i] = m.bson()
+		}
+		filter["$nor"] = except
+	}
+	return filter
+}
@@ -0,0 +1,285 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Fields log statistics can be grouped by
+const (
+	GroupByName     = "name"
+	GroupBySeverity = "severity"
+	GroupBySource   = "source"
+)
+
+// Time buckets log statistics can be counted in
+const (
+	BucketMinute = "minute"
+	BucketHour   = "hour"
+	BucketDay    = "day"
+)
+
+const (
+	// MaxBuckets bounds how many time buckets one stats query may span
+	MaxBuckets = 1440
+
+	// DefaultStatsWindow is how far back statistics go without a From
+	DefaultStatsWindow = 24 * time.Hour
+
+	// DefaultTopMessages and MaxTopMessages bound the top messages view
+	DefaultTopMessages = 10
+	MaxTopMessages     = 100
+)
+
+// ErrInvalidStats is returned for stats queries that cannot be answered
+var ErrInvalidStats = errors.New("invalid stats query")
+
+// bucketSizes is the length of every time bucket
+var bucketSizes = map[string]time.Duration{
+	BucketMinute: time.Minute,
+	BucketHour:   time.Hour,
+	BucketDay:    24 * time.Hour,
+}
+
+// StatsQuery counts the entries matching Filter, by the value of the GroupBy
+// field and by Bucket of creation time. Either may be left empty to count all
+// entries together.
+type StatsQuery struct {
+	Filter  LogFilter
+	GroupBy string
+	Bucket  string
+}
+
+// StatsRow is the count of one group in one time bucket. Time is the start
+// of the bucket, in UTC. Entries without the grouped field count in the
+// empty group.
+type StatsRow struct {
+	Time  *time.Time `json:"time,omitempty"`
+	Group string     `json:"group,omitempty"`
+	Count int64      `json:"count"`
+}
+
+// MessageCount is how often one message was logged, and when last
+type MessageCount struct {
+	Message  string    `json:"message"`
+	Count    int64     `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Prepare checks a stats query and bounds its time range: without a From it
+// covers the DefaultStatsWindow before To, or before now, and it may not
+// span more than MaxBuckets buckets.
+func (q *StatsQuery) Prepare(now time.Time) error {
+	switch q.GroupBy {
+	case "", GroupByName, GroupBySeverity, GroupBySource:
+	default:
+		return fmt.Errorf("%w: group_by must be name, severity or source", ErrInvalidStats)
+	}
+
+	size, ok := bucketSizes[q.Bucket]
+	if !ok && q.Bucket != "" {
+		return fmt.Errorf("%w: bucket must be minute, hour or day", ErrInvalidStats)
+	}
+
+	q.Filter.boundWindow(now)
+
+	end := q.Filter.To
+	if end.IsZero() {
+		end = now
+	}
+	if size > 0 && end.Sub(q.Filter.From) > MaxBuckets*size {
+		return fmt.Errorf("%w: more than %d %s buckets, narrow from and to", ErrInvalidStats, MaxBuckets, q.Bucket)
+	}
+
+	return nil
+}
+
+// boundWindow starts a filter without a From DefaultStatsWindow before its
+// To, or before now
+func (f *LogFilter) boundWindow(now time.Time) {
+	if !f.From.IsZero() {
+		return
+	}
+	if f.To.IsZero() {
+		f.From = now.Add(-DefaultStatsWindow)
+	} else {
+		f.From = f.To.Add(-DefaultStatsWindow)
+	}
+}
+
+// PrepareTop checks the size of a top messages view and bounds the time
+// range of its filter like Prepare does
+func PrepareTop(filter *LogFilter, n int, now time.Time) (int, error) {
+	if n == 0 {
+		n = DefaultTopMessages
+	}
+	if n < 1 || n > MaxTopMessages {
+		return 0, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidStats, MaxTopMessages)
+	}
+
+	filter.boundWindow(now)
+	return n, nil
+}
+
+// bucketStart returns the start of the bucket holding t, in UTC
+func bucketStart(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	switch bucket {
+	case BucketDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	default:
+		return t.Truncate(bucketSizes[bucket])
+	}
+}
+
+// groupValue returns the field of entry a stats query groups by
+func groupValue(entry *LogEntry, groupBy string) string {
+	switch groupBy {
+	case GroupByName:
+		return entry.Name
+	case GroupBySeverity:
+		return entry.Severity
+	case GroupBySource:
+		return entry.Source
+	}
+	return ""
+}
+
+// statsIn counts entries held in memory the way MongoStore.Stats does
+func statsIn(entries []*LogEntry, query StatsQuery) []StatsRow {
+	type key struct {
+		time  time.Time
+		group string
+	}
+
+	counts := map[key]int64{}
+	for _, entry := range entries {
+		if !query.Filter.Matches(entry) {
+			continue
+		}
+
+		k := key{group: groupValue(entry, query.GroupBy)}
+		if query.Bucket != "" {
+			k.time = bucketStart(entry.CreatedAt, query.Bucket)
+		}
+		counts[k]++
+	}
+
+	rows := make([]StatsRow, 0, len(counts))
+	for k, count := range counts {
+		row := StatsRow{Group: k.group, Count: count}
+		if query.Bucket != "" {
+			t := k.time
+			row.Time = &t
+		}
+		rows = append(rows, row)
+	}
+
+	sortStats(rows)
+	return rows
+}
+
+// sortStats orders rows by time, then by count, most first, then by group
+func sortStats(rows []StatsRow) {
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Time != nil && b.Time != nil && !a.Time.Equal(*b.Time) {
+			return a.Time.Before(*b.Time)
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Group < b.Group
+	})
+}
+
+// topIn returns the most frequent messages of entries held in memory the way
+// MongoStore.TopMessages does
+func topIn(entries []*LogEntry, filter LogFilter, n int) []MessageCount {
+	byMessage := map[string]*MessageCount{}
+	for _, entry := range entries {
+		if !filter.Matches(entry) {
+			continue
+		}
+
+		count, ok := byMessage[entry.Data]
+		if !ok {
+			count = &MessageCount{Message: entry.Data}
+			byMessage[entry.Data] = count
+		}
+		count.Count++
+		if entry.CreatedAt.After(count.LastSeen) {
+			count.LastSeen = entry.CreatedAt
+		}
+	}
+
+	top := make([]MessageCount, 0, len(byMessage))
+	for _, count := range byMessage {
+		top = append(top, *count)
+	}
+	sortTop(top)
+
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// sortTop orders messages by count, most first, then by message
+func sortTop(top []MessageCount) {
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Message < top[j].Message
+	})
+}
+
+// pipeline returns the Mongo aggregation answering the query. Buckets are
+// built with $dateFromParts rather than $dateTrunc, which Mongo 4.2 lacks.
+func (q StatsQuery) pipeline() bson.A {
+	id := bson.M{}
+	if q.GroupBy != "" {
+		// Missing and null fields make one empty group, as in memory
+		id["group"] = bson.M{"$ifNull": bson.A{"$" + q.GroupBy, ""}}
+	}
+	if q.Bucket != "" {
+		parts := bson.M{
+			"year":  bson.M{"$year": "$created_at"},
+			"month": bson.M{"$month": "$created_at"},
+			"day":   bson.M{"$dayOfMonth": "$created_at"},
+		}
+		if q.Bucket != BucketDay {
+			parts["hour"] = bson.M{"$hour": "$created_at"}
+		}
+		if q.Bucket == BucketMinute {
+			parts["minute"] = bson.M{"$minute": "$created_at"}
+		}
+		id["time"] = bson.M{"$dateFromParts": parts}
+	}
+
+	return bson.A{
+		bson.M{"$match": q.Filter.bson()},
+		bson.M{"$group": bson.M{"_id": id, "count": bson.M{"$sum": 1}}},
+		bson.M{"$sort": bson.D{{Key: "_id.time", Value: 1}, {Key: "count", Value: -1}, {Key: "_id.group", Value: 1}}},
+	}
+}
+
+// topPipeline returns the Mongo aggregation finding the n most frequent
+// messages of the entries matching filter
+func topPipeline(filter LogFilter, n int) bson.A {
+	return bson.A{
+		bson.M{"$match": filter.bson()},
+		bson.M{"$group": bson.M{
+			"_id":       "$data",
+			"count":     bson.M{"$sum": 1},
+			"last_seen": bson.M{"$max": "$created_at"},
+		}},
+		bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+		bson.M{"$limit": n},
+	}
+}
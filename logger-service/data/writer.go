@@ -0,0 +1,229 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// ErrBufferFull is returned when entries arrive faster than the store
+	// takes them and the buffer has no room left
+	ErrBufferFull = errors.New("log buffer is full")
+
+	// ErrWriterClosed is returned for entries written after Close
+	ErrWriterClosed = errors.New("log writer is closed")
+)
+
+// WriterOptions tune a BufferedWriter. Zero values take the defaults.
+type WriterOptions struct {
+	// BatchSize flushes as soon as this many entries wait, 500 by default
+	BatchSize int
+
+	// FlushInterval flushes the waiting entries at least this often, every
+	// second by default
+	FlushInterval time.Duration
+
+	// Capacity is how many entries may wait before writes are refused with
+	// ErrBufferFull, 10000 by default
+	Capacity int
+
+	// OnFlush, if set, is called after every flush with the number of
+	// entries written and the error of the store, if any
+	OnFlush func(written int, err error)
+}
+
+// BufferedWriter gathers log entries and writes them to a store in batches,
+// when enough of them wait or the flush interval is over. Writes return as
+// soon as the entry is buffered, or once it is stored with WriteSync.
+type BufferedWriter struct {
+	store LogStore
+	opts  WriterOptions
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan pendingEntry
+	done   chan struct{}
+}
+
+// pendingEntry is an entry waiting to be flushed. ack, if set, receives the
+// outcome of the flush.
+type pendingEntry struct {
+	entry LogEntry
+	ack   chan error
+}
+
+// NewBufferedWriter starts a writer flushing to store. Close it to flush the
+// entries still waiting.
+func NewBufferedWriter(store LogStore, opts WriterOptions) *BufferedWriter {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.Capacity <= 0 {
+		opts.Capacity = 10000
+	}
+
+	w := &BufferedWriter{
+		store: store,
+		opts:  opts,
+		queue: make(chan pendingEntry, opts.Capacity),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+
+	return w
+}
+
+// Write buffers an entry. Invalid entries are refused right away, with an
+// error wrapping ErrInvalidEntry, so that a batch never fails for one of
+// them.
+func (w *BufferedWriter) Write(entry LogEntry) error {
+	return w.enqueue(entry, nil)
+}
+
+// WriteSync buffers an entry and waits until it is flushed, for callers that
+// need it stored before going on. When ctx ends first the entry stays
+// buffered and may still be written.
+func (w *BufferedWriter) WriteSync(ctx context.Context, entry LogEntry) error {
+	ack := make(chan error, 1)
+	if err := w.enqueue(entry, ack); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-ack:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *BufferedWriter) enqueue(entry LogEntry, ack chan error) error {
+	if err := entry.Normalize(); err != nil {
+		return err
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	select {
+	case w.queue <- pendingEntry{entry: entry, ack: ack}:
+		return nil
+	default:
+		return ErrBufferFull
+	}
+}
+
+// Len returns how many entries wait to be flushed
+func (w *BufferedWriter) Len() int {
+	return len(w.queue)
+}
+
+// Close refuses new entries and flushes those waiting. It returns early if
+// ctx ends first, leaving the flush going on.
+func (w *BufferedWriter) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run gathers entries into batches until the queue is closed and drained
+func (w *BufferedWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]pendingEntry, 0, w.opts.BatchSize)
+	for {
+		select {
+		case pending, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, pending)
+			if len(batch) < w.opts.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		w.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// flush writes a batch and tells the waiting writers how it went
+func (w *BufferedWriter) flush(batch []pendingEntry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	entries := make([]LogEntry, len(batch))
+	for i, pending := range batch {
+		entries[i] = pending.entry
+	}
+
+	// Not bound to any request: the writers may be long gone
+	written, err := w.store.InsertMany(context.Background(), entries)
+
+	if w.opts.OnFlush != nil {
+		w.opts.OnFlush(len(written), err)
+	}
+
+	failed := failedEntries(err)
+	for i, pending := range batch {
+		if pending.ack == nil {
+			continue
+		}
+		if failed(i) {
+			pending.ack <- err
+		} else {
+			pending.ack <- nil
+		}
+	}
+}
+
+// failedEntries tells which entries of a batch were not written when
+// InsertMany returned err. Only Mongo writes part of a batch, and says which
+// entries failed.
+func failedEntries(err error) func(i int) bool {
+	if err == nil {
+		return func(int) bool { return false }
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return func(int) bool { return true }
+	}
+
+	failed := make(map[int]bool, len(bulkErr.WriteErrors))
+	for _, writeErr := range bulkErr.WriteErrors {
+		failed[writeErr.Index] = true
+	}
+	return func(i int) bool { return failed[i] }
+}
@@ -0,0 +1,487 @@
+// Package archive moves old log entries out of the store into gzipped NDJSON
+// segment files, one directory per day, listed in a manifest, and imports
+// them back on demand.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log-service/data"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ManifestName is the file listing the segments of an archive directory
+const ManifestName = "manifest.json"
+
+// DefaultSegmentSize bounds how many entries one segment holds
+const DefaultSegmentSize = 100000
+
+// Segment is one archive file. From and To are the creation times of its
+// first and last entries; every entry of a segment was created the same day,
+// in UTC.
+type Segment struct {
+	File       string    `json:"file"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Entries    int       `json:"entries"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// Manifest lists the segments of an archive, oldest archived first
+type Manifest struct {
+	Segments []Segment `json:"segments"`
+}
+
+// Result is what one archive run did
+type Result struct {
+	Segments int   `json:"segments"`
+	Archived int64 `json:"archived"`
+	Deleted  int64 `json:"deleted"`
+}
+
+// Options tune an archive. Zero values take the defaults.
+type Options struct {
+	// SegmentSize starts a new segment once one holds this many entries,
+	// DefaultSegmentSize by default
+	SegmentSize int
+}
+
+// Archive is a directory of segment files. Segments are only listed in the
+// manifest once written and read back, and entries are only deleted from the
+// store once listed, so that a crash at any point loses nothing: at worst
+// entries are archived twice, and imported once.
+type Archive struct {
+	dir  string
+	opts Options
+
+	// run lets one Move go at a time, mu guards the manifest
+	run      sync.Mutex
+	mu       sync.RWMutex
+	manifest Manifest
+}
+
+// Open opens the archive in dir, creating the directory if needed
+func Open(dir string, opts Options) (*Archive, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	a := &Archive{dir: dir, opts: opts}
+
+	raw, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &a.manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", ManifestName, err)
+	}
+
+	return a, nil
+}
+
+// Segments returns the segments holding entries created in [from, to). Zero
+// times do not bound the range.
+func (a *Archive) Segments(from, to time.Time) []Segment {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	segments := []Segment{}
+	for _, segment := range a.manifest.Segments {
+		if !from.IsZero() && segment.To.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !segment.From.Before(to) {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool { return segments[i].From.Before(segments[j].From) })
+	return segments
+}
+
+// Move archives the entries of store created before the given time, oldest
+// first, and deletes them from the store. Entries updated since then, such
+// as those restored lately, are left alone until they are that old too.
+func (a *Archive) Move(ctx context.Context, store data.LogStore, before time.Time) (Result, error) {
+	a.run.Lock()
+	defer a.run.Unlock()
+
+	var result Result
+	var segment *segmentWriter
+
+	// finish lists the open segment and deletes its entries from the store
+	finish := func() error {
+		if segment == nil {
+			return nil
+		}
+		written, err := segment.close()
+		segment = nil
+		if err != nil {
+			return err
+		}
+
+		if err := a.add(written.Segment); err != nil {
+			return err
+		}
+		result.Segments++
+		result.Archived += int64(written.Entries)
+
+		deleted, err := store.Delete(ctx, written.ids)
+		result.Deleted += deleted
+		return err
+	}
+
+	query := data.LogQuery{Filter: data.LogFilter{To: before}, Ascending: true, Limit: data.MaxPageSize}
+	for {
+		page, err := store.Find(ctx, query)
+		if err != nil {
+			segment.abort()
+			return result, err
+		}
+
+		for _, entry := range page.Entries {
+			if !entry.UpdatedAt.Before(before) {
+				continue
+			}
+
+			day := dayOf(entry.CreatedAt)
+			if segment != nil && (!segment.day.Equal(day) || segment.Entries == a.opts.SegmentSize) {
+				if err := finish(); err != nil {
+					return result, err
+				}
+			}
+			if segment == nil {
+				if segment, err = a.create(entry); err != nil {
+					return result, err
+				}
+			}
+
+			if err := segment.write(entry); err != nil {
+				segment.abort()
+				return result, err
+			}
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	return result, finish()
+}
+
+// Restore imports the archived entries created in [from, to) back into store
+// and returns how many it wrote. Entries the store still holds are skipped.
+// Restored entries are marked as updated at now, so that Move leaves them in
+// the store for as long as it leaves new entries.
+func (a *Archive) Restore(ctx context.Context, store data.LogStore, from, to, now time.Time) (int64, error) {
+	var restored int64
+
+	for _, segment := range a.Segments(from, to) {
+		batch := make([]data.LogEntry, 0, data.MaxPageSize)
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			written, err := store.Import(ctx, batch)
+			restored += written
+			batch = batch[:0]
+			return err
+		}
+
+		err := a.read(segment, func(entry *data.LogEntry) error {
+			if !from.IsZero() && entry.CreatedAt.Before(from) || !to.IsZero() && !entry.CreatedAt.Before(to) {
+				return nil
+			}
+			entry.UpdatedAt = now
+			batch = append(batch, *entry)
+			if len(batch) < cap(batch) {
+				return nil
+			}
+			return flush()
+		})
+		if err == nil {
+			err = flush()
+		}
+		if err != nil {
+			return restored, fmt.Errorf("%s: %w", segment.File, err)
+		}
+	}
+
+	return restored, nil
+}
+
+// add lists a segment in the manifest
+func (a *Archive) add(segment Segment) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	manifest := Manifest{Segments: append(append([]Segment{}, a.manifest.Segments...), segment)}
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(a.dir, ManifestName), raw); err != nil {
+		return err
+	}
+
+	a.manifest = manifest
+	return nil
+}
+
+// listed reports whether the manifest lists the segment file name
+func (a *Archive) listed(name string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for _, segment := range a.manifest.Segments {
+		if segment.File == filepath.ToSlash(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// read calls fn with every entry of a segment, in order, and then checks
+// that the file is the one that was archived
+func (a *Archive) read(segment Segment, fn func(*data.LogEntry) error) error {
+	file, err := os.Open(filepath.Join(a.dir, segment.File))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	sum := sha256.New()
+	tee := io.TeeReader(file, sum)
+	zr, err := gzip.NewReader(tee)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	reader := bufio.NewReader(zr)
+	for {
+		raw, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(raw) == 0 {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		entry, err := decodeEntry(raw)
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", count+1, err)
+		}
+		count++
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	// Drain what gzip left unread so that the whole file is hashed
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return err
+	}
+	if count != segment.Entries {
+		return fmt.Errorf("holds %d entries, the manifest says %d", count, segment.Entries)
+	}
+	if hex.EncodeToString(sum.Sum(nil)) != segment.SHA256 {
+		return errors.New("checksum does not match the manifest")
+	}
+	return nil
+}
+
+// decodeEntry reads one archived entry. Numbers are decoded as they were
+// written and normalized again, so that attributes keep their types.
+func decodeEntry(raw []byte) (*data.LogEntry, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var entry data.LogEntry
+	if err := decoder.Decode(&entry); err != nil {
+		return nil, err
+	}
+	if err := entry.Normalize(); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// dayOf returns the UTC day holding t
+func dayOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// segmentWriter writes one segment to a temporary file until it is closed
+type segmentWriter struct {
+	Segment
+	archive *Archive
+	day     time.Time
+	path    string
+	file    *os.File
+	sum     hash.Hash
+	gzip    *gzip.Writer
+	encoder *json.Encoder
+	ids     []string
+}
+
+// create starts the segment holding first, in the directory of its day. The
+// file is named after the entry, so that archiving the same entries again
+// replaces a segment left unlisted by a crash; a listed one is kept.
+func (a *Archive) create(first *data.LogEntry) (*segmentWriter, error) {
+	created := first.CreatedAt.UTC()
+	base := filepath.Join(created.Format("2006/01/02"), fmt.Sprintf("logs-%s-%s", created.Format("150405.000"), first.ID))
+	name := base + ".ndjson.gz"
+	for n := 2; a.listed(name); n++ {
+		name = fmt.Sprintf("%s-%d.ndjson.gz", base, n)
+	}
+	path := filepath.Join(a.dir, name)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &segmentWriter{
+		Segment: Segment{File: filepath.ToSlash(name), From: first.CreatedAt},
+		archive: a,
+		day:     dayOf(first.CreatedAt),
+		path:    path,
+		file:    file,
+		sum:     sha256.New(),
+	}
+	s.gzip = gzip.NewWriter(io.MultiWriter(file, s.sum))
+	s.encoder = json.NewEncoder(s.gzip)
+	return s, nil
+}
+
+func (s *segmentWriter) write(entry *data.LogEntry) error {
+	if err := s.encoder.Encode(entry); err != nil {
+		return err
+	}
+	s.To = entry.CreatedAt
+	s.Entries++
+	s.ids = append(s.ids, entry.ID)
+	return nil
+}
+
+// close writes the segment out, reads it back to check it holds the entries
+// written, and moves it in place
+func (s *segmentWriter) close() (*segmentWriter, error) {
+	err := s.gzip.Close()
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(s.file.Name())
+		return nil, err
+	}
+
+	info, err := os.Stat(s.file.Name())
+	if err != nil {
+		return nil, err
+	}
+	s.Size = info.Size()
+	s.SHA256 = hex.EncodeToString(s.sum.Sum(nil))
+	s.ArchivedAt = time.Now().UTC()
+
+	// Read back from the temporary file, under the name it is listed with
+	check := s.Segment
+	check.File = filepath.ToSlash(filepath.Join(filepath.Dir(s.File), filepath.Base(s.file.Name())))
+	i := 0
+	err = s.archive.read(check, func(entry *data.LogEntry) error {
+		if entry.ID != s.ids[i] {
+			return fmt.Errorf("entry %d is %s, %s was written", i+1, entry.ID, s.ids[i])
+		}
+		i++
+		return nil
+	})
+	if err == nil {
+		err = os.Rename(s.file.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(s.file.Name())
+		return nil, fmt.Errorf("%s: %w", s.File, err)
+	}
+
+	return s, syncDir(filepath.Dir(s.path))
+}
+
+// abort drops a segment that will not be finished
+func (s *segmentWriter) abort() {
+	if s == nil {
+		return
+	}
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// writeFileAtomic replaces the file at path with content, so that a crash
+// leaves either the old content or the new one
+func writeFileAtomic(path string, content []byte) error {
+	tmp := path + ".tmp"
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes the files renamed into dir survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
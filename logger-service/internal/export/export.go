@@ -0,0 +1,174 @@
+// Package export writes log entries to NDJSON and CSV files, optionally
+// gzip compressed, for the export endpoint and the "loggerApp export"
+// command alike.
+package export
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log-service/data"
+	"strings"
+	"time"
+)
+
+// Format is a file format log entries are exported to
+type Format string
+
+const (
+	NDJSON Format = "ndjson"
+	CSV    Format = "csv"
+)
+
+// columns of CSV files. Attributes are one JSON object.
+var columns = []string{"id", "created_at", "name", "severity", "source", "host", "trace_id", "span_id", "data", "attributes"}
+
+// ParseFormat accepts a format name or a file extension
+func ParseFormat(value string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(value, ".")) {
+	case "ndjson", "jsonl":
+		return NDJSON, nil
+	case "csv":
+		return CSV, nil
+	default:
+		return "", fmt.Errorf("unsupported format %q, use ndjson or csv", value)
+	}
+}
+
+// ContentType returns the media type files of the format are served as
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// Options choose what is exported and how
+type Options struct {
+	Format Format
+	Gzip   bool
+	Filter data.LogFilter
+}
+
+// FileName returns the name an export is saved as
+func (o Options) FileName() string {
+	name := "logs." + string(o.Format)
+	if o.Gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+// Export writes the entries matching the filter to w, oldest first, and
+// returns how many it wrote. Entries are read one page at a time, so that
+// exports of any size take little memory. NDJSON exports hold entries as the
+// file store keeps them.
+func Export(ctx context.Context, store data.LogStore, w io.Writer, opts Options) (int, error) {
+	var zw *gzip.Writer
+	if opts.Gzip {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+
+	writer := newWriter(w, opts.Format)
+
+	count := 0
+	query := data.LogQuery{Filter: opts.Filter, Ascending: true, Limit: data.MaxPageSize}
+	for {
+		page, err := store.Find(ctx, query)
+		if err != nil {
+			return count, err
+		}
+
+		for _, entry := range page.Entries {
+			if err := writer.write(entry); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if err := writer.flush(); err != nil {
+			return count, err
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	if zw != nil {
+		return count, zw.Close()
+	}
+	return count, nil
+}
+
+// entryWriter writes entries in one format
+type entryWriter struct {
+	format Format
+	json   *json.Encoder
+	csv    *csv.Writer
+	header bool
+}
+
+func newWriter(w io.Writer, format Format) *entryWriter {
+	if format == CSV {
+		return &entryWriter{format: format, csv: csv.NewWriter(w)}
+	}
+	return &entryWriter{format: format, json: json.NewEncoder(w)}
+}
+
+func (e *entryWriter) write(entry *data.LogEntry) error {
+	if e.format != CSV {
+		return e.json.Encode(entry)
+	}
+
+	if !e.header {
+		if err := e.csv.Write(columns); err != nil {
+			return err
+		}
+		e.header = true
+	}
+
+	attributes := ""
+	if len(entry.Attributes) > 0 {
+		raw, err := json.Marshal(entry.Attributes)
+		if err != nil {
+			return err
+		}
+		attributes = string(raw)
+	}
+
+	return e.csv.Write([]string{
+		entry.ID,
+		entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+		entry.Name,
+		entry.Severity,
+		entry.Source,
+		entry.Host,
+		entry.TraceID,
+		entry.SpanID,
+		entry.Data,
+		attributes,
+	})
+}
+
+// flush writes out what the writer buffers. An empty CSV export still gets
+// its header.
+func (e *entryWriter) flush() error {
+	if e.format != CSV {
+		return nil
+	}
+
+	if !e.header {
+		if err := e.csv.Write(columns); err != nil {
+			return err
+		}
+		e.header = true
+	}
+
+	e.csv.Flush()
+	return e.csv.Error()
+}
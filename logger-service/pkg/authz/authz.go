@@ -0,0 +1,183 @@
+// Package authz holds the access token format issued by authentication-service
+// together with chi compatible middleware to enforce permissions from it.
+//
+// It only depends on the standard library and the JWT package, so the other Go
+// services in this repository use it to protect their own routes:
+//
+//	mux.Use(authz.Verifier(secret))
+//	mux.With(authz.RequirePermission("logs:read")).Get("/logs", app.ListLogs)
+//
+// Each service is built on its own, so like the gRPC stubs the services keep a
+// copy of this file in their pkg/authz. Change it in authentication-service and
+// copy it over.
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Issuer is the iss claim of every token issued by authentication-service
+const Issuer = "authentication-service"
+
+// ErrInvalidToken is returned when a token cannot be parsed, has a bad
+// signature or has expired.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Claims are the claims carried by an access token. Permissions are resolved
+// from the roles when the token is issued, so services enforcing them never
+// need to talk to the users database.
+type Claims struct {
+	Email       string   `json:"email,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+
+	// ClientID is set on tokens issued to OAuth2 clients rather than users
+	ClientID string `json:"client_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// HasPermission reports whether the claims grant a permission. A granted
+// permission of "*" matches everything and "logs:*" matches every permission
+// starting with "logs:".
+func (c *Claims) HasPermission(permission string) bool {
+	for _, granted := range c.Permissions {
+		if granted == "*" || granted == permission {
+			return true
+		}
+		if strings.HasSuffix(granted, ":*") && strings.HasPrefix(permission, strings.TrimSuffix(granted, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the claims include a role
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign issues an HS256 signed token for the claims, valid for ttl
+func Sign(secret []byte, claims Claims, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims.Issuer = Issuer
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// Parse validates a signed token and returns its claims. Without a secret
+// every token is refused, since anyone could sign one with an empty key.
+func Parse(secret []byte, token string) (*Claims, error) {
+	if len(secret) == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (any, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(Issuer))
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the claims
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns the claims stored by Verifier, if any
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*Claims)
+	return claims, ok
+}
+
+// Verifier is a middleware which looks for a bearer token in the Authorization
+// header and, when it is valid, stores its claims in the request context. It
+// does not reject requests by itself; use RequireAuth or RequirePermission for
+// that.
+func Verifier(secret []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if ok {
+				if claims, err := Parse(secret, token); err == nil {
+					r = r.WithContext(NewContext(r.Context(), claims))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAuth rejects requests without valid claims with 401 Unauthorized
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := FromContext(r.Context()); !ok {
+			writeError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission rejects requests whose claims do not grant every one of
+// the given permissions, with 401 when there are no claims at all and 403 when
+// a permission is missing.
+func RequirePermission(permissions ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := FromContext(r.Context())
+			if !ok {
+				writeError(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+
+			for _, permission := range permissions {
+				if !claims.HasPermission(permission) {
+					writeError(w, http.StatusForbidden, "missing permission "+permission)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
+}
+
+// writeError sends the same JSON error shape the services in this repo use
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":   true,
+		"message": message,
+	})
+}
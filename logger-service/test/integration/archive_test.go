@@ -0,0 +1,268 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"log-service/api"
+	"log-service/data"
+	"log-service/internal/archive"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// deleteStore fails deletes while err is set
+type deleteStore struct {
+	*data.MemoryStore
+	err error
+}
+
+func (s *deleteStore) Delete(ctx context.Context, ids []string) (int64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.MemoryStore.Delete(ctx, ids)
+}
+
+// importOld stores entries created at the given times and returns their ids
+func importOld(t *testing.T, store data.LogStore, times ...time.Time) []string {
+	t.Helper()
+
+	ids := make([]string, len(times))
+	entries := make([]data.LogEntry, len(times))
+	for i, created := range times {
+		ids[i] = primitive.NewObjectID().Hex()
+		entries[i] = data.LogEntry{ID: ids[i], Name: "checkout", Data: "old", CreatedAt: created, UpdatedAt: created}
+	}
+
+	_, err := store.Import(context.Background(), entries)
+	require.NoError(t, err)
+	return ids
+}
+
+func TestArchiveMoveAndRestore(t *testing.T) {
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	dir := t.TempDir()
+
+	day1 := time.Date(2024, 6, 29, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, 6, 30, 10, 0, 0, 0, time.UTC)
+	ids := importOld(t, store, day1, day1.Add(time.Hour), day1.Add(2*time.Hour), day2, day2.Add(time.Hour))
+	recent, err := store.Insert(ctx, data.LogEntry{Name: "checkout", Data: "new"})
+	require.NoError(t, err)
+
+	logs, err := archive.Open(dir, archive.Options{SegmentSize: 2})
+	require.NoError(t, err)
+
+	before := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	result, err := logs.Move(ctx, store, before)
+	require.NoError(t, err)
+	assert.Equal(t, archive.Result{Segments: 3, Archived: 5, Deleted: 5}, result)
+
+	page, err := store.Find(ctx, data.LogQuery{})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, recent.ID, page.Entries[0].ID)
+
+	// Segments are split by day and by size, and listed in the manifest
+	segments := logs.Segments(time.Time{}, time.Time{})
+	require.Len(t, segments, 3)
+	assert.Equal(t, []int{2, 1, 2}, []int{segments[0].Entries, segments[1].Entries, segments[2].Entries})
+	assert.True(t, strings.HasPrefix(segments[0].File, "2024/06/29/"), segments[0].File)
+	assert.True(t, strings.HasPrefix(segments[2].File, "2024/06/30/"), segments[2].File)
+	assert.True(t, day1.Add(2*time.Hour).Equal(segments[1].From))
+	assert.FileExists(t, filepath.Join(dir, archive.ManifestName))
+
+	logs, err = archive.Open(dir, archive.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, segments, logs.Segments(time.Time{}, time.Time{}))
+	assert.Len(t, logs.Segments(day2, day2.Add(time.Minute)), 1)
+
+	// Only the entries of the range come back, as they were
+	now := time.Now()
+	restored, err := logs.Restore(ctx, store, day1.Add(time.Hour), day2, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), restored)
+
+	found, err := store.FindOne(ctx, ids[1])
+	require.NoError(t, err)
+	assert.True(t, day1.Add(time.Hour).Equal(found.CreatedAt))
+	_, err = store.FindOne(ctx, ids[0])
+	assert.ErrorIs(t, err, data.ErrNotFound)
+
+	restored, err = logs.Restore(ctx, store, day1.Add(time.Hour), day2, now)
+	require.NoError(t, err)
+	assert.Zero(t, restored, "restored entries are not imported twice")
+
+	// Restored entries stay in the store until they are old again
+	result, err = logs.Move(ctx, store, before)
+	require.NoError(t, err)
+	assert.Zero(t, result.Segments)
+	_, err = store.FindOne(ctx, ids[1])
+	assert.NoError(t, err)
+}
+
+func TestArchiveKeepsEntriesWhenDeleteFails(t *testing.T) {
+	ctx := context.Background()
+	store := &deleteStore{MemoryStore: data.NewMemoryStore(), err: errors.New("store is down")}
+	created := time.Date(2024, 6, 30, 10, 0, 0, 0, time.UTC)
+	ids := importOld(t, store, created, created.Add(time.Minute))
+
+	logs, err := archive.Open(t.TempDir(), archive.Options{})
+	require.NoError(t, err)
+
+	before := created.Add(24 * time.Hour)
+	result, err := logs.Move(ctx, store, before)
+	assert.ErrorIs(t, err, store.err)
+	assert.Equal(t, 1, result.Segments)
+	_, err = store.FindOne(ctx, ids[0])
+	require.NoError(t, err, "entries stay until they are deleted")
+
+	// The next run archives them again, next to the first segment
+	store.err = nil
+	result, err = logs.Move(ctx, store, before)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), result.Deleted)
+
+	segments := logs.Segments(time.Time{}, time.Time{})
+	require.Len(t, segments, 2)
+	assert.NotEqual(t, segments[0].File, segments[1].File)
+
+	restored, err := logs.Restore(ctx, store, time.Time{}, time.Time{}, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), restored)
+}
+
+func TestArchiveRefusesDamagedSegment(t *testing.T) {
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	created := time.Date(2024, 6, 30, 10, 0, 0, 0, time.UTC)
+	importOld(t, store, created)
+
+	dir := t.TempDir()
+	logs, err := archive.Open(dir, archive.Options{})
+	require.NoError(t, err)
+	_, err = logs.Move(ctx, store, created.Add(time.Hour))
+	require.NoError(t, err)
+
+	segment := logs.Segments(time.Time{}, time.Time{})[0]
+	file, err := os.OpenFile(filepath.Join(dir, segment.File), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString("garbage")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	_, err = logs.Restore(ctx, store, time.Time{}, time.Time{}, time.Now())
+	assert.ErrorContains(t, err, segment.File)
+}
+
+func TestRunArchiver(t *testing.T) {
+	api.InitLogger()
+	store := data.NewMemoryStore()
+	app := &api.Config{Models: data.NewModels(store), Logger: api.Log}
+	importOld(t, store, time.Now().AddDate(0, 0, -40))
+	_, err := store.Insert(context.Background(), data.LogEntry{Name: "checkout", Data: "new"})
+	require.NoError(t, err)
+
+	logs, err := archive.Open(t.TempDir(), archive.Options{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		app.RunArchiver(ctx, logs, 30*24*time.Hour, time.Hour)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return len(logs.Segments(time.Time{}, time.Time{})) == 1 }, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+
+	page, err := store.Find(context.Background(), data.LogQuery{})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, "new", page.Entries[0].Data)
+}
+
+func TestMongoImport(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("skips stored entries", func(mt *mtest.T) {
+		store := data.NewMongoStore(mt.Client)
+		created := time.Date(2024, 6, 30, 10, 0, 0, 0, time.UTC)
+		id := primitive.NewObjectID()
+
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 1, Code: 11000, Message: "duplicate key"}))
+		imported, err := store.Import(context.Background(), []data.LogEntry{
+			{ID: id.Hex(), Name: "auth", CreatedAt: created},
+			{ID: primitive.NewObjectID().Hex(), Name: "auth", CreatedAt: created},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), imported)
+
+		docs, _ := mt.GetStartedEvent().Command.Lookup("documents").Array().Values()
+		require.Len(t, docs, 2)
+		assert.Equal(t, id, docs[0].Document().Lookup("_id").ObjectID())
+		assert.Equal(t, created, docs[0].Document().Lookup("created_at").Time().UTC())
+	})
+
+	mt.Run("restore twice", func(mt *mtest.T) {
+		ctx := context.Background()
+		day := time.Date(2024, 6, 30, 10, 0, 0, 0, time.UTC)
+
+		memory := data.NewMemoryStore()
+		importOld(t, memory, day, day.Add(time.Hour))
+		logs, err := archive.Open(t.TempDir(), archive.Options{})
+		require.NoError(t, err)
+		_, err = logs.Move(ctx, memory, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+
+		store := data.NewMongoStore(mt.Client)
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		restored, err := logs.Restore(ctx, store, time.Time{}, time.Time{}, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), restored)
+
+		// The second time every entry is already stored
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(
+			mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key"},
+			mtest.WriteError{Index: 1, Code: 11000, Message: "duplicate key"},
+		))
+		restored, err = logs.Restore(ctx, store, time.Time{}, time.Time{}, time.Now())
+		require.NoError(t, err)
+		assert.Zero(t, restored)
+	})
+
+	mt.Run("other failures", func(mt *mtest.T) {
+		store := data.NewMongoStore(mt.Client)
+
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 121, Message: "validation failed"}))
+		_, err := store.Import(context.Background(), []data.LogEntry{
+			{ID: primitive.NewObjectID().Hex(), Name: "auth", CreatedAt: time.Now()},
+		})
+		assert.Error(t, err)
+	})
+
+	mt.Run("delete", func(mt *mtest.T) {
+		store := data.NewMongoStore(mt.Client)
+		id := primitive.NewObjectID()
+
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}})
+		deleted, err := store.Delete(context.Background(), []string{id.Hex(), "unknown"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+
+		ids, _ := mt.GetStartedEvent().Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q", "_id", "$in").Array().Values()
+		require.Len(t, ids, 1)
+		assert.Equal(t, id, ids[0].ObjectID())
+	})
+}
@@ -0,0 +1,82 @@
+package integration
+
+import (
+	"log-service/api"
+	"log-service/data"
+	"log-service/pkg/authz"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readerSecret signs the access tokens the tests read logs with
+var readerSecret = []byte("reader-test-secret")
+
+// signToken returns an access token granting permissions
+func signToken(t *testing.T, permissions ...string) string {
+	t.Helper()
+
+	token, err := authz.Sign(readerSecret, authz.Claims{Email: "reader@example.com", Permissions: permissions}, time.Minute)
+	require.NoError(t, err)
+	return token
+}
+
+// readerHeader returns the Authorization header of a caller with logs:read
+func readerHeader(t *testing.T) http.Header {
+	return http.Header{"Authorization": {"Bearer " + signToken(t, "logs:read")}}
+}
+
+// readRequest returns a GET request for target from a caller with logs:read
+func readRequest(t *testing.T, target string) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	require.NoError(t, err)
+	req.Header = readerHeader(t)
+	return req
+}
+
+func TestReadRoutesRequirePermission(t *testing.T) {
+	api.InitLogger()
+	app := &api.Config{Models: data.NewModels(data.NewMemoryStore()), Logger: api.Log, JWTSecret: readerSecret}
+	routes := app.Routes()
+
+	for _, target := range []string{"/logs", "/logs/66801d8c0000000000000000", "/logs/export", "/logs/stats", "/logs/stats/top", "/logs/tail?from=bad"} {
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "%s without a token", target)
+
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer "+signToken(t, "mail:send"))
+		rec = httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code, "%s without logs:read", target)
+
+		req = httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer "+signToken(t, "logs:*"))
+		rec = httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		assert.NotContains(t, []int{http.StatusUnauthorized, http.StatusForbidden}, rec.Code, "%s with logs:*", target)
+	}
+
+	// Writing logs stays open to the services that send them
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/log", strings.NewReader(`{"name":"auth","data":"login"}`)))
+	assert.Less(t, rec.Code, http.StatusBadRequest)
+}
+
+func TestReadRoutesWithoutSecret(t *testing.T) {
+	api.InitLogger()
+	app := &api.Config{Models: data.NewModels(data.NewMemoryStore()), Logger: api.Log}
+
+	req := httptest.NewRequest(http.MethodGet, "/logs", nil)
+	req.Header.Set("Authorization", "Bearer "+signToken(t, "logs:read"))
+	rec := httptest.NewRecorder()
+	app.Routes().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
@@ -0,0 +1,110 @@
+package integration
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log-service/api"
+	"log-service/data"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingStore fails every query
+type failingStore struct {
+	*data.MemoryStore
+}
+
+func (failingStore) Find(ctx context.Context, query data.LogQuery) (*data.LogPage, error) {
+	return nil, errors.New("store is down")
+}
+
+// exportLogs calls GET /logs/export
+func exportLogs(t *testing.T, app *api.Config, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	app.Routes().ServeHTTP(rec, readRequest(t, target))
+	return rec
+}
+
+func TestExportLogsOverManyPages(t *testing.T) {
+	api.InitLogger()
+	store := data.NewMemoryStore()
+	app := &api.Config{Models: data.NewModels(store), Logger: api.Log, JWTSecret: readerSecret}
+
+	// More than two pages of entries
+	batch := make([]data.LogEntry, 2*data.MaxPageSize+10)
+	for i := range batch {
+		batch[i] = data.LogEntry{Name: "checkout", Data: "entry"}
+	}
+	written, err := store.InsertMany(context.Background(), batch)
+	require.NoError(t, err)
+	_, err = store.Insert(context.Background(), data.LogEntry{Name: "login"})
+	require.NoError(t, err)
+
+	rec := exportLogs(t, app, "/logs/export?name=checkout")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="logs.ndjson"`, rec.Header().Get("Content-Disposition"))
+
+	var ids []string
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var entry data.LogEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		ids = append(ids, entry.ID)
+	}
+	require.Len(t, ids, len(batch))
+	assert.Equal(t, written[0].ID, ids[0], "oldest first")
+	assert.Equal(t, written[len(written)-1].ID, ids[len(ids)-1])
+}
+
+func TestExportLogsAsGzippedCSV(t *testing.T) {
+	api.InitLogger()
+	store := data.NewMemoryStore()
+	app := &api.Config{Models: data.NewModels(store), Logger: api.Log, JWTSecret: readerSecret}
+
+	_, err := store.InsertMany(context.Background(), []data.LogEntry{
+		{Name: "checkout", Data: "declined", Severity: "error"},
+		{Name: "checkout", Data: "paid"},
+	})
+	require.NoError(t, err)
+
+	rec := exportLogs(t, app, "/logs/export?format=csv&gzip=true&severity=error")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/gzip", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="logs.csv.gz"`, rec.Header().Get("Content-Disposition"))
+
+	zr, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	rows, err := csv.NewReader(zr).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "declined", rows[1][8])
+}
+
+func TestExportLogsErrors(t *testing.T) {
+	api.InitLogger()
+	app := &api.Config{Models: data.NewModels(data.NewMemoryStore()), Logger: api.Log, JWTSecret: readerSecret}
+
+	for _, target := range []string{
+		"/logs/export?format=xlsx",
+		"/logs/export?gzip=yes",
+		"/logs/export?from=yesterday",
+	} {
+		assert.Equal(t, http.StatusBadRequest, exportLogs(t, app, target).Code, target)
+	}
+
+	// Nothing has been sent yet when the first page fails
+	app = &api.Config{Models: data.NewModels(failingStore{data.NewMemoryStore()}), Logger: api.Log, JWTSecret: readerSecret}
+	rec := exportLogs(t, app, "/logs/export?format=csv")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Empty(t, rec.Header().Get("Content-Disposition"))
+	assert.Contains(t, rec.Body.String(), "unable to export logs")
+}
@@ -0,0 +1,279 @@
+package integration
+
+import (
+	"context"
+	"io"
+	"log-service/api"
+	"log-service/data"
+	"log-service/logs"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialLogService serves the log service over an in-memory connection and
+// returns a client for it
+func dialLogService(t *testing.T, models data.Models) logs.LogServiceClient {
+	t.Helper()
+
+	return dialLogServer(t, &api.LogServer{Models: models})
+}
+
+// dialLogServer serves a log server over an in-memory connection and returns
+// a client for it
+func dialLogServer(t *testing.T, logServer *api.LogServer) logs.LogServiceClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	logs.RegisterLogServiceServer(server, logServer)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return logs.NewLogServiceClient(conn)
+}
+
+func TestWriteLogBatch(t *testing.T) {
+	api.InitLogger()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("all written", func(mt *mtest.T) {
+		client := dialLogService(t, data.New(mt.Client))
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		summary, err := client.WriteLogBatch(context.Background(), &logs.LogBatchRequest{LogEntries: []*logs.Log{
+			{Name: "auth", Data: "login"},
+			{Name: "auth", Data: "logout"},
+		}})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), summary.Received)
+		assert.Equal(t, int64(2), summary.Written)
+		assert.Zero(t, summary.Failed)
+
+		insert := mt.GetStartedEvent().Command
+		docs, _ := insert.Lookup("documents").Array().Values()
+		assert.Len(t, docs, 2)
+		assert.False(t, insert.Lookup("ordered").Boolean())
+	})
+
+	mt.Run("some failed", func(mt *mtest.T) {
+		client := dialLogService(t, data.New(mt.Client))
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 1, Code: 11000, Message: "duplicate key"}))
+
+		summary, err := client.WriteLogBatch(context.Background(), &logs.LogBatchRequest{LogEntries: []*logs.Log{
+			{Name: "auth", Data: "one"},
+			{Name: "auth", Data: "two"},
+			{Name: "auth", Data: "three"},
+		}})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), summary.Written)
+		assert.Equal(t, int64(1), summary.Failed)
+		assert.Equal(t, "1 of 3 entries failed", summary.Result)
+	})
+
+	mt.Run("empty", func(mt *mtest.T) {
+		client := dialLogService(t, data.New(mt.Client))
+
+		_, err := client.WriteLogBatch(context.Background(), &logs.LogBatchRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestWriteLogsStream(t *testing.T) {
+	api.InitLogger()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("stream", func(mt *mtest.T) {
+		client := dialLogService(t, data.New(mt.Client))
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		stream, err := client.WriteLogs(context.Background())
+		require.NoError(t, err)
+		for _, message := range []string{"one", "two", "three"} {
+			require.NoError(t, stream.Send(&logs.LogRequest{LogEntry: &logs.Log{Name: "broker", Data: message}}))
+		}
+
+		summary, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), summary.Received)
+		assert.Equal(t, int64(3), summary.Written)
+		assert.Equal(t, "logged!", summary.Result)
+
+		// Fewer entries than a batch are written together when the stream ends
+		docs, _ := mt.GetStartedEvent().Command.Lookup("documents").Array().Values()
+		assert.Len(t, docs, 3)
+	})
+}
+
+func TestQueryLogsStream(t *testing.T) {
+	api.InitLogger()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("every page", func(mt *mtest.T) {
+		client := dialLogService(t, data.New(mt.Client))
+
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		var first []bson.D
+		for i := 0; i <= data.MaxPageSize; i++ {
+			first = append(first, logDoc(primitive.NewObjectID(), "payments", now.Add(-time.Duration(i)*time.Second)))
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "logs.logs", mtest.FirstBatch, first...),
+			mtest.CreateCursorResponse(0, "logs.logs", mtest.FirstBatch,
+				logDoc(primitive.NewObjectID(), "payments", now.Add(-time.Hour)),
+			),
+		)
+
+		stream, err := client.QueryLogs(context.Background(), &logs.QueryRequest{Name: "payments"})
+		require.NoError(t, err)
+
+		entries := receiveAll(t, stream)
+		assert.Len(t, entries, data.MaxPageSize+1)
+		assert.Equal(t, "payments", entries[0].Name)
+		assert.NotEmpty(t, entries[0].Id)
+		assert.Equal(t, now, entries[0].CreatedAt.AsTime())
+
+		find := mt.GetStartedEvent().Command
+		assert.Equal(t, "payments", find.Lookup("filter", "name").StringValue())
+		assert.Equal(t, int64(data.MaxPageSize+1), find.Lookup("limit").Int64())
+
+		// The second page continues after the last entry of the first
+		_, err = mt.GetStartedEvent().Command.LookupErr("filter", "$or")
+		assert.NoError(t, err)
+	})
+
+	mt.Run("limit", func(mt *mtest.T) {
+		client := dialLogService(t, data.New(mt.Client))
+
+		now := time.Now()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "logs.logs", mtest.FirstBatch,
+			logDoc(primitive.NewObjectID(), "auth", now),
+			logDoc(primitive.NewObjectID(), "auth", now.Add(-time.Second)),
+			logDoc(primitive.NewObjectID(), "auth", now.Add(-2*time.Second)),
+		))
+
+		stream, err := client.QueryLogs(context.Background(), &logs.QueryRequest{Limit: 2, Ascending: true})
+		require.NoError(t, err)
+		assert.Len(t, receiveAll(t, stream), 2)
+
+		find := mt.GetStartedEvent().Command
+		assert.Equal(t, int64(3), find.Lookup("limit").Int64())
+	})
+
+	mt.Run("source", func(mt *mtest.T) {
+		client := dialLogService(t, data.New(mt.Client))
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "logs.logs", mtest.FirstBatch))
+
+		stream, err := client.QueryLogs(context.Background(), &logs.QueryRequest{Source: "checkout-api"})
+		require.NoError(t, err)
+		assert.Empty(t, receiveAll(t, stream))
+
+		find := mt.GetStartedEvent().Command
+		assert.Equal(t, "checkout-api", find.Lookup("filter", "source").StringValue())
+	})
+
+	mt.Run("negative limit", func(mt *mtest.T) {
+		client := dialLogService(t, data.New(mt.Client))
+
+		stream, err := client.QueryLogs(context.Background(), &logs.QueryRequest{Limit: -1})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestTailLogs(t *testing.T) {
+	api.InitLogger()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("tail", func(mt *mtest.T) {
+		client := dialLogService(t, data.New(mt.Client))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stream, err := client.TailLogs(ctx, &logs.TailRequest{Name: "payments"})
+		require.NoError(t, err)
+
+		// Headers arrive once the server is subscribed
+		_, err = stream.Header()
+		require.NoError(t, err)
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		_, err = client.WriteLogBatch(ctx, &logs.LogBatchRequest{LogEntries: []*logs.Log{
+			{Name: "auth", Data: "not followed"},
+			{Name: "payments", Data: "card declined"},
+		}})
+		require.NoError(t, err)
+
+		entry, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, "payments", entry.Name)
+		assert.Equal(t, "card declined", entry.Data)
+		assert.NotEmpty(t, entry.Id)
+
+		cancel()
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Canceled, status.Code(err))
+	})
+
+	mt.Run("source", func(mt *mtest.T) {
+		client := dialLogService(t, data.New(mt.Client))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stream, err := client.TailLogs(ctx, &logs.TailRequest{Source: "checkout-api"})
+		require.NoError(t, err)
+		_, err = stream.Header()
+		require.NoError(t, err)
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		_, err = client.WriteLogBatch(ctx, &logs.LogBatchRequest{LogEntries: []*logs.Log{
+			{Name: "payments", Data: "other service", Source: "billing"},
+			{Name: "payments", Data: "card declined", Source: "checkout-api"},
+		}})
+		require.NoError(t, err)
+
+		entry, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, "card declined", entry.Data)
+		assert.Equal(t, "checkout-api", entry.Source)
+	})
+}
+
+// receiveAll reads a server stream to its end
+func receiveAll(t *testing.T, stream grpc.ServerStreamingClient[logs.Log]) []*logs.Log {
+	t.Helper()
+
+	var entries []*logs.Log
+	for {
+		entry, err := stream.Recv()
+		if err == io.EOF {
+			return entries
+		}
+		require.NoError(t, err)
+		entries = append(entries, entry)
+	}
+}
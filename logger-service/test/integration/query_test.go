@@ -0,0 +1,141 @@
+package integration
+
+import (
+	"encoding/json"
+	"log-service/api"
+	"log-service/data"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// logDoc returns a stored log entry as Mongo returns it
+func logDoc(id primitive.ObjectID, name string, createdAt time.Time) bson.D {
+	return bson.D{
+		{Key: "_id", Value: id},
+		{Key: "name", Value: name},
+		{Key: "data", Value: "payment failed"},
+		{Key: "severity", Value: "error"},
+		{Key: "created_at", Value: createdAt},
+		{Key: "updated_at", Value: createdAt},
+	}
+}
+
+// listLogs calls GET /logs and decodes the page
+func listLogs(t *testing.T, app *api.Config, target string) (int, data.LogPage) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	app.Routes().ServeHTTP(rec, readRequest(t, target))
+
+	var resp struct {
+		Data data.LogPage `json:"data"`
+	}
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	}
+	return rec.Code, resp.Data
+}
+
+func TestListLogsPagesWithCursor(t *testing.T) {
+	api.InitLogger()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("pages", func(mt *mtest.T) {
+		app := &api.Config{Models: data.New(mt.Client), Logger: api.Log, JWTSecret: readerSecret}
+
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		first, second, third := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+
+		// Asked for two, the service fetches three to see if there is more
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "logs.logs", mtest.FirstBatch,
+			logDoc(first, "payments", now),
+			logDoc(second, "payments", now.Add(-time.Minute)),
+			logDoc(third, "payments", now.Add(-2*time.Minute)),
+		))
+
+		status, page := listLogs(t, app, "/logs?name=payments&severity=error,WARNING&limit=2&from=2024-01-01T00:00:00Z")
+		require.Equal(t, http.StatusOK, status)
+		require.Len(t, page.Entries, 2)
+		assert.Equal(t, first.Hex(), page.Entries[0].ID)
+		assert.Equal(t, "error", page.Entries[0].Severity)
+		require.NotEmpty(t, page.NextCursor)
+
+		find := mt.GetStartedEvent().Command
+		assert.Equal(t, int64(3), find.Lookup("limit").Int64())
+		filter := find.Lookup("filter").Document()
+		assert.Equal(t, "payments", filter.Lookup("name").StringValue())
+		severities, _ := filter.Lookup("severity", "$in").Array().Values()
+		assert.Len(t, severities, 2)
+		assert.NotNil(t, filter.Lookup("created_at", "$gte"))
+
+		// The next page continues after the second entry
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "logs.logs", mtest.FirstBatch,
+			logDoc(third, "payments", now.Add(-2*time.Minute)),
+		))
+
+		status, page = listLogs(t, app, "/logs?name=payments&severity=error,WARNING&limit=2&from=2024-01-01T00:00:00Z&cursor="+page.NextCursor)
+		require.Equal(t, http.StatusOK, status)
+		require.Len(t, page.Entries, 1)
+		assert.Empty(t, page.NextCursor)
+
+		filter = mt.GetStartedEvent().Command.Lookup("filter").Document()
+		after, _ := filter.Lookup("$or").Array().Values()
+		require.Len(t, after, 2)
+		assert.Equal(t, second.Hex(), after[1].Document().Lookup("_id", "$lt").ObjectID().Hex())
+	})
+}
+
+func TestListLogsRejectsBadParameters(t *testing.T) {
+	api.InitLogger()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("bad parameters", func(mt *mtest.T) {
+		app := &api.Config{Models: data.New(mt.Client), Logger: api.Log, JWTSecret: readerSecret}
+
+		for _, target := range []string{
+			"/logs?limit=0",
+			"/logs?limit=501",
+			"/logs?sort=sideways",
+			"/logs?from=yesterday",
+			"/logs?from=2024-02-01T00:00:00Z&to=2024-01-01T00:00:00Z",
+			"/logs?cursor=garbage",
+		} {
+			status, _ := listLogs(t, app, target)
+			assert.Equal(t, http.StatusBadRequest, status, target)
+		}
+	})
+}
+
+func TestGetLog(t *testing.T) {
+	api.InitLogger()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("get", func(mt *mtest.T) {
+		app := &api.Config{Models: data.New(mt.Client), Logger: api.Log, JWTSecret: readerSecret}
+		id := primitive.NewObjectID()
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "logs.logs", mtest.FirstBatch, logDoc(id, "auth", time.Now())))
+
+		rec := httptest.NewRecorder()
+		app.Routes().ServeHTTP(rec, readRequest(t, "/logs/"+id.Hex()))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "logs.logs", mtest.FirstBatch))
+
+		rec = httptest.NewRecorder()
+		app.Routes().ServeHTTP(rec, readRequest(t, "/logs/"+primitive.NewObjectID().Hex()))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec = httptest.NewRecorder()
+		app.Routes().ServeHTTP(rec, readRequest(t, "/logs/not-an-id"))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
@@ -0,0 +1,225 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"log-service/api"
+	"log-service/data"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// adminRequest calls the admin API as a caller with logs:admin
+func adminRequest(t *testing.T, app *api.Config, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+signToken(t, "logs:admin"))
+	app.Routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRetentionAdminAPI(t *testing.T) {
+	api.InitLogger()
+	app := &api.Config{Models: data.NewModels(data.NewMemoryStore()), Logger: api.Log, JWTSecret: readerSecret}
+
+	rec := adminRequest(t, app, http.MethodGet, "/admin/retention", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"policies": []}`, dataOf(t, rec))
+
+	rec = adminRequest(t, app, http.MethodPut, "/admin/retention", `{"policies": [
+		{"id": "debug", "severity": "DEBUG", "days": 3},
+		{"id": "errors", "severity": "error", "days": 90}
+	]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = adminRequest(t, app, http.MethodGet, "/admin/retention", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"policies": [
+		{"id": "debug", "severity": "debug", "days": 3},
+		{"id": "errors", "severity": "error", "days": 90}
+	]}`, dataOf(t, rec))
+
+	// A refused set leaves the current one in place
+	rec = adminRequest(t, app, http.MethodPut, "/admin/retention", `{"policies": [{"id": "debug", "severity": "debug", "days": 0}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	policies, err := app.Models.LogEntry.RetentionPolicies(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, policies, 2)
+}
+
+func TestRetentionAdminAPIRequiresPermission(t *testing.T) {
+	api.InitLogger()
+	app := &api.Config{Models: data.NewModels(data.NewMemoryStore()), Logger: api.Log, JWTSecret: readerSecret}
+
+	for _, authorization := range []string{"", "Bearer wrong"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin/retention", nil)
+		req.Header.Set("Authorization", authorization)
+		app.Routes().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, authorization)
+	}
+
+	// Reading logs is not enough to manage retention
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/admin/retention", strings.NewReader(`{"policies": []}`))
+	req.Header.Set("Authorization", "Bearer "+signToken(t, "logs:read"))
+	app.Routes().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestPurgeNowAppliesPolicies(t *testing.T) {
+	api.InitLogger()
+	ctx := context.Background()
+	store := data.NewMemoryStore()
+	app := &api.Config{Models: data.NewModels(store), Logger: api.Log, JWTSecret: readerSecret}
+
+	_, err := store.InsertMany(ctx, []data.LogEntry{
+		{Name: "auth", Severity: "debug"},
+		{Name: "auth", Severity: "debug"},
+		{Name: "auth", Severity: "error"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, store.SetRetentionPolicies(ctx, []data.RetentionPolicy{
+		{ID: "purge-test-debug", Severity: "debug", Days: 3},
+		{ID: "purge-test-errors", Severity: "error", Days: 90},
+	}))
+
+	// Entries written now are not old enough
+	rec := adminRequest(t, app, http.MethodPost, "/admin/retention/purge", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[{"policy": "purge-test-debug", "deleted": 0}, {"policy": "purge-test-errors", "deleted": 0}]`, dataOf(t, rec))
+
+	// Four days on, the debug entries have expired
+	results, err := data.PurgeExpired(ctx, store, time.Now().AddDate(0, 0, 4))
+	require.NoError(t, err)
+	assert.Equal(t, []data.PurgeResult{{Policy: "purge-test-debug", Deleted: 2}, {Policy: "purge-test-errors", Deleted: 0}}, results)
+
+	page, err := store.Find(ctx, data.LogQuery{})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, "error", page.Entries[0].Severity)
+}
+
+func TestRunRetentionRecordsPurgedEntries(t *testing.T) {
+	api.InitLogger()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Entries written ten days ago, as a file store keeps them
+	path := filepath.Join(t.TempDir(), "logs.ndjson")
+	old := time.Now().AddDate(0, 0, -10).UTC().Format(time.RFC3339)
+	require.NoError(t, os.WriteFile(path, []byte(
+		`{"id":"64b7f0c2a1b2c3d4e5f60718","name":"auth","data":"old","severity":"debug","created_at":"`+old+`"}`+"\n"+
+			`{"id":"64b7f0c2a1b2c3d4e5f60719","name":"auth","data":"old","severity":"error","created_at":"`+old+`"}`+"\n",
+	), 0o644))
+
+	store, err := data.OpenFileStore(path)
+	require.NoError(t, err)
+	defer store.Close(ctx)
+
+	_, err = store.Insert(ctx, data.LogEntry{Name: "auth", Data: "new", Severity: "debug"})
+	require.NoError(t, err)
+	require.NoError(t, store.SetRetentionPolicies(ctx, []data.RetentionPolicy{{ID: "run-test", Severity: "debug", Days: 3}}))
+
+	app := &api.Config{Models: data.NewModels(store), Logger: api.Log}
+	before := purgedTotal(t, "run-test")
+
+	// The first run happens right away
+	go app.RunRetention(ctx, time.Hour)
+	require.Eventually(t, func() bool {
+		return purgedTotal(t, "run-test") == before+1
+	}, time.Second, 10*time.Millisecond)
+
+	page, err := store.Find(ctx, data.LogQuery{Ascending: true})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 2)
+	assert.Equal(t, "error", page.Entries[0].Severity)
+	assert.Equal(t, "new", page.Entries[1].Data)
+}
+
+func TestMongoPurgeFilter(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("delete", func(mt *mtest.T) {
+		store := data.NewMongoStore(mt.Client)
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 7}})
+
+		before := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		deleted, err := store.Purge(context.Background(), data.PurgeRule{
+			Match:  data.EntryMatch{Severity: "debug"},
+			Before: before,
+			Except: []data.EntryMatch{{Name: "audit"}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), deleted)
+
+		deletes, _ := mt.GetStartedEvent().Command.Lookup("deletes").Array().Values()
+		require.Len(t, deletes, 1)
+		query := deletes[0].Document().Lookup("q").Document()
+		assert.Equal(t, "debug", query.Lookup("severity").StringValue())
+		assert.Equal(t, before, query.Lookup("created_at", "$lt").Time().UTC())
+		assert.Equal(t, "audit", query.Lookup("$nor", "0", "name").StringValue())
+	})
+
+	mt.Run("policies", func(mt *mtest.T) {
+		store := data.NewMongoStore(mt.Client)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		err := store.SetRetentionPolicies(context.Background(), []data.RetentionPolicy{{ID: "debug", Severity: "debug", Days: 3}})
+		require.NoError(t, err)
+
+		command := mt.GetStartedEvent().Command
+		update := command.Lookup("updates", "0")
+		assert.Equal(t, "retention", update.Document().Lookup("q", "_id").StringValue())
+		assert.True(t, update.Document().Lookup("upsert").Boolean())
+		assert.Equal(t, "debug", update.Document().Lookup("u", "policies", "0", "id").StringValue())
+	})
+}
+
+// purgedTotal returns how many entries a policy deleted, as exported to
+// Prometheus
+func purgedTotal(t *testing.T, policy string) float64 {
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != "logger_service_retention_purged_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "policy" && label.GetValue() == policy {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// dataOf returns the data of a JSON response
+func dataOf(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var resp struct {
+		Data json.RawMessage `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	return string(resp.Data)
+}
@@ -0,0 +1,208 @@
+package integration
+
+import (
+	"context"
+	"io"
+	"log-service/api"
+	"log-service/data"
+	"log-service/logs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// insertedDoc returns the first document of the insert command Mongo was sent
+func insertedDoc(mt *mtest.T) bson.Raw {
+	docs, _ := mt.GetStartedEvent().Command.Lookup("documents").Array().Values()
+	require.NotEmpty(mt, docs)
+	return docs[0].Document()
+}
+
+// postLog calls POST /log with a JSON body
+func postLog(app *api.Config, body string) *httptest.ResponseRecorder {
+	return postLogTo(app, "/log", body)
+}
+
+// postLogTo posts a JSON body to target
+func postLogTo(app *api.Config, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	app.Routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWriteRichLogOverHTTP(t *testing.T) {
+	api.InitLogger()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("typed fields", func(mt *mtest.T) {
+		app := &api.Config{Models: data.New(mt.Client), Logger: api.Log}
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		rec := postLog(app, `{
+			"name": "checkout", "data": "payment declined", "severity": "WARN",
+			"source": "payment-service", "host": "pay-7f9c", "trace_id": "4bf92f3577b34da6", "span_id": "00f067aa0ba902b7",
+			"attributes": {"status": 402, "amount": 19.99, "retried": true, "card": {"brand": "visa"}, "tags": ["eu", "web"]}
+		}`)
+		require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+
+		doc := insertedDoc(mt)
+		assert.Equal(t, "warning", doc.Lookup("severity").StringValue())
+		assert.Equal(t, "payment-service", doc.Lookup("source").StringValue())
+		assert.Equal(t, "pay-7f9c", doc.Lookup("host").StringValue())
+		assert.Equal(t, "4bf92f3577b34da6", doc.Lookup("trace_id").StringValue())
+		assert.Equal(t, "00f067aa0ba902b7", doc.Lookup("span_id").StringValue())
+		assert.Equal(t, int64(402), doc.Lookup("attributes", "status").Int64())
+		assert.Equal(t, 19.99, doc.Lookup("attributes", "amount").Double())
+		assert.True(t, doc.Lookup("attributes", "retried").Boolean())
+		assert.Equal(t, "visa", doc.Lookup("attributes", "card", "brand").StringValue())
+		tags, _ := doc.Lookup("attributes", "tags").Array().Values()
+		assert.Len(t, tags, 2)
+	})
+
+	mt.Run("old clients", func(mt *mtest.T) {
+		app := &api.Config{Models: data.New(mt.Client), Logger: api.Log}
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		rec := postLog(app, `{"name": "auth", "data": "login"}`)
+		require.Equal(t, http.StatusAccepted, rec.Code)
+
+		doc := insertedDoc(mt)
+		assert.Equal(t, "auth", doc.Lookup("name").StringValue())
+		assert.Equal(t, "info", doc.Lookup("severity").StringValue())
+		_, err := doc.LookupErr("attributes")
+		assert.Error(t, err)
+	})
+
+	mt.Run("invalid", func(mt *mtest.T) {
+		app := &api.Config{Models: data.New(mt.Client), Logger: api.Log}
+
+		for _, body := range []string{
+			`{"name": "auth", "data": "login", "severity": "loud"}`,
+			`{"name": "auth", "data": "login", "attributes": {"http.status": 200}}`,
+		} {
+			rec := postLog(app, body)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+		}
+	})
+}
+
+func TestWriteRichLogOverRPC(t *testing.T) {
+	api.InitLogger()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("rpc", func(mt *mtest.T) {
+		server := &api.RPCServer{Config: &api.Config{Models: data.New(mt.Client), Logger: api.Log}}
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var resp string
+		err := server.LogInfo(api.RPCPayload{
+			Name:       "broker",
+			Data:       "queued",
+			Severity:   "debug",
+			Source:     "broker-service",
+			Attributes: map[string]any{"queue": "logs_topic", "depth": 12},
+		}, &resp)
+		require.NoError(t, err)
+
+		doc := insertedDoc(mt)
+		assert.Equal(t, "debug", doc.Lookup("severity").StringValue())
+		assert.Equal(t, "broker-service", doc.Lookup("source").StringValue())
+		assert.Equal(t, int64(12), doc.Lookup("attributes", "depth").Int64())
+	})
+}
+
+func TestWriteRichLogOverGRPC(t *testing.T) {
+	api.InitLogger()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("write", func(mt *mtest.T) {
+		client := dialLogService(t, data.New(mt.Client))
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		attributes, err := structpb.NewStruct(map[string]any{"status": 500, "path": "/pay"})
+		require.NoError(t, err)
+
+		_, err = client.WriteLog(context.Background(), &logs.LogRequest{LogEntry: &logs.Log{
+			Name:       "checkout",
+			Data:       "upstream failed",
+			Severity:   logs.Severity_SEVERITY_ERROR,
+			Source:     "payment-service",
+			Host:       "pay-1",
+			TraceId:    "trace",
+			SpanId:     "span",
+			Attributes: attributes,
+		}})
+		require.NoError(t, err)
+
+		doc := insertedDoc(mt)
+		assert.Equal(t, "error", doc.Lookup("severity").StringValue())
+		assert.Equal(t, "pay-1", doc.Lookup("host").StringValue())
+		assert.Equal(t, "span", doc.Lookup("span_id").StringValue())
+		assert.Equal(t, int64(500), doc.Lookup("attributes", "status").Int64())
+	})
+
+	mt.Run("old clients", func(mt *mtest.T) {
+		client := dialLogService(t, data.New(mt.Client))
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		_, err := client.WriteLog(context.Background(), &logs.LogRequest{LogEntry: &logs.Log{Name: "auth", Data: "login"}})
+		require.NoError(t, err)
+		assert.Equal(t, "info", insertedDoc(mt).Lookup("severity").StringValue())
+	})
+
+	mt.Run("invalid", func(mt *mtest.T) {
+		client := dialLogService(t, data.New(mt.Client))
+
+		_, err := client.WriteLog(context.Background(), &logs.LogRequest{LogEntry: &logs.Log{Name: "auth", Severity: logs.Severity(42)}})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		attributes, _ := structpb.NewStruct(map[string]any{"$set": 1})
+		_, err = client.WriteLogBatch(context.Background(), &logs.LogBatchRequest{LogEntries: []*logs.Log{
+			{Name: "auth"},
+			{Name: "auth", Attributes: attributes},
+		}})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "entry 1")
+	})
+
+	mt.Run("read", func(mt *mtest.T) {
+		client := dialLogService(t, data.New(mt.Client))
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "logs.logs", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "name", Value: "checkout"},
+			{Key: "data", Value: "upstream failed"},
+			{Key: "severity", Value: "fatal"},
+			{Key: "source", Value: "payment-service"},
+			{Key: "attributes", Value: bson.D{
+				{Key: "status", Value: int32(500)},
+				{Key: "tags", Value: bson.A{"eu", int64(2)}},
+			}},
+			{Key: "created_at", Value: time.Now()},
+		}))
+
+		stream, err := client.QueryLogs(context.Background(), &logs.QueryRequest{})
+		require.NoError(t, err)
+		entry, err := stream.Recv()
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.Equal(t, io.EOF, err)
+
+		assert.Equal(t, logs.Severity_SEVERITY_FATAL, entry.Severity)
+		assert.Equal(t, "payment-service", entry.Source)
+		assert.Equal(t, map[string]any{"status": float64(500), "tags": []any{"eu", float64(2)}}, entry.Attributes.AsMap())
+	})
+}
@@ -0,0 +1,124 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"log-service/api"
+	"log-service/data"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// getJSON calls a GET route and returns the status and the data of the answer
+func getJSON(t *testing.T, app *api.Config, target string) (int, map[string]any) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	app.Routes().ServeHTTP(rec, readRequest(t, target))
+
+	var resp struct {
+		Data map[string]any `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	return rec.Code, resp.Data
+}
+
+func TestLogStats(t *testing.T) {
+	api.InitLogger()
+	store := data.NewMemoryStore()
+	app := &api.Config{Models: data.NewModels(store), Logger: api.Log, JWTSecret: readerSecret}
+
+	_, err := store.InsertMany(context.Background(), []data.LogEntry{
+		{Name: "checkout", Data: "declined", Severity: "error", Source: "payment-service"},
+		{Name: "checkout", Data: "declined", Severity: "error", Source: "payment-service"},
+		{Name: "login", Data: "bad password", Severity: "error", Source: "authentication-service"},
+		{Name: "login", Data: "welcome", Severity: "info", Source: "authentication-service"},
+	})
+	require.NoError(t, err)
+
+	code, stats := getJSON(t, app, "/logs/stats?severity=error&group_by=source&bucket=hour")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(3), stats["total"])
+	assert.Equal(t, "source", stats["group_by"])
+	assert.NotContains(t, stats, "to")
+
+	rows := stats["rows"].([]any)
+	require.Len(t, rows, 2)
+	first := rows[0].(map[string]any)
+	assert.Equal(t, "payment-service", first["group"])
+	assert.Equal(t, float64(2), first["count"])
+	assert.NotEmpty(t, first["time"])
+
+	code, stats = getJSON(t, app, "/logs/stats/top?limit=1&name=checkout")
+	require.Equal(t, http.StatusOK, code)
+	messages := stats["messages"].([]any)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "declined", messages[0].(map[string]any)["message"])
+
+	for _, target := range []string{
+		"/logs/stats?group_by=host",
+		"/logs/stats?bucket=week",
+		"/logs/stats?bucket=minute&from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z",
+		"/logs/stats?from=yesterday",
+		"/logs/stats/top?limit=0",
+		"/logs/stats/top?limit=1000",
+	} {
+		code, _ := getJSON(t, app, target)
+		assert.Equal(t, http.StatusBadRequest, code, target)
+	}
+}
+
+func TestMongoStatsPipeline(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("stats", func(mt *mtest.T) {
+		store := data.NewMongoStore(mt.Client)
+		hour := time.Date(2024, 6, 30, 11, 0, 0, 0, time.UTC)
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "logs.logs", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: bson.D{{Key: "group", Value: "payment-service"}, {Key: "time", Value: hour}}}, {Key: "count", Value: int32(2)}},
+			bson.D{{Key: "_id", Value: bson.D{{Key: "group", Value: ""}, {Key: "time", Value: hour}}}, {Key: "count", Value: int32(1)}},
+		))
+
+		query := data.StatsQuery{GroupBy: data.GroupBySource, Bucket: data.BucketHour, Filter: data.LogFilter{Severity: []string{"error"}}}
+		require.NoError(t, query.Prepare(hour.Add(time.Hour)))
+
+		rows, err := store.Stats(context.Background(), query)
+		require.NoError(t, err)
+		assert.Equal(t, []data.StatsRow{
+			{Time: &hour, Group: "payment-service", Count: 2},
+			{Time: &hour, Count: 1},
+		}, rows)
+
+		pipeline := mt.GetStartedEvent().Command.Lookup("pipeline")
+		assert.Equal(t, "error", pipeline.Array().Index(0).Value().Document().Lookup("$match", "severity").StringValue())
+		group := pipeline.Array().Index(1).Value().Document().Lookup("$group", "_id")
+		_, err = group.Document().LookupErr("time", "$dateFromParts", "hour")
+		assert.NoError(t, err)
+		_, err = group.Document().LookupErr("time", "$dateFromParts", "minute")
+		assert.Error(t, err, "hours are not split in minutes")
+	})
+
+	mt.Run("top", func(mt *mtest.T) {
+		store := data.NewMongoStore(mt.Client)
+		lastSeen := time.Date(2024, 6, 30, 11, 59, 0, 0, time.UTC)
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "logs.logs", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: "declined"}, {Key: "count", Value: int32(3)}, {Key: "last_seen", Value: lastSeen}},
+		))
+
+		top, err := store.TopMessages(context.Background(), data.LogFilter{Name: "checkout"}, 5)
+		require.NoError(t, err)
+		assert.Equal(t, []data.MessageCount{{Message: "declined", Count: 3, LastSeen: lastSeen}}, top)
+
+		pipeline := mt.GetStartedEvent().Command.Lookup("pipeline")
+		assert.Equal(t, int32(5), pipeline.Array().Index(3).Value().Document().Lookup("$limit").Int32())
+	})
+}
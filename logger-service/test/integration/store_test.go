@@ -0,0 +1,603 @@
+package integration
+
+import (
+	"context"
+	"log-service/data"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// stores returns a constructor for an empty store of every kind. Mongo is
+// only included when TEST_MONGO_URL points at a throwaway server, since the
+// logs and settings collections are dropped.
+func stores(t *testing.T) map[string]func(t *testing.T) data.LogStore {
+	factories := map[string]func(t *testing.T) data.LogStore{
+		"memory": func(t *testing.T) data.LogStore {
+			return data.NewMemoryStore()
+		},
+		"file": func(t *testing.T) data.LogStore {
+			store, err := data.OpenFileStore(filepath.Join(t.TempDir(), "logs.ndjson"))
+			require.NoError(t, err)
+			t.Cleanup(func() { store.Close(context.Background()) })
+			return store
+		},
+	}
+
+	url := os.Getenv("TEST_MONGO_URL")
+	if url == "" {
+		t.Log("TEST_MONGO_URL is not set, skipping the Mongo store")
+		return factories
+	}
+
+	factories["mongo"] = func(t *testing.T) data.LogStore {
+		ctx := context.Background()
+
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(url))
+		require.NoError(t, err)
+		require.NoError(t, client.Database("logs").Collection("logs").Drop(ctx))
+		require.NoError(t, client.Database("logs").Collection("settings").Drop(ctx))
+
+		store := data.NewMongoStore(client)
+		require.NoError(t, store.CreateIndexes(ctx))
+		t.Cleanup(func() { store.Close(ctx) })
+		return store
+	}
+	return factories
+}
+
+// TestLogStoreConformance checks that every store behaves the same
+func TestLogStoreConformance(t *testing.T) {
+	for name, newStore := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			t.Run("insert and find one", func(t *testing.T) { testInsertAndFindOne(t, newStore(t)) })
+			t.Run("invalid entries", func(t *testing.T) { testInvalidEntries(t, newStore(t)) })
+			t.Run("insert many", func(t *testing.T) { testInsertMany(t, newStore(t)) })
+			t.Run("filters", func(t *testing.T) { testFilters(t, newStore(t)) })
+			t.Run("pages", func(t *testing.T) { testPages(t, newStore(t)) })
+			t.Run("attribute types", func(t *testing.T) { testAttributeTypes(t, newStore(t)) })
+			t.Run("stats", func(t *testing.T) { testStats(t, newStore(t)) })
+			t.Run("top messages", func(t *testing.T) { testTopMessages(t, newStore(t)) })
+			t.Run("purge", func(t *testing.T) { testPurge(t, newStore(t)) })
+			t.Run("import and delete", func(t *testing.T) { testImportAndDelete(t, newStore(t)) })
+			t.Run("retention policies", func(t *testing.T) { testRetentionPolicies(t, newStore(t)) })
+		})
+	}
+}
+
+func testInsertAndFindOne(t *testing.T, store data.LogStore) {
+	ctx := context.Background()
+	require.NoError(t, store.Ping(ctx))
+
+	before := time.Now().Add(-time.Second)
+	stored, err := store.Insert(ctx, data.LogEntry{
+		ID:       "ignored",
+		Name:     "auth",
+		Data:     "login",
+		Severity: "WARN",
+		Source:   "authentication-service",
+		TraceID:  "trace-1",
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, stored.ID)
+	assert.NotEqual(t, "ignored", stored.ID)
+	assert.Equal(t, "warning", stored.Severity)
+	assert.True(t, stored.CreatedAt.After(before))
+
+	found, err := store.FindOne(ctx, stored.ID)
+	require.NoError(t, err)
+	assert.Equal(t, stored.ID, found.ID)
+	assert.Equal(t, "auth", found.Name)
+	assert.Equal(t, "login", found.Data)
+	assert.Equal(t, "warning", found.Severity)
+	assert.Equal(t, "authentication-service", found.Source)
+	assert.Equal(t, "trace-1", found.TraceID)
+	assert.True(t, stored.CreatedAt.Equal(found.CreatedAt))
+
+	_, err = store.FindOne(ctx, "64b7f0c2a1b2c3d4e5f60718")
+	assert.ErrorIs(t, err, data.ErrNotFound)
+	_, err = store.FindOne(ctx, "not-an-id")
+	assert.ErrorIs(t, err, data.ErrNotFound)
+}
+
+func testInvalidEntries(t *testing.T, store data.LogStore) {
+	ctx := context.Background()
+
+	_, err := store.Insert(ctx, data.LogEntry{Name: "auth", Severity: "loud"})
+	assert.ErrorIs(t, err, data.ErrInvalidEntry)
+
+	written, err := store.InsertMany(ctx, []data.LogEntry{
+		{Name: "auth"},
+		{Name: "auth", Attributes: map[string]any{"a.b": 1}},
+	})
+	assert.ErrorIs(t, err, data.ErrInvalidEntry)
+	assert.Empty(t, written)
+
+	page, err := store.Find(ctx, data.LogQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, page.Entries, "nothing of a refused batch is stored")
+}
+
+func testInsertMany(t *testing.T, store data.LogStore) {
+	ctx := context.Background()
+
+	written, err := store.InsertMany(ctx, []data.LogEntry{
+		{Name: "broker", Data: "one"},
+		{Name: "broker", Data: "two"},
+		{Name: "broker", Data: "three"},
+	})
+	require.NoError(t, err)
+	require.Len(t, written, 3)
+
+	ids := map[string]bool{}
+	for _, entry := range written {
+		ids[entry.ID] = true
+	}
+	assert.Len(t, ids, 3)
+
+	page, err := store.Find(ctx, data.LogQuery{Filter: data.LogFilter{Name: "broker"}, Ascending: true})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 3)
+	assert.Equal(t, "one", page.Entries[0].Data)
+	assert.Equal(t, "three", page.Entries[2].Data)
+
+	written, err = store.InsertMany(ctx, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, written)
+}
+
+func testFilters(t *testing.T, store data.LogStore) {
+	ctx := context.Background()
+
+	first, err := store.InsertMany(ctx, []data.LogEntry{
+		{Name: "payments", Data: "card declined by issuer", Severity: "error", TraceID: "t1", Source: "payment-service"},
+		{Name: "payments", Data: "card accepted", Severity: "info", TraceID: "t2"},
+		{Name: "auth", Data: "login declined", Severity: "warning"},
+	})
+	require.NoError(t, err)
+
+	// Later by at least a millisecond, the precision of stored times
+	time.Sleep(5 * time.Millisecond)
+	last, err := store.Insert(ctx, data.LogEntry{Name: "auth", Data: "logout", Severity: "debug"})
+	require.NoError(t, err)
+
+	names := func(filter data.LogFilter) []string {
+		page, err := store.Find(ctx, data.LogQuery{Filter: filter, Ascending: true})
+		require.NoError(t, err)
+
+		var out []string
+		for _, entry := range page.Entries {
+			out = append(out, entry.Data)
+		}
+		return out
+	}
+
+	assert.Equal(t, []string{"card declined by issuer", "card accepted"}, names(data.LogFilter{Name: "payments"}))
+	assert.Equal(t, []string{"card declined by issuer", "login declined"}, names(data.LogFilter{Severity: []string{"error", "warning"}}))
+	assert.Equal(t, []string{"card accepted"}, names(data.LogFilter{TraceID: "t2"}))
+	assert.Equal(t, []string{"card declined by issuer"}, names(data.LogFilter{Source: "payment-service"}))
+	assert.Equal(t, []string{"card declined by issuer", "login declined"}, names(data.LogFilter{Search: "declined"}))
+	assert.Equal(t, []string{"logout"}, names(data.LogFilter{From: last.CreatedAt}))
+	assert.Len(t, names(data.LogFilter{To: last.CreatedAt}), 3)
+	assert.Empty(t, names(data.LogFilter{Name: "payments", Severity: []string{"debug"}}))
+	assert.Equal(t, first[2].ID, mustFind(t, store, data.LogFilter{Name: "auth", Severity: []string{"warning"}}).ID)
+}
+
+func testPages(t *testing.T, store data.LogStore) {
+	ctx := context.Background()
+
+	var batch []data.LogEntry
+	for _, message := range []string{"a", "b", "c", "d", "e"} {
+		batch = append(batch, data.LogEntry{Name: "pages", Data: message})
+	}
+	_, err := store.InsertMany(ctx, batch)
+	require.NoError(t, err)
+
+	collect := func(ascending bool) []string {
+		var out []string
+		query := data.LogQuery{Filter: data.LogFilter{Name: "pages"}, Ascending: ascending, Limit: 2}
+		for {
+			page, err := store.Find(ctx, query)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(page.Entries), 2)
+			for _, entry := range page.Entries {
+				out = append(out, entry.Data)
+			}
+			if page.NextCursor == "" {
+				return out
+			}
+			query.Cursor = page.NextCursor
+		}
+	}
+
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, collect(true))
+	assert.Equal(t, []string{"e", "d", "c", "b", "a"}, collect(false))
+
+	page, err := store.Find(ctx, data.LogQuery{Filter: data.LogFilter{Name: "pages"}, Limit: 2})
+	require.NoError(t, err)
+	_, err = store.Find(ctx, data.LogQuery{Filter: data.LogFilter{Name: "pages"}, Limit: 2, Ascending: true, Cursor: page.NextCursor})
+	assert.ErrorIs(t, err, data.ErrInvalidCursor)
+	_, err = store.Find(ctx, data.LogQuery{Cursor: "garbage"})
+	assert.ErrorIs(t, err, data.ErrInvalidCursor)
+}
+
+func testAttributeTypes(t *testing.T, store data.LogStore) {
+	ctx := context.Background()
+
+	stored, err := store.Insert(ctx, data.LogEntry{Name: "checkout", Attributes: map[string]any{
+		"status":  float64(502),
+		"amount":  19.99,
+		"retried": true,
+		"card":    map[string]any{"brand": "visa"},
+		"tags":    []any{"eu", "web"},
+	}})
+	require.NoError(t, err)
+
+	found, err := store.FindOne(ctx, stored.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(502), found.Attributes["status"])
+	assert.Equal(t, 19.99, found.Attributes["amount"])
+	assert.Equal(t, true, found.Attributes["retried"])
+	assert.Equal(t, "visa", found.Attributes["card"].(map[string]any)["brand"])
+	assert.Len(t, found.Attributes["tags"], 2)
+}
+
+func testStats(t *testing.T, store data.LogStore) {
+	ctx := context.Background()
+
+	written, err := store.InsertMany(ctx, []data.LogEntry{
+		{Name: "checkout", Data: "declined", Severity: "error", Source: "payment-service"},
+		{Name: "checkout", Data: "declined", Severity: "error", Source: "payment-service"},
+		{Name: "login", Data: "bad password", Severity: "error", Source: "authentication-service"},
+		{Name: "login", Data: "welcome", Severity: "info", Source: "authentication-service"},
+		{Name: "queue", Data: "depth", Severity: "debug"},
+	})
+	require.NoError(t, err)
+
+	stats := func(query data.StatsQuery) []data.StatsRow {
+		require.NoError(t, query.Prepare(time.Now()))
+		rows, err := store.Stats(ctx, query)
+		require.NoError(t, err)
+		return rows
+	}
+
+	assert.Equal(t, []data.StatsRow{{Count: 5}}, stats(data.StatsQuery{}))
+
+	assert.Equal(t, []data.StatsRow{
+		{Group: "payment-service", Count: 2},
+		{Group: "authentication-service", Count: 1},
+	}, stats(data.StatsQuery{GroupBy: data.GroupBySource, Filter: data.LogFilter{Severity: []string{"error"}}}))
+
+	assert.Equal(t, []data.StatsRow{
+		{Group: "authentication-service", Count: 2},
+		{Group: "payment-service", Count: 2},
+		{Group: "", Count: 1},
+	}, stats(data.StatsQuery{GroupBy: data.GroupBySource}))
+
+	// Written together, every entry falls in the same day
+	day := written[0].CreatedAt.UTC().Truncate(24 * time.Hour)
+	assert.Equal(t, []data.StatsRow{
+		{Time: &day, Group: "error", Count: 3},
+		{Time: &day, Group: "debug", Count: 1},
+		{Time: &day, Group: "info", Count: 1},
+	}, stats(data.StatsQuery{GroupBy: data.GroupBySeverity, Bucket: data.BucketDay}))
+
+	assert.Empty(t, stats(data.StatsQuery{GroupBy: data.GroupByName, Filter: data.LogFilter{Name: "billing"}}))
+	assert.Empty(t, stats(data.StatsQuery{Filter: data.LogFilter{From: time.Now().Add(time.Hour)}}))
+}
+
+func testTopMessages(t *testing.T, store data.LogStore) {
+	ctx := context.Background()
+
+	var batch []data.LogEntry
+	for message, times := range map[string]int{"declined": 3, "timeout": 2, "welcome": 1, "bye": 1} {
+		for i := 0; i < times; i++ {
+			batch = append(batch, data.LogEntry{Name: "checkout", Data: message})
+		}
+	}
+	written, err := store.InsertMany(ctx, batch)
+	require.NoError(t, err)
+
+	filter := data.LogFilter{Name: "checkout"}
+	n, err := data.PrepareTop(&filter, 3, time.Now())
+	require.NoError(t, err)
+
+	top, err := store.TopMessages(ctx, filter, n)
+	require.NoError(t, err)
+	require.Len(t, top, 3)
+	assert.Equal(t, "declined", top[0].Message)
+	assert.Equal(t, int64(3), top[0].Count)
+	assert.Equal(t, "timeout", top[1].Message)
+	assert.Equal(t, "bye", top[2].Message, "ties are broken by message")
+	assert.True(t, written[0].CreatedAt.Equal(top[0].LastSeen))
+}
+
+func testPurge(t *testing.T, store data.LogStore) {
+	ctx := context.Background()
+
+	written, err := store.InsertMany(ctx, []data.LogEntry{
+		{Name: "auth", Data: "a", Severity: "debug"},
+		{Name: "auth", Data: "b", Severity: "error"},
+		{Name: "audit", Data: "c", Severity: "debug"},
+		{Name: "broker", Data: "d", Severity: "debug"},
+	})
+	require.NoError(t, err)
+
+	// Nothing is old enough yet
+	deleted, err := store.Purge(ctx, data.PurgeRule{Match: data.EntryMatch{Severity: "debug"}, Before: written[0].CreatedAt})
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+
+	deleted, err = store.Purge(ctx, data.PurgeRule{
+		Match:  data.EntryMatch{Severity: "debug"},
+		Before: time.Now().Add(time.Second),
+		Except: []data.EntryMatch{{Name: "audit"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	_, err = store.FindOne(ctx, written[0].ID)
+	assert.ErrorIs(t, err, data.ErrNotFound)
+
+	page, err := store.Find(ctx, data.LogQuery{Ascending: true})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 2)
+	assert.Equal(t, "b", page.Entries[0].Data)
+	assert.Equal(t, "c", page.Entries[1].Data)
+
+	// Entries written after a purge are kept with the others
+	_, err = store.Insert(ctx, data.LogEntry{Name: "auth", Data: "e"})
+	require.NoError(t, err)
+	page, err = store.Find(ctx, data.LogQuery{})
+	require.NoError(t, err)
+	assert.Len(t, page.Entries, 3)
+}
+
+func testImportAndDelete(t *testing.T, store data.LogStore) {
+	ctx := context.Background()
+	created := time.Date(2024, 6, 30, 11, 0, 0, 0, time.UTC)
+
+	id := primitive.NewObjectID().Hex()
+	entries := []data.LogEntry{
+		{ID: id, Name: "auth", Data: "old", Attributes: map[string]any{"attempt": float64(3)}, CreatedAt: created, UpdatedAt: created},
+		{ID: primitive.NewObjectID().Hex(), Name: "auth", Data: "older", CreatedAt: created.Add(-time.Hour)},
+	}
+
+	imported, err := store.Import(ctx, entries)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), imported)
+
+	// Entries keep their id and times
+	found, err := store.FindOne(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "old", found.Data)
+	assert.Equal(t, int64(3), found.Attributes["attempt"])
+	assert.True(t, created.Equal(found.CreatedAt))
+
+	page, err := store.Find(ctx, data.LogQuery{Filter: data.LogFilter{To: created.Add(time.Second)}, Ascending: true})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 2)
+	assert.Equal(t, "older", page.Entries[0].Data)
+
+	// Importing again writes nothing
+	imported, err = store.Import(ctx, entries)
+	require.NoError(t, err)
+	assert.Zero(t, imported)
+
+	_, err = store.Import(ctx, []data.LogEntry{{Name: "auth", CreatedAt: created}})
+	assert.ErrorIs(t, err, data.ErrInvalidEntry, "an id is needed")
+	_, err = store.Import(ctx, []data.LogEntry{{ID: primitive.NewObjectID().Hex(), Name: "auth"}})
+	assert.ErrorIs(t, err, data.ErrInvalidEntry, "a creation time is needed")
+
+	deleted, err := store.Delete(ctx, []string{id, "unknown", primitive.NewObjectID().Hex()})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	_, err = store.FindOne(ctx, id)
+	assert.ErrorIs(t, err, data.ErrNotFound)
+}
+
+func testRetentionPolicies(t *testing.T, store data.LogStore) {
+	ctx := context.Background()
+
+	policies, err := store.RetentionPolicies(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, policies)
+
+	set := []data.RetentionPolicy{
+		{ID: "debug", Severity: "debug", Days: 3},
+		{ID: "audit", LogName: "audit", Days: 365},
+	}
+	require.NoError(t, store.SetRetentionPolicies(ctx, set))
+
+	policies, err = store.RetentionPolicies(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, set, policies)
+
+	require.NoError(t, store.SetRetentionPolicies(ctx, nil))
+	policies, err = store.RetentionPolicies(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, policies)
+}
+
+// mustFind returns the only entry matching a filter
+func mustFind(t *testing.T, store data.LogStore, filter data.LogFilter) *data.LogEntry {
+	t.Helper()
+
+	page, err := store.Find(context.Background(), data.LogQuery{Filter: filter})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	return page.Entries[0]
+}
+
+func TestFileStoreReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "logs.ndjson")
+
+	store, err := data.OpenFileStore(path)
+	require.NoError(t, err)
+	stored, err := store.Insert(ctx, data.LogEntry{Name: "auth", Data: "login", Attributes: map[string]any{"attempt": 3}})
+	require.NoError(t, err)
+	_, err = store.InsertMany(ctx, []data.LogEntry{{Name: "auth", Data: "logout"}})
+	require.NoError(t, err)
+	require.NoError(t, store.Close(ctx))
+
+	// A write cut short by a crash leaves half a line behind
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"id":"64b7f0c2a1b2c3d4e5f60718","name":"au`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	store, err = data.OpenFileStore(path)
+	require.NoError(t, err)
+
+	found, err := store.FindOne(ctx, stored.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "login", found.Data)
+	assert.Equal(t, int64(3), found.Attributes["attempt"])
+	assert.True(t, stored.CreatedAt.Equal(found.CreatedAt))
+
+	page, err := store.Find(ctx, data.LogQuery{})
+	require.NoError(t, err)
+	assert.Len(t, page.Entries, 2)
+
+	// Writes go on after the last complete line
+	_, err = store.Insert(ctx, data.LogEntry{Name: "auth", Data: "again"})
+	require.NoError(t, err)
+	require.NoError(t, store.Close(ctx))
+
+	store, err = data.OpenFileStore(path)
+	require.NoError(t, err)
+	defer store.Close(ctx)
+
+	page, err = store.Find(ctx, data.LogQuery{})
+	require.NoError(t, err)
+	assert.Len(t, page.Entries, 3)
+}
+
+func TestFileStoreKeepsPurgesAndPolicies(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "logs.ndjson")
+
+	store, err := data.OpenFileStore(path)
+	require.NoError(t, err)
+	_, err = store.InsertMany(ctx, []data.LogEntry{
+		{Name: "auth", Data: "old", Severity: "debug"},
+		{Name: "auth", Data: "kept", Severity: "error"},
+	})
+	require.NoError(t, err)
+
+	deleted, err := store.Purge(ctx, data.PurgeRule{Match: data.EntryMatch{Severity: "debug"}, Before: time.Now().Add(time.Second)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	_, err = store.Insert(ctx, data.LogEntry{Name: "auth", Data: "new"})
+	require.NoError(t, err)
+	policies := []data.RetentionPolicy{{ID: "debug", Severity: "debug", Days: 3}}
+	require.NoError(t, store.SetRetentionPolicies(ctx, policies))
+	require.NoError(t, store.Close(ctx))
+
+	store, err = data.OpenFileStore(path)
+	require.NoError(t, err)
+	defer store.Close(ctx)
+
+	page, err := store.Find(ctx, data.LogQuery{Ascending: true})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 2)
+	assert.Equal(t, "kept", page.Entries[0].Data)
+	assert.Equal(t, "new", page.Entries[1].Data)
+
+	loaded, err := store.RetentionPolicies(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, policies, loaded)
+}
+
+func TestFileStoreKeepsImportsAndDeletes(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "logs.ndjson")
+	created := time.Date(2024, 6, 30, 11, 0, 0, 0, time.UTC)
+
+	store, err := data.OpenFileStore(path)
+	require.NoError(t, err)
+	stored, err := store.Insert(ctx, data.LogEntry{Name: "auth", Data: "new"})
+	require.NoError(t, err)
+	imported := primitive.NewObjectID().Hex()
+	_, err = store.Import(ctx, []data.LogEntry{{ID: imported, Name: "auth", Data: "old", CreatedAt: created}})
+	require.NoError(t, err)
+	_, err = store.Delete(ctx, []string{stored.ID})
+	require.NoError(t, err)
+	require.NoError(t, store.Close(ctx))
+
+	store, err = data.OpenFileStore(path)
+	require.NoError(t, err)
+	defer store.Close(ctx)
+
+	page, err := store.Find(ctx, data.LogQuery{})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, imported, page.Entries[0].ID)
+	assert.True(t, created.Equal(page.Entries[0].CreatedAt))
+}
+
+func TestFileStoreRefusesCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.ndjson")
+	require.NoError(t, os.WriteFile(path, []byte("not json\n"), 0o644))
+
+	_, err := data.OpenFileStore(path)
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestMongoInsertManyFailureInBatch(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("middle entry fails", func(mt *mtest.T) {
+		store := data.NewMongoStore(mt.Client)
+
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 1, Code: 121, Message: "validation failed"}))
+		written, err := store.InsertMany(context.Background(), []data.LogEntry{
+			{Name: "auth", Data: "first"},
+			{Name: "auth", Data: "second"},
+			{Name: "auth", Data: "third"},
+		})
+		var bulkErr mongo.BulkWriteException
+		require.ErrorAs(t, err, &bulkErr)
+
+		docs, _ := mt.GetStartedEvent().Command.Lookup("documents").Array().Values()
+		require.Len(t, docs, 3)
+
+		require.Len(t, written, 2)
+		assert.Equal(t, "first", written[0].Data)
+		assert.Equal(t, docs[0].Document().Lookup("_id").ObjectID().Hex(), written[0].ID)
+		assert.Equal(t, "third", written[1].Data)
+		assert.Equal(t, docs[2].Document().Lookup("_id").ObjectID().Hex(), written[1].ID)
+	})
+}
+
+func TestMongoCreateIndexes(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("indexes", func(mt *mtest.T) {
+		store := data.NewMongoStore(mt.Client)
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		require.NoError(t, store.CreateIndexes(context.Background()))
+
+		indexes, _ := mt.GetStartedEvent().Command.Lookup("indexes").Array().Values()
+		names := make([]string, 0, len(indexes))
+		for _, index := range indexes {
+			names = append(names, index.Document().Lookup("name").StringValue())
+		}
+		assert.Contains(t, names, "name_1_created_at_-1")
+		assert.Contains(t, names, "source_1_created_at_-1")
+		assert.Contains(t, names, "trace_id_1")
+	})
+}
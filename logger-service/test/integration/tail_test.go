@@ -0,0 +1,184 @@
+package integration
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log-service/api"
+	"log-service/data"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// tailServer serves the routes of an app on a memory store
+func tailServer(t *testing.T) (*api.Config, *httptest.Server, chan struct{}) {
+	api.InitLogger()
+	done := make(chan struct{})
+	app := &api.Config{Models: data.NewModels(data.NewMemoryStore()), Logger: api.Log, JWTSecret: readerSecret, Done: done}
+
+	srv := httptest.NewServer(app.Routes())
+	t.Cleanup(srv.Close)
+	return app, srv, done
+}
+
+// dialTail opens a WebSocket tail
+func dialTail(t *testing.T, srv *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/logs/tail"+query, readerHeader(t))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestTailLogsOverWebSocket(t *testing.T) {
+	app, srv, _ := tailServer(t)
+	conn := dialTail(t, srv, "?name=checkout&severity=error,fatal&source=payment-service")
+
+	_, err := app.Models.LogEntry.InsertMany(context.Background(), []data.LogEntry{
+		{Name: "checkout", Data: "paid", Source: "payment-service"},
+		{Name: "login", Data: "bad password", Severity: "error", Source: "payment-service"},
+		{Name: "checkout", Data: "declined", Severity: "error", Source: "broker-service"},
+		{Name: "checkout", Data: "declined", Severity: "error", Source: "payment-service"},
+	})
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var entry data.LogEntry
+	require.NoError(t, conn.ReadJSON(&entry))
+	assert.Equal(t, "declined", entry.Data)
+	assert.Equal(t, "payment-service", entry.Source)
+	assert.NotEmpty(t, entry.ID)
+}
+
+func TestTailLogsAsServerSentEvents(t *testing.T) {
+	app, srv, _ := tailServer(t)
+
+	resp, err := http.DefaultClient.Do(readRequest(t, srv.URL+"/logs/tail?name=checkout"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	lines := bufio.NewScanner(resp.Body)
+	require.True(t, lines.Scan())
+	assert.Equal(t, ": tailing", lines.Text())
+
+	stored, err := app.Models.LogEntry.Insert(context.Background(), data.LogEntry{Name: "checkout", Data: "paid"})
+	require.NoError(t, err)
+
+	var event []string
+	for lines.Scan() && len(event) < 3 {
+		if lines.Text() != "" {
+			event = append(event, lines.Text())
+		}
+	}
+	require.Len(t, event, 3)
+	assert.Equal(t, "id: "+stored.ID, event[0])
+	assert.Equal(t, "event: log", event[1])
+
+	var entry data.LogEntry
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(event[2], "data: ")), &entry))
+	assert.Equal(t, "paid", entry.Data)
+}
+
+func TestTailLogsDisconnectsSlowClients(t *testing.T) {
+	app, srv, _ := tailServer(t)
+	conn := dialTail(t, srv, "?name=flood")
+
+	// Far more entries at once than a tail may fall behind
+	batch := make([]data.LogEntry, 5000)
+	for i := range batch {
+		batch[i] = data.LogEntry{Name: "flood", Data: strings.Repeat("x", 100)}
+	}
+	_, err := app.Models.LogEntry.InsertMany(context.Background(), batch)
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	received := 0
+	for {
+		if _, _, err = conn.ReadMessage(); err != nil {
+			break
+		}
+		received++
+	}
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "%v", err)
+	assert.Less(t, received, len(batch))
+}
+
+func TestTailLogsEndsOnShutdown(t *testing.T) {
+	_, srv, done := tailServer(t)
+	conn := dialTail(t, srv, "")
+
+	close(done)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "%v", err)
+}
+
+func TestTailLogsRefusesTimeRanges(t *testing.T) {
+	_, srv, _ := tailServer(t)
+
+	for _, query := range []string{"?from=2024-06-30T11:00:00Z", "?to=yesterday"} {
+		resp, err := http.DefaultClient.Do(readRequest(t, srv.URL+"/logs/tail"+query))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+}
+
+func TestWatchChanges(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("standalone server", func(mt *mtest.T) {
+		store := data.NewMongoStore(mt.Client)
+
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    40573,
+			Message: "The $changeStream stage is only supported on replica sets",
+		}))
+		assert.Error(t, store.WatchChanges(context.Background()))
+	})
+
+	mt.Run("entries of every instance", func(mt *mtest.T) {
+		store := data.NewMongoStore(mt.Client)
+		sub := data.Subscribe(data.LogFilter{Name: "checkout"}, 8)
+		defer sub.Close()
+
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(mtest.CreateCursorResponse(1, "logs.logs", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: bson.D{{Key: "_data", Value: "resume-token"}}},
+			{Key: "operationType", Value: "insert"},
+			{Key: "fullDocument", Value: bson.D{
+				{Key: "_id", Value: id},
+				{Key: "name", Value: "checkout"},
+				{Key: "data", Value: "written elsewhere"},
+				{Key: "severity", Value: "info"},
+				{Key: "created_at", Value: time.Now()},
+			}},
+		}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.NoError(t, store.WatchChanges(ctx))
+
+		select {
+		case entry := <-sub.C:
+			assert.Equal(t, id.Hex(), entry.ID)
+			assert.Equal(t, "written elsewhere", entry.Data)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no entry came through the change stream")
+		}
+	})
+}
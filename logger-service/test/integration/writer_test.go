@@ -0,0 +1,242 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"log-service/api"
+	"log-service/data"
+	"log-service/logs"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flushStore counts the batches written to a memory store. When release is
+// set, every batch waits for it first; when err is set, batches fail with it.
+type flushStore struct {
+	*data.MemoryStore
+	batches atomic.Int64
+	release chan struct{}
+	err     error
+}
+
+func newFlushStore() *flushStore {
+	return &flushStore{MemoryStore: data.NewMemoryStore()}
+}
+
+func (s *flushStore) InsertMany(ctx context.Context, entries []data.LogEntry) ([]*data.LogEntry, error) {
+	if s.release != nil {
+		<-s.release
+	}
+	s.batches.Add(1)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.MemoryStore.InsertMany(ctx, entries)
+}
+
+// stored returns how many entries the store holds
+func (s *flushStore) stored(t *testing.T) int {
+	page, err := s.Find(context.Background(), data.LogQuery{Limit: data.MaxPageSize})
+	require.NoError(t, err)
+	return len(page.Entries)
+}
+
+func TestBufferedWriterFlushesOnBatchSize(t *testing.T) {
+	store := newFlushStore()
+	writer := data.NewBufferedWriter(store, data.WriterOptions{BatchSize: 3, FlushInterval: time.Hour})
+	defer writer.Close(context.Background())
+
+	for i := 0; i < 6; i++ {
+		require.NoError(t, writer.Write(data.LogEntry{Name: "auth"}))
+	}
+
+	require.Eventually(t, func() bool { return store.stored(t) == 6 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int64(2), store.batches.Load())
+}
+
+func TestBufferedWriterFlushesOnInterval(t *testing.T) {
+	store := newFlushStore()
+	writer := data.NewBufferedWriter(store, data.WriterOptions{BatchSize: 100, FlushInterval: 20 * time.Millisecond})
+	defer writer.Close(context.Background())
+
+	require.NoError(t, writer.Write(data.LogEntry{Name: "auth"}))
+	require.NoError(t, writer.Write(data.LogEntry{Name: "auth"}))
+
+	require.Eventually(t, func() bool { return store.stored(t) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int64(1), store.batches.Load())
+}
+
+func TestBufferedWriterRefusesWhenFull(t *testing.T) {
+	store := newFlushStore()
+	store.release = make(chan struct{})
+	writer := data.NewBufferedWriter(store, data.WriterOptions{BatchSize: 1, FlushInterval: time.Hour, Capacity: 2})
+
+	// The first entry is taken and held up in the store, two more fill the buffer
+	require.NoError(t, writer.Write(data.LogEntry{Name: "auth"}))
+	require.Eventually(t, func() bool { return writer.Len() == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, writer.Write(data.LogEntry{Name: "auth"}))
+	require.NoError(t, writer.Write(data.LogEntry{Name: "auth"}))
+
+	assert.ErrorIs(t, writer.Write(data.LogEntry{Name: "auth"}), data.ErrBufferFull)
+
+	close(store.release)
+	require.NoError(t, writer.Close(context.Background()))
+	assert.Equal(t, 3, store.stored(t))
+}
+
+func TestBufferedWriterFlushesOnClose(t *testing.T) {
+	store := newFlushStore()
+	writer := data.NewBufferedWriter(store, data.WriterOptions{BatchSize: 100, FlushInterval: time.Hour})
+
+	require.NoError(t, writer.Write(data.LogEntry{Name: "auth"}))
+	require.NoError(t, writer.Close(context.Background()))
+	assert.Equal(t, 1, store.stored(t))
+
+	assert.ErrorIs(t, writer.Write(data.LogEntry{Name: "auth"}), data.ErrWriterClosed)
+	assert.NoError(t, writer.Close(context.Background()), "closing twice is harmless")
+}
+
+func TestBufferedWriterWriteSync(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("stored", func(t *testing.T) {
+		store := newFlushStore()
+		writer := data.NewBufferedWriter(store, data.WriterOptions{BatchSize: 100, FlushInterval: 10 * time.Millisecond})
+		defer writer.Close(ctx)
+
+		require.NoError(t, writer.WriteSync(ctx, data.LogEntry{Name: "auth"}))
+		assert.Equal(t, 1, store.stored(t))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		writer := data.NewBufferedWriter(newFlushStore(), data.WriterOptions{})
+		defer writer.Close(ctx)
+
+		err := writer.WriteSync(ctx, data.LogEntry{Name: "auth", Severity: "loud"})
+		assert.ErrorIs(t, err, data.ErrInvalidEntry)
+	})
+
+	t.Run("store fails", func(t *testing.T) {
+		store := newFlushStore()
+		store.err = errors.New("store is down")
+		writer := data.NewBufferedWriter(store, data.WriterOptions{FlushInterval: 10 * time.Millisecond})
+		defer writer.Close(ctx)
+
+		assert.ErrorIs(t, writer.WriteSync(ctx, data.LogEntry{Name: "auth"}), store.err)
+	})
+
+	t.Run("some entries fail", func(t *testing.T) {
+		store := newFlushStore()
+		store.err = mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Index: 1, Code: 11000}}}}
+		writer := data.NewBufferedWriter(store, data.WriterOptions{BatchSize: 2, FlushInterval: time.Hour})
+		defer writer.Close(ctx)
+
+		// Both entries go in one batch, whose second entry fails
+		results := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() { results <- writer.WriteSync(ctx, data.LogEntry{Name: "auth"}) }()
+		}
+
+		var failed []error
+		for i := 0; i < 2; i++ {
+			if err := <-results; err != nil {
+				failed = append(failed, err)
+			}
+		}
+		require.Len(t, failed, 1)
+		var bulkErr mongo.BulkWriteException
+		assert.ErrorAs(t, failed[0], &bulkErr)
+	})
+
+	t.Run("caller gives up", func(t *testing.T) {
+		store := newFlushStore()
+		writer := data.NewBufferedWriter(store, data.WriterOptions{FlushInterval: time.Hour})
+
+		timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, writer.WriteSync(timeout, data.LogEntry{Name: "auth"}), context.DeadlineExceeded)
+
+		// The entry stays buffered
+		require.NoError(t, writer.Close(ctx))
+		assert.Equal(t, 1, store.stored(t))
+	})
+}
+
+func TestWriteLogThroughBuffer(t *testing.T) {
+	api.InitLogger()
+
+	store := newFlushStore()
+	models := data.NewModels(store)
+	app := &api.Config{Models: models, Logger: api.Log}
+	app.Writer = api.NewWriter(models, data.WriterOptions{BatchSize: 100, FlushInterval: 10 * time.Millisecond, Capacity: 1})
+	defer app.Writer.Close(context.Background())
+
+	rec := postLog(app, `{"name": "auth", "data": "login"}`)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Contains(t, rec.Body.String(), "queued")
+	require.Eventually(t, func() bool { return store.stored(t) == 1 }, time.Second, 5*time.Millisecond)
+
+	rec = postLog(app, `{"name": "auth", "data": "invalid", "severity": "loud"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Waiting callers only get an answer once the entry is stored
+	rec = postLogTo(app, "/log?wait=true", `{"name": "auth", "data": "logout"}`)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Contains(t, rec.Body.String(), "logged")
+	assert.Equal(t, 2, store.stored(t))
+}
+
+func TestWriteLogBufferFull(t *testing.T) {
+	api.InitLogger()
+
+	store := newFlushStore()
+	store.release = make(chan struct{})
+	models := data.NewModels(store)
+	writer := api.NewWriter(models, data.WriterOptions{BatchSize: 1, FlushInterval: time.Hour, Capacity: 1})
+	defer func() {
+		close(store.release)
+		writer.Close(context.Background())
+	}()
+
+	app := &api.Config{Models: models, Logger: api.Log, Writer: writer}
+	client := dialLogServer(t, &api.LogServer{Models: models, Writer: writer})
+
+	// One entry held up in the store, one in the buffer
+	require.NoError(t, writer.Write(data.LogEntry{Name: "auth"}))
+	require.Eventually(t, func() bool { return writer.Len() == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, writer.Write(data.LogEntry{Name: "auth"}))
+
+	rec := postLog(app, `{"name": "auth", "data": "login"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	_, err := client.WriteLog(context.Background(), &logs.LogRequest{LogEntry: &logs.Log{Name: "auth"}})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	var resp string
+	err = (&api.RPCServer{Config: app}).LogInfo(api.RPCPayload{Name: "auth"}, &resp)
+	assert.ErrorIs(t, err, data.ErrBufferFull)
+}
+
+func TestWriteLogOverGRPCWaits(t *testing.T) {
+	api.InitLogger()
+
+	store := newFlushStore()
+	models := data.NewModels(store)
+	writer := api.NewWriter(models, data.WriterOptions{FlushInterval: 10 * time.Millisecond})
+	defer writer.Close(context.Background())
+
+	client := dialLogServer(t, &api.LogServer{Models: models, Writer: writer})
+
+	_, err := client.WriteLog(context.Background(), &logs.LogRequest{LogEntry: &logs.Log{Name: "auth"}, Wait: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.stored(t))
+}
@@ -0,0 +1,74 @@
+package unit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log-service/data"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNormalizeSeverity(t *testing.T) {
+	for input, want := range map[string]string{
+		"":         data.SeverityInfo,
+		"DEBUG":    data.SeverityDebug,
+		" warn ":   data.SeverityWarning,
+		"Warning":  data.SeverityWarning,
+		"error":    data.SeverityError,
+		"fatal":    data.SeverityFatal,
+		"critical": "",
+	} {
+		entry := data.LogEntry{Severity: input}
+		err := entry.Normalize()
+		if want == "" {
+			assert.ErrorIs(t, err, data.ErrInvalidEntry, input)
+			continue
+		}
+		require.NoError(t, err, input)
+		assert.Equal(t, want, entry.Severity, input)
+	}
+}
+
+func TestNormalizeAttributes(t *testing.T) {
+	entry := data.LogEntry{Attributes: map[string]any{
+		"status":   float64(502),
+		"ratio":    0.25,
+		"big":      json.Number("9007199254740993"),
+		"retried":  true,
+		"customer": "c-42",
+		"missing":  nil,
+		"nested":   map[string]any{"attempt": float64(3)},
+		"tags":     primitive.A{"a", float64(1)},
+	}}
+
+	require.NoError(t, entry.Normalize())
+	assert.Equal(t, int64(502), entry.Attributes["status"])
+	assert.Equal(t, 0.25, entry.Attributes["ratio"])
+	assert.Equal(t, int64(9007199254740993), entry.Attributes["big"])
+	assert.Equal(t, true, entry.Attributes["retried"])
+	assert.Nil(t, entry.Attributes["missing"])
+	assert.Equal(t, map[string]any{"attempt": int64(3)}, entry.Attributes["nested"])
+	assert.Equal(t, []any{"a", int64(1)}, entry.Attributes["tags"])
+}
+
+func TestNormalizeRefusesBadAttributes(t *testing.T) {
+	tooMany := map[string]any{}
+	for i := 0; i <= data.MaxAttributes; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = i
+	}
+
+	for name, attributes := range map[string]map[string]any{
+		"dotted name":     {"http.status": 200},
+		"operator name":   {"$where": "1"},
+		"empty name":      {"": "x"},
+		"nested bad name": {"request": map[string]any{"a.b": 1}},
+		"unsupported":     {"channel": make(chan int)},
+		"too many":        tooMany,
+	} {
+		entry := data.LogEntry{Attributes: attributes}
+		assert.ErrorIs(t, entry.Normalize(), data.ErrInvalidEntry, name)
+	}
+}
@@ -0,0 +1,92 @@
+package unit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log-service/data"
+	"log-service/internal/export"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExportFormat(t *testing.T) {
+	for value, want := range map[string]export.Format{
+		"ndjson": export.NDJSON,
+		".jsonl": export.NDJSON,
+		"CSV":    export.CSV,
+		".csv":   export.CSV,
+	} {
+		format, err := export.ParseFormat(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, format, value)
+	}
+
+	_, err := export.ParseFormat("xlsx")
+	assert.Error(t, err)
+
+	assert.Equal(t, "logs.csv.gz", export.Options{Format: export.CSV, Gzip: true}.FileName())
+	assert.Equal(t, "logs.ndjson", export.Options{Format: export.NDJSON}.FileName())
+}
+
+// exportStore returns a memory store holding a few entries
+func exportStore(t *testing.T) data.LogStore {
+	store := data.NewMemoryStore()
+	_, err := store.InsertMany(context.Background(), []data.LogEntry{
+		{Name: "checkout", Data: "declined, twice", Severity: "error", Source: "payment-service",
+			Attributes: map[string]any{"status": 402}},
+		{Name: "login", Data: "welcome"},
+	})
+	require.NoError(t, err)
+	return store
+}
+
+func TestExportCSV(t *testing.T) {
+	var buf bytes.Buffer
+	count, err := export.Export(context.Background(), exportStore(t), &buf, export.Options{Format: export.CSV})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, []string{"id", "created_at", "name", "severity", "source", "host", "trace_id", "span_id", "data", "attributes"}, rows[0])
+	assert.Equal(t, "checkout", rows[1][2])
+	assert.Equal(t, "declined, twice", rows[1][8])
+	assert.JSONEq(t, `{"status": 402}`, rows[1][9])
+	assert.Equal(t, "", rows[2][9])
+}
+
+func TestExportNDJSONGzip(t *testing.T) {
+	var buf bytes.Buffer
+	count, err := export.Export(context.Background(), exportStore(t), &buf, export.Options{
+		Format: export.NDJSON,
+		Gzip:   true,
+		Filter: data.LogFilter{Name: "checkout"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	zr, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	raw, err := io.ReadAll(zr)
+	require.NoError(t, err)
+
+	var entry data.LogEntry
+	require.NoError(t, json.Unmarshal(raw, &entry))
+	assert.Equal(t, "checkout", entry.Name)
+	assert.Equal(t, float64(402), entry.Attributes["status"])
+}
+
+func TestExportNothing(t *testing.T) {
+	var buf bytes.Buffer
+	count, err := export.Export(context.Background(), data.NewMemoryStore(), &buf, export.Options{Format: export.CSV})
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	assert.Equal(t, "id,created_at,name,severity,source,host,trace_id,span_id,data,attributes\n", buf.String())
+}
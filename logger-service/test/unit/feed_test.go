@@ -0,0 +1,45 @@
+package unit
+
+import (
+	"log-service/data"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogFilterMatches(t *testing.T) {
+	now := time.Now()
+	entry := &data.LogEntry{
+		Name:      "payments",
+		Data:      "Card declined: insufficient funds",
+		Severity:  "error",
+		TraceID:   "abc123",
+		CreatedAt: now,
+	}
+
+	tests := []struct {
+		name   string
+		filter data.LogFilter
+		want   bool
+	}{
+		{"empty", data.LogFilter{}, true},
+		{"name", data.LogFilter{Name: "payments"}, true},
+		{"other name", data.LogFilter{Name: "auth"}, false},
+		{"severity", data.LogFilter{Severity: []string{"warning", "error"}}, true},
+		{"other severity", data.LogFilter{Severity: []string{"info"}}, false},
+		{"trace", data.LogFilter{TraceID: "abc123"}, true},
+		{"other trace", data.LogFilter{TraceID: "def456"}, false},
+		{"in range", data.LogFilter{From: now.Add(-time.Minute), To: now.Add(time.Minute)}, true},
+		{"to is exclusive", data.LogFilter{To: now}, false},
+		{"before from", data.LogFilter{From: now.Add(time.Second)}, false},
+		{"any word", data.LogFilter{Search: "refund DECLINED"}, true},
+		{"whole words only", data.LogFilter{Search: "decline"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Matches(entry))
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package unit
+
+import (
+	"log-service/data"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizePolicies(t *testing.T) {
+	policies := []data.RetentionPolicy{
+		{ID: "debug", Severity: "DEBUG", Days: 3},
+		{ID: "warnings", Severity: "warn", Days: 30},
+		{ID: "audit", LogName: "audit", Days: 365},
+	}
+	require.NoError(t, data.NormalizePolicies(policies))
+	assert.Equal(t, "debug", policies[0].Severity)
+	assert.Equal(t, "warning", policies[1].Severity)
+
+	for name, policies := range map[string][]data.RetentionPolicy{
+		"bad id":        {{ID: "Debug Logs", Days: 3}},
+		"no id":         {{Days: 3}},
+		"bad severity":  {{ID: "loud", Severity: "loud", Days: 3}},
+		"no days":       {{ID: "debug", Severity: "debug"}},
+		"too many days": {{ID: "debug", Severity: "debug", Days: data.MaxRetentionDays + 1}},
+		"same id":       {{ID: "a", Severity: "debug", Days: 3}, {ID: "a", Severity: "info", Days: 3}},
+		"same match":    {{ID: "a", Severity: "debug", Days: 3}, {ID: "b", Severity: "debug", Days: 7}},
+	} {
+		assert.ErrorIs(t, data.NormalizePolicies(policies), data.ErrInvalidPolicy, name)
+	}
+}
+
+func TestRetentionRuleLeavesEntriesToMoreSpecificPolicies(t *testing.T) {
+	policies := []data.RetentionPolicy{
+		{ID: "default", Days: 30},
+		{ID: "errors", Severity: "error", Days: 90},
+		{ID: "debug", Severity: "debug", Days: 3},
+		{ID: "audit", LogName: "audit", Days: 365},
+		{ID: "audit-debug", LogName: "audit", Severity: "debug", Days: 7},
+	}
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	rule := policies[0].Rule(policies, now)
+	assert.Equal(t, data.EntryMatch{}, rule.Match)
+	assert.Equal(t, now.AddDate(0, 0, -30), rule.Before)
+	assert.Len(t, rule.Except, 4)
+
+	rule = policies[1].Rule(policies, now)
+	assert.Equal(t, data.EntryMatch{Severity: "error"}, rule.Match)
+	assert.Equal(t, []data.EntryMatch{{Name: "audit"}}, rule.Except)
+
+	rule = policies[2].Rule(policies, now)
+	assert.Equal(t, []data.EntryMatch{{Name: "audit"}, {Name: "audit", Severity: "debug"}}, rule.Except)
+
+	rule = policies[3].Rule(policies, now)
+	assert.Equal(t, []data.EntryMatch{{Name: "audit", Severity: "debug"}}, rule.Except)
+
+	rule = policies[4].Rule(policies, now)
+	assert.Equal(t, data.EntryMatch{Name: "audit", Severity: "debug"}, rule.Match)
+	assert.Empty(t, rule.Except)
+}
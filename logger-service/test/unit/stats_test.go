@@ -0,0 +1,53 @@
+package unit
+
+import (
+	"log-service/data"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsQueryPrepare(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	query := data.StatsQuery{GroupBy: data.GroupBySeverity, Bucket: data.BucketMinute}
+	require.NoError(t, query.Prepare(now))
+	assert.Equal(t, now.Add(-data.DefaultStatsWindow), query.Filter.From)
+	assert.True(t, query.Filter.To.IsZero(), "the window stays open to new entries")
+
+	to := now.Add(-time.Hour)
+	query = data.StatsQuery{Filter: data.LogFilter{To: to}}
+	require.NoError(t, query.Prepare(now))
+	assert.Equal(t, to.Add(-data.DefaultStatsWindow), query.Filter.From)
+
+	for name, query := range map[string]data.StatsQuery{
+		"unknown group":    {GroupBy: "host"},
+		"unknown bucket":   {Bucket: "week"},
+		"too many minutes": {Bucket: data.BucketMinute, Filter: data.LogFilter{From: now.Add(-25 * time.Hour)}},
+		"too many hours":   {Bucket: data.BucketHour, Filter: data.LogFilter{From: now.AddDate(0, 0, -61)}},
+		"too many days":    {Bucket: data.BucketDay, Filter: data.LogFilter{From: now.AddDate(-5, 0, 0), To: now}},
+	} {
+		assert.ErrorIs(t, query.Prepare(now), data.ErrInvalidStats, name)
+	}
+
+	// A long range is fine in bigger buckets or without any
+	query = data.StatsQuery{Bucket: data.BucketDay, Filter: data.LogFilter{From: now.AddDate(0, 0, -90)}}
+	assert.NoError(t, query.Prepare(now))
+	query = data.StatsQuery{Filter: data.LogFilter{From: now.AddDate(-5, 0, 0)}}
+	assert.NoError(t, query.Prepare(now))
+}
+
+func TestPrepareTop(t *testing.T) {
+	now := time.Now()
+
+	var filter data.LogFilter
+	n, err := data.PrepareTop(&filter, 0, now)
+	require.NoError(t, err)
+	assert.Equal(t, data.DefaultTopMessages, n)
+	assert.Equal(t, now.Add(-data.DefaultStatsWindow), filter.From)
+
+	_, err = data.PrepareTop(&filter, data.MaxTopMessages+1, now)
+	assert.ErrorIs(t, err, data.ErrInvalidStats)
+}